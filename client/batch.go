@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Create a new empty Batch for the given Client
+func (c *Client) NewBatch() *Batch {
+	return &Batch{
+		client:   c,
+		requests: []common.Request{},
+		calls:    []*BatchCall{},
+	}
+}
+
+// Add a call to the batch, the returned handle gets the response once the batch is sent
+func (b *Batch) Call(method string, params interface{}) (*BatchCall, error) {
+	if b.sent {
+		return nil, fmt.Errorf("batch already sent")
+	}
+
+	request, err := b.client.NewJsonRpcRequest(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &BatchCall{
+		Request: request,
+		Err:     fmt.Errorf("batch not sent"),
+	}
+	b.requests = append(b.requests, request)
+	b.calls = append(b.calls, call)
+
+	return call, nil
+}
+
+// Add a notification (a request with no id) to the batch
+func (b *Batch) Notify(method string, params interface{}) error {
+	if b.sent {
+		return fmt.Errorf("batch already sent")
+	}
+
+	request, err := newJsonRpcRequest(nil, method, params)
+	if err != nil {
+		return err
+	}
+	b.requests = append(b.requests, request)
+
+	return nil
+}
+
+// Get the number of requests in the batch
+func (b *Batch) Len() int {
+	return len(b.requests)
+}
+
+// Send the batch through the client's transport and distribute the responses among the calls
+// A transport error (or the rejection of the whole batch by the server) is returned and also set for every call of the batch
+func (b *Batch) Send(ctx context.Context) error {
+	if b.sent {
+		return fmt.Errorf("batch already sent")
+	}
+
+	if len(b.requests) == 0 {
+		return fmt.Errorf("empty batch")
+	}
+
+	b.sent = true
+
+	rc := common.EmptyRequestContext()
+	rc.Logger = b.client.logger
+	rc.Ctx = ctx
+
+	var err error
+	if rc.RawRequest, err = json.Marshal(b.requests); err != nil {
+		b.fail(err)
+		return err
+	}
+
+	if err = b.client.T.PerformRequest(&rc); err != nil {
+		b.fail(err)
+		return err
+	}
+
+	// A batch consisting of notifications only gets no response
+	if len(b.calls) == 0 {
+		return nil
+	}
+
+	return b.distribute(rc.RawResponse)
+}
+
+// Match the raw batch response to the calls by id
+func (b *Batch) distribute(rawResponse json.RawMessage) error {
+	var responses []common.Response
+
+	if err := json.Unmarshal(rawResponse, &responses); err != nil {
+		// The server may reject the whole batch with a single response
+		var single common.Response
+		if json.Unmarshal(rawResponse, &single) != nil {
+			b.fail(err)
+			return err
+		}
+
+		err = fmt.Errorf("batch rejected by the server: %s", string(single.Error))
+		b.fail(err)
+		return err
+	}
+
+	byId := map[string][]common.Response{}
+	for _, response := range responses {
+		byId[response.Id] = append(byId[response.Id], response)
+	}
+
+	for _, call := range b.calls {
+		matched := byId[call.Request.Id]
+
+		switch len(matched) {
+		case 0:
+			call.Err = fmt.Errorf("no response for id %s", call.Request.Id)
+		case 1:
+			call.Response = matched[0]
+			call.Err = nil
+		default:
+			call.Err = fmt.Errorf("duplicate responses for id %s", call.Request.Id)
+		}
+	}
+
+	return nil
+}
+
+// Set the same error for all calls of the batch
+func (b *Batch) fail(err error) {
+	for _, call := range b.calls {
+		call.Err = err
+	}
+}
+
+// Get the response of the call (or an error if no proper response was received)
+func (call *BatchCall) Result() (common.Response, error) {
+	return call.Response, call.Err
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+type test_BatchHandler struct{}
+
+func (h test_BatchHandler) Handle_pass(params test_ClientPassParams) (result test_ClientPassResult, jsonRpcError common.Error, err error) {
+	result.Value = params.Name
+	return
+}
+
+// Fail with the error of the request context
+type test_ContextTransport struct {
+	transport.Discard
+}
+
+func (t *test_ContextTransport) PerformRequest(rc *common.RequestContext) error {
+	return rc.GetContext().Err()
+}
+
+func TestBatch_Send(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_BatchHandler{}, "Handle_")
+
+	c := New()
+	_ = c.SetTransport(&transport.Local{Server: s})
+
+	b := c.NewBatch()
+	c1, _ := b.Call("pass", test_ClientPassParams{"lol"})
+	c2, _ := b.Call("nope", test_ClientPassParams{"kek"})
+	_ = b.Notify("pass", test_ClientPassParams{"cheburek"})
+
+	if b.Len() != 3 {
+		t.Errorf("wrong batch length %d", b.Len())
+	}
+
+	if _, err := c1.Result(); err == nil {
+		t.Errorf("result of an unsent batch was returned")
+	}
+
+	if err := b.Send(context.Background()); err != nil {
+		t.Fatalf("batch was not sent: %s", err.Error())
+	}
+
+	r1, err := c1.Result()
+	if err != nil {
+		t.Errorf("first call failed: %s", err.Error())
+	} else if string(r1.Result) != `{"value":"lol"}` {
+		t.Errorf("wrong result of the first call %s", string(r1.Result))
+	}
+
+	r2, err := c2.Result()
+	if err != nil {
+		t.Errorf("second call failed: %s", err.Error())
+	} else if string(r2.Error) != `{"code":-32601,"message":"Method not found"}` {
+		t.Errorf("wrong error of the second call %s", string(r2.Error))
+	}
+
+	if b.Send(context.Background()) == nil {
+		t.Errorf("batch was sent twice")
+	}
+
+	if _, err := b.Call("pass", nil); err == nil {
+		t.Errorf("call was added to a sent batch")
+	}
+}
+
+func TestBatch_Send_Matching(t *testing.T) {
+	c := New()
+	b := c.NewBatch()
+
+	c1, _ := b.Call("a", nil)
+	c2, _ := b.Call("b", nil)
+	c3, _ := b.Call("c", nil)
+	c4, _ := b.Call("d", nil)
+
+	// Out of order, c2 duplicated, c3 missing
+	_ = c.SetTransport(&transport.Error{
+		RawResponse: []byte(fmt.Sprintf(
+			`[{"jsonrpc":"2.0","id":"%s","result":4},{"jsonrpc":"2.0","id":"%s","result":2},{"jsonrpc":"2.0","id":"%s","result":1},{"jsonrpc":"2.0","id":"%s","result":2}]`,
+			c4.Request.Id, c2.Request.Id, c1.Request.Id, c2.Request.Id,
+		)),
+	})
+
+	if err := b.Send(context.Background()); err != nil {
+		t.Fatalf("batch was not sent: %s", err.Error())
+	}
+
+	if r, err := c1.Result(); err != nil || string(r.Result) != "1" {
+		t.Errorf("first call was not matched")
+	}
+
+	if _, err := c2.Result(); err == nil {
+		t.Errorf("duplicate response was accepted")
+	}
+
+	if _, err := c3.Result(); err == nil {
+		t.Errorf("missing response was not detected")
+	}
+
+	if r, err := c4.Result(); err != nil || string(r.Result) != "4" {
+		t.Errorf("fourth call was not matched")
+	}
+}
+
+func TestBatch_Send_Error(t *testing.T) {
+	c := New()
+
+	if c.NewBatch().Send(context.Background()) == nil {
+		t.Errorf("empty batch was sent")
+	}
+
+	_ = c.SetTransport(&transport.Error{ErrorMessage: "KEK"})
+
+	b := c.NewBatch()
+	c1, _ := b.Call("a", nil)
+
+	if err := b.Send(context.Background()); err == nil || err.Error() != "KEK" {
+		t.Errorf("transport error was not returned")
+	}
+
+	if _, err := c1.Result(); err == nil || err.Error() != "KEK" {
+		t.Errorf("transport error was not set for the call")
+	}
+
+	_ = c.SetTransport(&transport.Error{
+		RawResponse: []byte(`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"}}`),
+	})
+
+	b = c.NewBatch()
+	c1, _ = b.Call("a", nil)
+
+	err := b.Send(context.Background())
+	if err == nil || err.Error() != `batch rejected by the server: {"code":-32600,"message":"Invalid request"}` {
+		t.Errorf("batch rejection was not returned: %v", err)
+	}
+
+	if _, callErr := c1.Result(); callErr == nil || callErr.Error() != err.Error() {
+		t.Errorf("batch rejection was not set for the call")
+	}
+
+	// The context is passed to the transport
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_ = c.SetTransport(&test_ContextTransport{})
+	b = c.NewBatch()
+	_, _ = b.Call("a", nil)
+
+	if err := b.Send(ctx); err != context.Canceled {
+		t.Errorf("context was not passed to the transport: %v", err)
+	}
+}
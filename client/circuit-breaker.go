@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// The error returned by an open circuit breaker without calling the wrapped transport
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+
+	return "unknown"
+}
+
+// Wrap a transport into a circuit breaker
+func NewCircuitBreaker(next Transport, settings BreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{
+		Next:     next,
+		Settings: settings,
+		breakers: map[string]*breaker{},
+		now:      time.Now,
+	}
+}
+
+func (cb *CircuitBreaker) PerformRequest(rc *common.RequestContext) error {
+	key := cb.key(rc)
+
+	if err := cb.allow(key); err != nil {
+		return err
+	}
+
+	err := cb.Next.PerformRequest(rc)
+
+	// A request given up by the caller tells nothing about the server
+	if err != nil && rc.GetContext().Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		cb.release(key)
+		return err
+	}
+
+	cb.record(key, err != nil || cb.isFailureResponse(rc))
+
+	return err
+}
+
+func (cb *CircuitBreaker) AddPreProcessingStage(stage common.Stage) {
+	cb.Next.AddPreProcessingStage(stage)
+}
+
+func (cb *CircuitBreaker) AddPostProcessingStage(stage common.Stage) {
+	cb.Next.AddPostProcessingStage(stage)
+}
+
+func (cb *CircuitBreaker) SetLogger(l *log.Logger) error {
+	return cb.Next.SetLogger(l)
+}
+
+// Get the state of the breaker for the given method (the method is ignored unless PerMethod is set)
+func (cb *CircuitBreaker) State(method string) BreakerState {
+	if !cb.Settings.PerMethod {
+		method = ""
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if b, ok := cb.breakers[method]; ok {
+		cb.refresh(b)
+		return b.state
+	}
+
+	return BreakerClosed
+}
+
+// Get the states of all known breakers keyed by method ("" for the whole transport)
+func (cb *CircuitBreaker) States() map[string]BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	r := make(map[string]BreakerState, len(cb.breakers))
+	for key, b := range cb.breakers {
+		cb.refresh(b)
+		r[key] = b.state
+	}
+
+	return r
+}
+
+// Get the breaker key for the request
+func (cb *CircuitBreaker) key(rc *common.RequestContext) string {
+	if cb.Settings.PerMethod {
+		return rc.JsonRpcRequest.Method
+	}
+
+	return ""
+}
+
+// Get (or create) the breaker for the key, must be called under lock
+func (cb *CircuitBreaker) get(key string) *breaker {
+	if cb.breakers == nil {
+		cb.breakers = map[string]*breaker{}
+	}
+
+	b, ok := cb.breakers[key]
+	if !ok {
+		b = &breaker{}
+		cb.breakers[key] = b
+	}
+
+	return b
+}
+
+func (cb *CircuitBreaker) clock() time.Time {
+	if cb.now == nil {
+		return time.Now()
+	}
+
+	return cb.now()
+}
+
+// Move an open breaker to half-open once the open timeout passes, must be called under lock
+func (cb *CircuitBreaker) refresh(b *breaker) {
+	if b.state == BreakerOpen && cb.clock().Sub(b.openedAt) >= cb.Settings.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+}
+
+// Check if a request may go through
+func (cb *CircuitBreaker) allow(key string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.get(key)
+	cb.refresh(b)
+
+	switch b.state {
+	case BreakerOpen:
+		return cb.openError(key)
+	case BreakerHalfOpen:
+		limit := cb.Settings.HalfOpenRequests
+		if limit <= 0 {
+			limit = 1
+		}
+		if b.probes >= limit {
+			return cb.openError(key)
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+func (cb *CircuitBreaker) openError(key string) error {
+	if key == "" {
+		return ErrCircuitOpen
+	}
+
+	return fmt.Errorf("%w: %s", ErrCircuitOpen, key)
+}
+
+// Record the outcome of a request and trip or reset the breaker
+func (cb *CircuitBreaker) record(key string, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.get(key)
+	now := cb.clock()
+
+	// Requests sent before the breaker opened do not keep it open
+	if b.state == BreakerOpen {
+		return
+	}
+
+	if b.state == BreakerHalfOpen {
+		if failed {
+			cb.trip(b, now)
+		} else {
+			*b = breaker{}
+		}
+		return
+	}
+
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	if cb.Settings.ErrorRate > 0 {
+		b.events = append(b.events, breakerEvent{now, failed})
+
+		// Drop the events that left the window
+		i := 0
+		for i < len(b.events) && now.Sub(b.events[i].at) > cb.Settings.Window {
+			i++
+		}
+		b.events = b.events[i:]
+	}
+
+	if cb.Settings.ConsecutiveFailures > 0 && b.consecutive >= cb.Settings.ConsecutiveFailures {
+		cb.trip(b, now)
+		return
+	}
+
+	if cb.Settings.ErrorRate > 0 && len(b.events) > 0 && len(b.events) >= cb.Settings.MinRequests {
+		failures := 0
+		for _, e := range b.events {
+			if e.failed {
+				failures++
+			}
+		}
+
+		if float64(failures)/float64(len(b.events)) >= cb.Settings.ErrorRate {
+			cb.trip(b, now)
+		}
+	}
+}
+
+// Give back the probe slot of a request that ended without an outcome
+func (cb *CircuitBreaker) release(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if b := cb.get(key); b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// Open the breaker, must be called under lock
+func (cb *CircuitBreaker) trip(b *breaker, now time.Time) {
+	*b = breaker{
+		state:    BreakerOpen,
+		openedAt: now,
+	}
+}
+
+// Check if the raw response carries one of the failure error codes
+func (cb *CircuitBreaker) isFailureResponse(rc *common.RequestContext) bool {
+	if len(cb.Settings.FailureCodes) == 0 || rc.RawResponse == nil {
+		return false
+	}
+
+	response := common.Response{}
+	if json.Unmarshal(rc.RawResponse, &response) != nil || response.Error == nil {
+		return false
+	}
+
+	e := common.Error{}
+	if json.Unmarshal(response.Error, &e) != nil {
+		return false
+	}
+
+	for _, code := range cb.Settings.FailureCodes {
+		if code == e.Code {
+			return true
+		}
+	}
+
+	return false
+}
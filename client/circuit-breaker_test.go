@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	failing := &transport.Error{ErrorMessage: "KEK"}
+	cb := NewCircuitBreaker(failing, BreakerSettings{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Minute,
+	})
+
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	c := New()
+	_ = c.SetTransport(cb)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Request("test", nil); err == nil || err.Error() != "KEK" {
+			t.Errorf("%d transport error was not passed through", i)
+		}
+	}
+
+	if cb.State("test") != BreakerOpen {
+		t.Errorf("breaker was not tripped, it is %s", cb.State(""))
+	}
+
+	if _, err := c.Request("test", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("open breaker did not fail fast")
+	}
+
+	// Let a probe through, it fails and the breaker opens again
+	now = now.Add(time.Minute)
+	if cb.State("") != BreakerHalfOpen {
+		t.Errorf("breaker did not become half-open")
+	}
+
+	if _, err := c.Request("test", nil); err == nil || err.Error() != "KEK" {
+		t.Errorf("probe was not let through")
+	}
+	if cb.State("") != BreakerOpen {
+		t.Errorf("failed probe did not open the breaker")
+	}
+
+	// Successful probe closes the breaker
+	now = now.Add(time.Minute)
+	cb.Next = &transport.Local{Server: server.NewServer()}
+
+	if _, err := c.Request("test", nil); err != nil {
+		t.Errorf("probe failed: %s", err.Error())
+	}
+	if cb.State("") != BreakerClosed {
+		t.Errorf("successful probe did not close the breaker")
+	}
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	cb := NewCircuitBreaker(&transport.Local{Server: server.NewServer()}, BreakerSettings{
+		ErrorRate:    0.5,
+		Window:       time.Minute,
+		MinRequests:  4,
+		OpenTimeout:  time.Minute,
+		PerMethod:    true,
+		FailureCodes: []json.Number{common.MethodNotFoundError.Code},
+	})
+
+	c := New()
+	_ = c.SetTransport(cb)
+
+	ok := &transport.Error{JsonRpcError: common.Error{Code: "1", Message: "not a failure"}}
+	failing := &transport.Local{Server: server.NewServer()}
+
+	for _, next := range []Transport{ok, ok, failing} {
+		cb.Next = next
+		_, _ = c.Request("test", nil)
+	}
+
+	if cb.State("test") != BreakerClosed {
+		t.Errorf("breaker was tripped before reaching minimal number of requests")
+	}
+
+	_, _ = c.Request("test", nil)
+
+	if cb.State("test") != BreakerOpen {
+		t.Errorf("breaker was not tripped by error rate")
+	}
+
+	if cb.State("other") != BreakerClosed {
+		t.Errorf("breaker was tripped for another method")
+	}
+
+	if states := cb.States(); len(states) != 1 || states["test"] != BreakerOpen {
+		t.Errorf("wrong breaker states %v", states)
+	}
+}
+
+// Transport failing with the error of the request context
+type test_CancelledTransport struct {
+	transport.Discard
+}
+
+func (t *test_CancelledTransport) PerformRequest(rc *common.RequestContext) error {
+	return rc.GetContext().Err()
+}
+
+func TestCircuitBreaker_OpenTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(&transport.Error{ErrorMessage: "KEK"}, BreakerSettings{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+	})
+
+	now := time.Now()
+	cb.now = func() time.Time { return now }
+
+	rc := common.EmptyRequestContext()
+	_ = cb.PerformRequest(&rc)
+
+	// Failures of the requests sent before the breaker opened do not move the open timeout
+	now = now.Add(30 * time.Second)
+	cb.record("", true)
+	cb.record("", true)
+
+	now = now.Add(30 * time.Second)
+	if cb.State("") != BreakerHalfOpen {
+		t.Errorf("breaker did not become half-open, it is %s", cb.State(""))
+	}
+
+	// A probe cancelled by the caller is neither a failure nor a success, it gives the slot back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cb.Next = &test_CancelledTransport{}
+	rc = common.EmptyRequestContext()
+	rc.Ctx = ctx
+	if err := cb.PerformRequest(&rc); err != context.Canceled {
+		t.Errorf("cancelled request was not let through: %v", err)
+	}
+	if cb.State("") != BreakerHalfOpen {
+		t.Errorf("cancelled probe changed the breaker to %s", cb.State(""))
+	}
+
+	cb.Next = &transport.Local{Server: server.NewServer()}
+	rc = common.EmptyRequestContext()
+	if err := cb.PerformRequest(&rc); err != nil {
+		t.Errorf("probe slot was not given back: %v", err)
+	}
+	if cb.State("") != BreakerClosed {
+		t.Errorf("successful probe did not close the breaker")
+	}
+}
+
+func TestCircuitBreaker_CallerCancellation(t *testing.T) {
+	cb := NewCircuitBreaker(&test_CancelledTransport{}, BreakerSettings{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	rc := common.EmptyRequestContext()
+	rc.Ctx = ctx
+	if err := cb.PerformRequest(&rc); err != context.DeadlineExceeded {
+		t.Errorf("expired request was not let through: %v", err)
+	}
+
+	if cb.State("") != BreakerClosed {
+		t.Errorf("expired context of the caller tripped the breaker")
+	}
+}
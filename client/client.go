@@ -1,11 +1,11 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 
 	"github.com/yekhlakov/gojsonrpc/client/transport"
 	"github.com/yekhlakov/gojsonrpc/common"
@@ -16,6 +16,7 @@ func New() *Client {
 	return &Client{
 		T:      &transport.Discard{},
 		logger: log.New(ioutil.Discard, "", 0),
+		ids:    &RandomIDGenerator{},
 	}
 }
 
@@ -39,26 +40,60 @@ func (c *Client) SetLogger(l *log.Logger) error {
 	return c.T.SetLogger(l)
 }
 
+// Set the generator of request ids
+func (c *Client) SetIDGenerator(g IDGenerator) error {
+	if g == nil {
+		return fmt.Errorf("nil id generator not allowed")
+	}
+
+	c.ids = g
+	return nil
+}
+
+// Set the retry policy of the client, nil disables retries
+func (c *Client) SetRetryPolicy(p *RetryPolicy) {
+	c.retry = p
+}
+
 // Do the request
 func (c *Client) PerformRequest(rc *common.RequestContext) error {
 	if err := rc.RebuildRawRequest(); err != nil {
 		return err
 	}
-	if err := c.T.PerformRequest(rc); err != nil {
-		return err
-	}
-	if err := rc.ParseRawResponse(); err != nil {
-		return err
-	}
 
-	return nil
+	return c.performWithRetry(rc)
 }
 
-// Create a new Json-Rpc Request
+// Create a new Json-Rpc Request with an id from the default generator
 func NewJsonRpcRequest(method string, params interface{}) (common.Request, error) {
+	return newJsonRpcRequest(defaultIDGenerator, method, params)
+}
+
+// Create a new Json-Rpc Request with an id from the client's generator
+func (c *Client) NewJsonRpcRequest(method string, params interface{}) (common.Request, error) {
+	if c.ids == nil {
+		return NewJsonRpcRequest(method, params)
+	}
+
+	return newJsonRpcRequest(c.ids, method, params)
+}
+
+// Create a new Json-Rpc Request, nil id generator means a notification
+func newJsonRpcRequest(ids IDGenerator, method string, params interface{}) (common.Request, error) {
+	id := ""
+	if ids != nil {
+		var err error
+		if id, err = ids.NextID(); err != nil {
+			return common.Request{}, err
+		}
+		if id == "" {
+			return common.Request{}, fmt.Errorf("empty request id generated")
+		}
+	}
+
 	r := common.Request{
 		JsonRPC: "2.0",
-		Id:      fmt.Sprintf("%8.8x%8.8x", rand.Uint64(), rand.Uint64()),
+		Id:      id,
 		Method:  method,
 		Params:  nil,
 	}
@@ -75,16 +110,22 @@ func NewJsonRpcRequest(method string, params interface{}) (common.Request, error
 func (c *Client) NewRequestContext(method string, params interface{}) (rc common.RequestContext, err error) {
 	rc = common.EmptyRequestContext()
 	rc.Logger = c.logger
-	rc.JsonRpcRequest, err = NewJsonRpcRequest(method, params)
+	rc.JsonRpcRequest, err = c.NewJsonRpcRequest(method, params)
 	return
 }
 
 // The main entry point for request processing
 func (c *Client) Request(method string, params interface{}) (response common.Response, err error) {
+	return c.RequestWithContext(context.Background(), method, params)
+}
+
+// Perform a request that is abandoned (and cancelled, if the transport supports it) once the context is done
+func (c *Client) RequestWithContext(ctx context.Context, method string, params interface{}) (response common.Response, err error) {
 	rc, err := c.NewRequestContext(method, params)
 	if err != nil {
 		return
 	}
+	rc.Ctx = ctx
 
 	err = c.PerformRequest(&rc)
 	if err != nil {
@@ -93,3 +134,18 @@ func (c *Client) Request(method string, params interface{}) (response common.Res
 
 	return rc.JsonRpcResponse, nil
 }
+
+// Send a notification, that is a request with no id
+// No response is expected so nothing is parsed, only local and transport errors are returned
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	rc := common.EmptyRequestContext()
+	rc.Logger = c.logger
+	rc.Ctx = ctx
+
+	var err error
+	if rc.JsonRpcRequest, err = newJsonRpcRequest(nil, method, params); err != nil {
+		return err
+	}
+
+	return c.PerformRequest(&rc)
+}
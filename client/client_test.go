@@ -1,9 +1,15 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/yekhlakov/gojsonrpc/client/transport"
 	"github.com/yekhlakov/gojsonrpc/common"
@@ -185,3 +191,54 @@ func TestClient_Request(t *testing.T) {
 		t.Errorf("got wrong error from the server %s, %s", e.Code, e.Message)
 	}
 }
+
+func TestClient_Notify(t *testing.T) {
+	var body []byte
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer httpServer.Close()
+
+	c := New()
+	_ = c.SetTransport(&transport.Http{Url: httpServer.URL})
+
+	err := c.Notify(context.Background(), "pass", test_ClientPassParams{"qwer"})
+	if err != nil {
+		t.Errorf("notification failed: %s", err.Error())
+	} else if string(body) != `{"jsonrpc":"2.0","method":"pass","params":{"name":"qwer"}}` {
+		t.Errorf("wrong notification was sent: %s", string(body))
+	}
+
+	_ = c.SetTransport(&transport.Local{Server: &server.JsonRpcServer{}})
+
+	if err := c.Notify(context.Background(), "pass", test_ClientPassParams{"qwer"}); err != nil {
+		t.Errorf("notification failed: %s", err.Error())
+	}
+
+	_ = c.SetTransport(&transport.Error{ErrorMessage: "KEK"})
+
+	if err := c.Notify(context.Background(), "pass", nil); err == nil {
+		t.Errorf("transport error was not returned")
+	}
+}
+
+func TestClient_RequestWithContext(t *testing.T) {
+	release := make(chan struct{})
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer httpServer.Close()
+	defer close(release)
+
+	c := New()
+	_ = c.SetTransport(&transport.Http{Url: httpServer.URL})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.RequestWithContext(ctx, "pass", test_ClientPassParams{"qwer"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("request was not abandoned: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The generator used for requests created outside of a Client
+var defaultIDGenerator IDGenerator = &RandomIDGenerator{}
+
+func (g *SequentialIDGenerator) NextID() (string, error) {
+	return strconv.FormatUint(atomic.AddUint64(&g.last, 1), 10), nil
+}
+
+func (g UUIDv4Generator) NextID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+
+	return formatUUID(u, 4), nil
+}
+
+func (g UUIDv7Generator) NextID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		return "", err
+	}
+
+	// 48 bits of unix milliseconds go first
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(u[:6], ts[2:])
+
+	return formatUUID(u, 7), nil
+}
+
+// Set version and variant bits of the UUID and format it in the canonical way
+func formatUUID(u [16]byte, version byte) string {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func (g RandomIDGenerator) NextID() (string, error) {
+	size := g.Size
+	if size <= 0 {
+		size = 16
+	}
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (g *FixedIDGenerator) NextID() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next >= len(g.Ids) {
+		return "", fmt.Errorf("fixed id sequence exhausted")
+	}
+
+	g.next++
+	return g.Ids[g.next-1], nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+)
+
+func TestSequentialIDGenerator_NextID(t *testing.T) {
+	g := SequentialIDGenerator{}
+
+	for _, expect := range []string{"1", "2", "3"} {
+		if id, _ := g.NextID(); id != expect {
+			t.Errorf("expected id %s, got %s", expect, id)
+		}
+	}
+}
+
+func TestUUIDGenerator_NextID(t *testing.T) {
+	testData := []struct {
+		Generator IDGenerator
+		Pattern   string
+	}{
+		{UUIDv4Generator{}, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{UUIDv7Generator{}, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{RandomIDGenerator{}, `^[0-9a-f]{32}$`},
+		{RandomIDGenerator{Size: 4}, `^[0-9a-f]{8}$`},
+	}
+
+	for k, data := range testData {
+		re := regexp.MustCompile(data.Pattern)
+
+		id1, err := data.Generator.NextID()
+		if err != nil {
+			t.Errorf("%d could not generate id: %s", k, err.Error())
+			continue
+		}
+		id2, _ := data.Generator.NextID()
+
+		if !re.MatchString(id1) {
+			t.Errorf("%d wrong id format %s", k, id1)
+		}
+		if id1 == id2 {
+			t.Errorf("%d same id generated twice", k)
+		}
+	}
+}
+
+func TestFixedIDGenerator_NextID(t *testing.T) {
+	g := FixedIDGenerator{Ids: []string{"lol", "kek"}}
+
+	if id, _ := g.NextID(); id != "lol" {
+		t.Errorf("wrong first id %s", id)
+	}
+	if id, _ := g.NextID(); id != "kek" {
+		t.Errorf("wrong second id %s", id)
+	}
+	if _, err := g.NextID(); err == nil {
+		t.Errorf("exhausted sequence produced an id")
+	}
+}
+
+func TestClient_SetIDGenerator(t *testing.T) {
+	c := New()
+
+	if c.SetIDGenerator(nil) == nil {
+		t.Errorf("nil id generator was accepted")
+	}
+
+	_ = c.SetIDGenerator(&FixedIDGenerator{Ids: []string{"lol", "", "kek"}})
+	_ = c.SetTransport(&transport.Discard{})
+
+	rc, err := c.NewRequestContext("test", nil)
+	if err != nil || rc.JsonRpcRequest.Id != "lol" {
+		t.Errorf("id was not taken from the generator")
+	}
+
+	// Notifications do not consume ids
+	b := c.NewBatch()
+	_ = b.Notify("test", nil)
+
+	if _, err = c.NewRequestContext("test", nil); err == nil {
+		t.Errorf("empty id was accepted")
+	}
+
+	if call, _ := b.Call("test", nil); call == nil || call.Request.Id != "kek" {
+		t.Errorf("batch call id was not taken from the generator")
+	}
+}
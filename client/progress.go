@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/progress"
+)
+
+// Get the progress handlers of the client, listening to the notifications of its transport
+// Returns false if the transport does not deliver notifications
+func (c *Client) progressHandlers() (*progressHandlers, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	source, ok := c.T.(NotificationSource)
+	if !ok {
+		return nil, false
+	}
+
+	if c.progress == nil || c.progress.transport != source {
+		c.progress = &progressHandlers{
+			transport: source,
+			handlers:  map[string]ProgressHandler{},
+		}
+		source.OnNotification(progress.Method, c.progress.dispatch)
+	}
+
+	return c.progress, true
+}
+
+// Route a progress notification to the handler of its call
+func (ph *progressHandlers) dispatch(n common.Request) {
+	params := progress.Params{}
+	if json.Unmarshal(n.Params, &params) != nil {
+		return
+	}
+
+	token := ""
+	if json.Unmarshal(params.Token, &token) != nil {
+		return
+	}
+
+	ph.mu.Lock()
+	h, ok := ph.handlers[token]
+	ph.mu.Unlock()
+
+	if ok {
+		h(params.Value)
+	}
+}
+
+// Add the progress token to the params, which must be an object (or nothing)
+func withProgressToken(params interface{}, token string) (map[string]json.RawMessage, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if trimmed := bytes.TrimSpace(raw); string(trimmed) != "null" {
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return nil, fmt.Errorf("progress reporting requires params to be an object")
+		}
+		if err = json.Unmarshal(trimmed, &fields); err != nil {
+			return nil, err
+		}
+	}
+
+	fields[progress.TokenField], _ = json.Marshal(token)
+	return fields, nil
+}
+
+// Call a method passing its progress reports to the handler
+// The reports only come through transports delivering notifications; with other transports
+// the call is made as usual and the handler is never called
+func (c *Client) RequestWithProgress(ctx context.Context, method string, params interface{}, h ProgressHandler) (response common.Response, err error) {
+	if h == nil {
+		return response, fmt.Errorf("nil progress handler not allowed")
+	}
+
+	if ph, ok := c.progressHandlers(); ok {
+		token := ""
+		if token, err = defaultIDGenerator.NextID(); err != nil {
+			return
+		}
+
+		if params, err = withProgressToken(params, token); err != nil {
+			return
+		}
+
+		ph.mu.Lock()
+		ph.handlers[token] = h
+		ph.mu.Unlock()
+
+		defer func() {
+			ph.mu.Lock()
+			delete(ph.handlers, token)
+			ph.mu.Unlock()
+		}()
+	}
+
+	rc, err := c.NewRequestContext(method, params)
+	if err != nil {
+		return
+	}
+	rc.Ctx = ctx
+
+	if err = c.PerformRequest(&rc); err != nil {
+		return
+	}
+
+	return rc.JsonRpcResponse, nil
+}
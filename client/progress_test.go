@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/progress"
+	"github.com/yekhlakov/gojsonrpc/server"
+	servertransport "github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+type test_ExportHandler struct{}
+
+func (h test_ExportHandler) Handle_export(rc *common.RequestContext, params struct {
+	Items int `json:"items"`
+}) (result int, jsonRpcError common.Error, err error) {
+	p := progress.From(rc)
+	for i := 1; i <= params.Items; i++ {
+		_ = p.Update(int64(i), int64(params.Items), "exporting")
+	}
+	return params.Items, common.Error{}, nil
+}
+
+func TestClient_RequestWithProgress(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_ExportHandler{}, "Handle_")
+	s.PreProcessingStages = append(s.PreProcessingStages, progress.Stage(0))
+
+	st, err := servertransport.NewTcpTransport("127.0.0.1:0", s, framing.Newline{})
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	st.Start()
+	defer func() { _ = st.Shutdown(context.Background()) }()
+
+	ct := transport.NewTcp(st.Addr().String(), framing.Newline{})
+	defer func() { _ = ct.Close() }()
+
+	c := New()
+	_ = c.SetTransport(ct)
+
+	var values []progress.Value
+	response, err := c.RequestWithProgress(context.Background(), "export", map[string]int{"items": 3}, func(raw json.RawMessage) {
+		v := progress.Value{}
+		_ = json.Unmarshal(raw, &v)
+		values = append(values, v)
+	})
+
+	if err != nil || string(response.Result) != "3" {
+		t.Fatalf("Call failed: %v %s", err, string(response.Result))
+	}
+
+	// Notifications are written before the response on the same connection
+	if len(values) != 3 || values[2].Done != 3 || values[2].Total != 3 {
+		t.Errorf("Got progress %v", values)
+	}
+}
+
+func TestWithProgressToken(t *testing.T) {
+	testData := []struct {
+		Params interface{}
+		Result string
+		Error  bool
+	}{
+		{nil, `{"progressToken":"t"}`, false},
+		{map[string]int{"a": 1}, `{"a":1,"progressToken":"t"}`, false},
+		{[]int{1}, ``, true},
+	}
+
+	for k, data := range testData {
+		fields, err := withProgressToken(data.Params, "t")
+		if (err != nil) != data.Error {
+			t.Errorf("%d unexpected error %v", k, err)
+			continue
+		}
+		if raw, _ := json.Marshal(fields); !data.Error && string(raw) != data.Result {
+			t.Errorf("%d got params %s", k, string(raw))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Request Context Data keys holding the retry info
+const (
+	RetryAttemptsKey = "retry.attempts"
+	RetryDelaysKey   = "retry.delays"
+)
+
+// Mark the methods as idempotent, so they may be retried
+func (p *RetryPolicy) MarkIdempotent(methods ...string) {
+	if p.IdempotentMethods == nil {
+		p.IdempotentMethods = map[string]bool{}
+	}
+
+	for _, method := range methods {
+		p.IdempotentMethods[method] = true
+	}
+}
+
+// Check if the request should be retried after the given attempt
+func (p *RetryPolicy) shouldRetry(rc *common.RequestContext, transportErr error, attempt int) bool {
+	if attempt >= p.MaxAttempts || !p.IdempotentMethods[rc.JsonRpcRequest.Method] {
+		return false
+	}
+
+	if transportErr != nil {
+		return true
+	}
+
+	if rc.JsonRpcResponse.Error == nil || len(p.RetryCodes) == 0 {
+		return false
+	}
+
+	e := common.Error{}
+	if json.Unmarshal(rc.JsonRpcResponse.Error, &e) != nil {
+		return false
+	}
+
+	for _, code := range p.RetryCodes {
+		if code == e.Code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the delay before the retry that follows the given attempt
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		d *= multiplier
+	}
+
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+// Perform the request according to the client's retry policy
+// The number of attempts and the delays are put into the Request Context Data
+func (c *Client) performWithRetry(rc *common.RequestContext) error {
+	var delays []time.Duration
+
+	if rc.Data == nil {
+		rc.Data = map[string]interface{}{}
+	}
+
+	for attempt := 1; ; attempt++ {
+		transportErr, err := c.performAttempt(rc)
+
+		rc.Data[RetryAttemptsKey] = attempt
+		rc.Data[RetryDelaysKey] = delays
+
+		if c.retry == nil || !c.retry.shouldRetry(rc, transportErr, attempt) {
+			return err
+		}
+
+		d := c.retry.delay(attempt)
+		delays = append(delays, d)
+
+		if rc.Logger != nil {
+			rc.Logger.Println("retrying", rc.JsonRpcRequest.Method, "attempt", attempt+1, "after", d)
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-rc.GetContext().Done():
+			timer.Stop()
+			return rc.GetContext().Err()
+		}
+	}
+}
+
+// Make a single attempt to perform the request
+// Errors of the transport are returned separately as only these may be retried
+func (c *Client) performAttempt(rc *common.RequestContext) (transportErr error, err error) {
+	rc.RawResponse = nil
+	rc.JsonRpcResponse = common.Response{}
+
+	if err = c.T.PerformRequest(rc); err != nil {
+		return err, err
+	}
+	// Notifications get no response
+	if rc.JsonRpcRequest.IsNotification() {
+		return nil, nil
+	}
+
+	return nil, rc.ParseRawResponse()
+}
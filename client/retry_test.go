@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+type test_FlakyHandler struct {
+	calls    *int
+	failures int
+}
+
+func (h test_FlakyHandler) Handle_flaky(params struct{}) (result string, jsonRpcError common.Error, err error) {
+	*h.calls++
+	if *h.calls <= h.failures {
+		jsonRpcError = common.Error{Code: "-32000", Message: "busy"}
+		return
+	}
+
+	result = "ok"
+	return
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+	}
+
+	for k, expect := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if d := p.delay(k + 1); d != expect {
+			t.Errorf("%d wrong delay %s", k, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < time.Second/2 || d > time.Second {
+			t.Errorf("jittered delay out of bounds %s", d)
+		}
+	}
+}
+
+func TestClient_PerformRequest_Retry_TransportError(t *testing.T) {
+	c := New()
+	_ = c.SetTransport(&transport.Error{ErrorMessage: "KEK"})
+
+	p := &RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+	}
+	c.SetRetryPolicy(p)
+
+	// Not idempotent: no retries
+	rc, _ := c.NewRequestContext("test", nil)
+	if c.PerformRequest(&rc) == nil {
+		t.Errorf("error was not returned")
+	}
+	if rc.Data[RetryAttemptsKey] != 1 {
+		t.Errorf("non-idempotent method was retried")
+	}
+
+	p.MarkIdempotent("test")
+
+	rc, _ = c.NewRequestContext("test", nil)
+	if err := c.PerformRequest(&rc); err == nil || err.Error() != "KEK" {
+		t.Errorf("transport error was not returned")
+	}
+	if rc.Data[RetryAttemptsKey] != 3 {
+		t.Errorf("wrong number of attempts %v", rc.Data[RetryAttemptsKey])
+	}
+	if delays, _ := rc.Data[RetryDelaysKey].([]time.Duration); len(delays) != 2 || delays[0] != time.Millisecond || delays[1] != 2*time.Millisecond {
+		t.Errorf("wrong delays %v", rc.Data[RetryDelaysKey])
+	}
+}
+
+func TestClient_PerformRequest_Retry_ErrorCode(t *testing.T) {
+	calls := 0
+	s := server.NewServer()
+	s.AddHandler(test_FlakyHandler{&calls, 2}, "Handle_")
+
+	c := New()
+	_ = c.SetTransport(&transport.Local{Server: s})
+
+	p := &RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: time.Millisecond,
+	}
+	p.MarkIdempotent("flaky")
+	c.SetRetryPolicy(p)
+
+	// The error code is not retryable
+	rc, _ := c.NewRequestContext("flaky", struct{}{})
+	if err := c.PerformRequest(&rc); err != nil {
+		t.Errorf("request failed: %s", err.Error())
+	} else if rc.JsonRpcResponse.Error == nil || rc.Data[RetryAttemptsKey] != 1 {
+		t.Errorf("non-retryable error was retried")
+	}
+
+	calls = 0
+	p.RetryCodes = []json.Number{"-32000"}
+
+	rc, _ = c.NewRequestContext("flaky", struct{}{})
+	if err := c.PerformRequest(&rc); err != nil {
+		t.Errorf("request failed: %s", err.Error())
+	} else if string(rc.JsonRpcResponse.Result) != `"ok"` {
+		t.Errorf("wrong result %s", string(rc.JsonRpcResponse.Result))
+	}
+	if rc.Data[RetryAttemptsKey] != 3 {
+		t.Errorf("wrong number of attempts %v", rc.Data[RetryAttemptsKey])
+	}
+}
+
+func TestClient_PerformRequest_Retry_Cancel(t *testing.T) {
+	c := New()
+	_ = c.SetTransport(&transport.Error{ErrorMessage: "KEK"})
+
+	p := &RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Hour,
+	}
+	p.MarkIdempotent("test")
+	c.SetRetryPolicy(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	rc, _ := c.NewRequestContext("test", nil)
+	rc.Ctx = ctx
+
+	if err := c.PerformRequest(&rc); err != context.DeadlineExceeded {
+		t.Errorf("retry wait was not cancelled: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Default methods matching the server/subscription package
+const (
+	DefaultSubscriptionMethod = "rpc.subscription"
+	DefaultUnsubscribeMethod  = "rpc.unsubscribe"
+)
+
+// Default number of events buffered for a subscription before it is dropped as too slow
+const DefaultSubscriptionQueueSize = 128
+
+// Maximum number of events kept for subscriptions that are not registered yet
+const maxEarlyEvents = 1024
+
+var (
+	ErrSubscriptionOverflow = errors.New("subscription queue overflow")
+	ErrUnsubscribed         = errors.New("unsubscribed")
+)
+
+// Delivers server notifications, implemented by persistent transports
+type NotificationSource interface {
+	OnNotification(method string, h transport.NotificationHandler)
+}
+
+// Reports lost connections, the subscriptions end with the error when it happens
+type DisconnectSource interface {
+	OnDisconnect(f func(err error))
+}
+
+// Params of the event notifications
+type subscriptionEvent struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// Set the methods used for event notifications and for cancelling subscriptions
+// Must be called before the first Subscribe
+func (c *Client) SetSubscriptionMethods(notificationMethod string, unsubscribeMethod string) error {
+	if notificationMethod == "" || unsubscribeMethod == "" {
+		return fmt.Errorf("empty method names not allowed")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subs != nil && c.subs.transport != nil {
+		return fmt.Errorf("subscriptions already started")
+	}
+
+	c.subs = &subscriptions{
+		notificationMethod: notificationMethod,
+		unsubscribeMethod:  unsubscribeMethod,
+	}
+
+	return nil
+}
+
+// Get the subscriptions of the client, listening to the notifications of its transport
+func (c *Client) subscriptions() (*subscriptions, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	source, ok := c.T.(NotificationSource)
+	if !ok {
+		return nil, fmt.Errorf("the transport does not deliver notifications")
+	}
+
+	if c.subs == nil {
+		c.subs = &subscriptions{
+			notificationMethod: DefaultSubscriptionMethod,
+			unsubscribeMethod:  DefaultUnsubscribeMethod,
+		}
+	}
+
+	if c.subs.transport != source {
+		if c.subs.transport != nil {
+			return nil, fmt.Errorf("the transport was changed")
+		}
+		c.subs.transport = source
+		c.subs.active = map[string]*Subscription{}
+		c.subs.early = map[string][]json.RawMessage{}
+		source.OnNotification(c.subs.notificationMethod, c.subs.dispatch)
+		if d, ok := source.(DisconnectSource); ok {
+			d.OnDisconnect(c.subs.disconnected)
+		}
+	}
+
+	return c.subs, nil
+}
+
+// Subscribe calls the method and delivers the events of the resulting subscription to the channel
+// The channel must be a chan of the event type; events that fail to decode are skipped.
+// The subscription is dropped with ErrSubscriptionOverflow when the channel is not drained fast enough.
+func (c *Client) Subscribe(ctx context.Context, method string, params interface{}, channel interface{}) (*Subscription, error) {
+	ch := reflect.ValueOf(channel)
+	if ch.Kind() != reflect.Chan || ch.Type().ChanDir()&reflect.SendDir == 0 {
+		return nil, fmt.Errorf("channel must be a writable chan")
+	}
+
+	subs, err := c.subscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := c.NewRequestContext(method, params)
+	if err != nil {
+		return nil, err
+	}
+	rc.Ctx = ctx
+
+	if err = c.PerformRequest(&rc); err != nil {
+		return nil, err
+	}
+
+	if rc.JsonRpcResponse.Error != nil {
+		return nil, fmt.Errorf("subscription rejected: %s", string(rc.JsonRpcResponse.Error))
+	}
+
+	id := ""
+	if err = json.Unmarshal(rc.JsonRpcResponse.Result, &id); err != nil || id == "" {
+		return nil, fmt.Errorf("bad subscription id %s", string(rc.JsonRpcResponse.Result))
+	}
+
+	s := &Subscription{
+		Id:      id,
+		client:  c,
+		channel: ch,
+		queue:   make(chan json.RawMessage, DefaultSubscriptionQueueSize),
+		err:     make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+
+	subs.add(s)
+	go s.forward()
+
+	return s, nil
+}
+
+// Register a subscription and pass it the events that came before
+func (subs *subscriptions) add(s *Subscription) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	subs.active[s.Id] = s
+
+	for _, raw := range subs.early[s.Id] {
+		s.push(raw)
+	}
+	delete(subs.early, s.Id)
+}
+
+// Route an event notification to its subscription
+func (subs *subscriptions) dispatch(n common.Request) {
+	event := subscriptionEvent{}
+	if json.Unmarshal(n.Params, &event) != nil || event.Subscription == "" {
+		return
+	}
+
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if s, ok := subs.active[event.Subscription]; ok {
+		s.push(event.Result)
+		return
+	}
+
+	// The subscription may not be registered yet as its events may outrun the response
+	total := 0
+	for _, events := range subs.early {
+		total += len(events)
+	}
+	if total < maxEarlyEvents {
+		subs.early[event.Subscription] = append(subs.early[event.Subscription], event.Result)
+	}
+}
+
+// End all the subscriptions as the server forgets them with the connection
+func (subs *subscriptions) disconnected(err error) {
+	subs.mu.Lock()
+	active := make([]*Subscription, 0, len(subs.active))
+	for _, s := range subs.active {
+		active = append(active, s)
+	}
+	subs.early = map[string][]json.RawMessage{}
+	subs.mu.Unlock()
+
+	for _, s := range active {
+		s.close(err)
+	}
+}
+
+// Forget a subscription
+func (subs *subscriptions) remove(id string) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	delete(subs.active, id)
+}
+
+// Queue an event without blocking the transport, must be called under the subscriptions lock
+func (s *Subscription) push(raw json.RawMessage) {
+	select {
+	case <-s.done:
+	case s.queue <- raw:
+	default:
+		go func() {
+			if s.close(ErrSubscriptionOverflow) {
+				_ = s.unsubscribe(context.Background())
+			}
+		}()
+	}
+}
+
+// Decode the queued events and send them to the channel
+func (s *Subscription) forward() {
+	elemType := s.channel.Type().Elem()
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.done)},
+		{Dir: reflect.SelectSend, Chan: s.channel},
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case raw := <-s.queue:
+			event := reflect.New(elemType)
+			if err := json.Unmarshal(raw, event.Interface()); err != nil {
+				s.client.logger.Println("bad subscription event", err.Error())
+				continue
+			}
+
+			cases[1].Send = event.Elem()
+			if chosen, _, _ := reflect.Select(cases); chosen == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Get a channel receiving the error that ended the subscription
+// Nothing is sent when the subscription is ended by Unsubscribe
+func (s *Subscription) Err() <-chan error {
+	return s.err
+}
+
+// Get a channel that is closed when the subscription ends
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Cancel the subscription on the server and stop delivering events
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	if !s.close(ErrUnsubscribed) {
+		return nil
+	}
+
+	return s.unsubscribe(ctx)
+}
+
+// Tell the server the events are no longer wanted
+func (s *Subscription) unsubscribe(ctx context.Context) error {
+	subs, err := s.client.subscriptions()
+	if err != nil {
+		return err
+	}
+
+	rc, err := s.client.NewRequestContext(subs.unsubscribeMethod, map[string]string{"subscription": s.Id})
+	if err != nil {
+		return err
+	}
+	rc.Ctx = ctx
+
+	if err = s.client.PerformRequest(&rc); err != nil {
+		return err
+	}
+
+	if rc.JsonRpcResponse.Error != nil {
+		return fmt.Errorf("unsubscribe failed: %s", string(rc.JsonRpcResponse.Error))
+	}
+
+	return nil
+}
+
+// End the subscription, returns false if it has already ended
+func (s *Subscription) close(reason error) bool {
+	s.mu.Lock()
+	if s.closeErr != nil {
+		s.mu.Unlock()
+		return false
+	}
+	s.closeErr = reason
+	s.mu.Unlock()
+
+	close(s.done)
+	if reason != ErrUnsubscribed {
+		s.err <- reason
+	}
+
+	if subs, err := s.client.subscriptions(); err == nil {
+		subs.remove(s.Id)
+	}
+
+	return true
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Answers every call with a subscription id, pushing an event ahead of the response
+type test_PushTransport struct {
+	transport.Discard
+	handlers   map[string]transport.NotificationHandler
+	disconnect func(err error)
+	requests   []common.Request
+}
+
+func (t *test_PushTransport) OnNotification(method string, h transport.NotificationHandler) {
+	if t.handlers == nil {
+		t.handlers = map[string]transport.NotificationHandler{}
+	}
+	t.handlers[method] = h
+}
+
+func (t *test_PushTransport) OnDisconnect(f func(err error)) {
+	t.disconnect = f
+}
+
+func (t *test_PushTransport) push(id string, result string) {
+	n, _ := common.MakeNotification(DefaultSubscriptionMethod, map[string]json.RawMessage{
+		"subscription": json.RawMessage(`"` + id + `"`),
+		"result":       json.RawMessage(result),
+	})
+	t.handlers[n.Method](n)
+}
+
+func (t *test_PushTransport) PerformRequest(rc *common.RequestContext) error {
+	t.requests = append(t.requests, rc.JsonRpcRequest)
+
+	if rc.JsonRpcRequest.Method == "subscribe" {
+		t.push("sub1", `{"n":1}`)
+	}
+
+	rc.RawResponse = []byte(`{"jsonrpc":"2.0","id":"` + rc.JsonRpcRequest.Id + `","result":"sub1"}`)
+	return nil
+}
+
+func (t *test_PushTransport) SetLogger(l *log.Logger) error {
+	return nil
+}
+
+type test_Event struct {
+	N int `json:"n"`
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	c := New()
+
+	if _, err := c.Subscribe(context.Background(), "subscribe", nil, make(chan test_Event)); err == nil {
+		t.Errorf("Subscribing through a transport without notifications did not fail")
+	}
+
+	tr := &test_PushTransport{}
+	_ = c.SetTransport(tr)
+
+	if _, err := c.Subscribe(context.Background(), "subscribe", nil, test_Event{}); err == nil {
+		t.Errorf("Subscribing with a non-channel did not fail")
+	}
+
+	events := make(chan test_Event, 10)
+	sub, err := c.Subscribe(context.Background(), "subscribe", nil, events)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %s", err.Error())
+	}
+
+	tr.push("sub1", `{"n":2}`)
+	tr.push("other", `{"n":-1}`)
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case e := <-events:
+			if e.N != i {
+				t.Errorf("Got event %d instead of %d", e.N, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Event %d was not delivered", i)
+		}
+	}
+
+	if err = sub.Unsubscribe(context.Background()); err != nil {
+		t.Errorf("Could not unsubscribe: %s", err.Error())
+	}
+
+	last := tr.requests[len(tr.requests)-1]
+	if last.Method != DefaultUnsubscribeMethod || string(last.Params) != `{"subscription":"sub1"}` {
+		t.Errorf("Bad unsubscribe request %v", last)
+	}
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Errorf("Subscription is not done after unsubscribe")
+	}
+}
+
+func TestClient_Subscribe_Disconnect(t *testing.T) {
+	c := New()
+	tr := &test_PushTransport{}
+	_ = c.SetTransport(tr)
+
+	sub, err := c.Subscribe(context.Background(), "subscribe", nil, make(chan test_Event, 10))
+	if err != nil {
+		t.Fatalf("Could not subscribe: %s", err.Error())
+	}
+
+	lost := errors.New("lost")
+	tr.disconnect(lost)
+
+	select {
+	case err = <-sub.Err():
+		if err != lost {
+			t.Errorf("Got error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription did not end on disconnect")
+	}
+}
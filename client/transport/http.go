@@ -1,23 +1,125 @@
 package transport
 
 import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+
 	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/compression"
 )
 
+// HTTP transport
+// Posts raw requests to the given Url, HttpClient defaults to http.DefaultClient (or a client with TLSConfig)
+// Compressed responses (gzip or deflate) are always accepted
 type Http struct {
 	Logged
+	Url        string
+	HttpClient *http.Client
+	// TLS settings (e.g. a client certificate, see NewClientTLSConfig), used if HttpClient is nil
+	TLSConfig *tls.Config
+	// Coding of the requests (compression.Gzip or compression.Deflate), requests are not compressed if empty
+	Compression string
+	// Requests smaller than this are not compressed, compression.DefaultMinSize if zero
+	CompressMinSize int
+	// Maximum size of a decompressed response, compression.DefaultMaxSize if zero
+	MaxResponseSize      int64
 	PreProcessingStages  []common.Stage
 	PostProcessingStages []common.Stage
+	tlsClient            *http.Client
+	tlsOnce              sync.Once
 }
 
 func (t *Http) PerformRequest(rc *common.RequestContext) error {
 	rc.ApplyPipeline(&t.PreProcessingStages)
-	// TODO: http processing
+
+	if err := t.post(rc); err != nil {
+		return err
+	}
 
 	rc.ApplyPipeline(&t.PostProcessingStages)
 	return nil
 }
 
+// Do the actual HTTP round trip
+func (t *Http) post(rc *common.RequestContext) error {
+	body, encoding, err := t.requestBody(rc.RawRequest)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(rc.GetContext(), http.MethodPost, t.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept-Encoding", compression.AcceptEncoding)
+	if encoding != "" {
+		request.Header.Set("Content-Encoding", encoding)
+	}
+
+	httpClient := t.client()
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Setting Accept-Encoding turns off the transparent decompression of net/http, so it is done here
+	rc.RawResponse, err = compression.Decompress(response.Body, response.Header.Get("Content-Encoding"), t.MaxResponseSize)
+	if err != nil {
+		return err
+	}
+
+	// Notifications may legitimately get an empty body with any successful status
+	if response.StatusCode >= 300 && len(rc.RawResponse) == 0 {
+		return fmt.Errorf("http error %s", response.Status)
+	}
+
+	return nil
+}
+
+// Get the HTTP client to use
+func (t *Http) client() *http.Client {
+	if t.HttpClient != nil {
+		return t.HttpClient
+	}
+
+	if t.TLSConfig == nil {
+		return http.DefaultClient
+	}
+
+	// Keep the defaults (proxy, timeouts, HTTP/2, connection pooling) of the default transport
+	t.tlsOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = t.TLSConfig
+		t.tlsClient = &http.Client{Transport: transport}
+	})
+
+	return t.tlsClient
+}
+
+// Compress the request body if needed
+func (t *Http) requestBody(raw []byte) (body []byte, encoding string, err error) {
+	minSize := t.CompressMinSize
+	if minSize == 0 {
+		minSize = compression.DefaultMinSize
+	}
+
+	if t.Compression == "" || len(raw) < minSize {
+		return raw, "", nil
+	}
+
+	if body, err = compression.Compress(raw, t.Compression); err != nil {
+		return nil, "", err
+	}
+
+	return body, t.Compression, nil
+}
+
 func (t *Http) AddPreProcessingStage(stage common.Stage) {
 	t.PreProcessingStages = append(t.PreProcessingStages, stage)
 }
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/compression"
+)
+
+func TestHttp_Compression(t *testing.T) {
+	requestEncoding := ""
+	response := []byte(`{"jsonrpc":"2.0","id":"1","result":"` + strings.Repeat("lol", 1000) + `"}`)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestEncoding = r.Header.Get("Content-Encoding")
+		if _, err := compression.Decompress(r.Body, requestEncoding, 0); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"))
+		body, _ := compression.Compress(response, encoding)
+		w.Header().Set("Content-Encoding", encoding)
+		_, _ = w.Write(body)
+	}))
+	defer httpServer.Close()
+
+	big := `{"jsonrpc":"2.0","id":"1","method":"lol","params":"` + strings.Repeat("kek", 1000) + `"}`
+	small := `{"jsonrpc":"2.0","id":"1","method":"lol"}`
+
+	testData := []struct {
+		Compression string
+		Request     string
+		Encoding    string
+	}{
+		{"", big, ""},
+		{compression.Gzip, small, ""},
+		{compression.Gzip, big, compression.Gzip},
+		{compression.Deflate, big, compression.Deflate},
+	}
+
+	for k, data := range testData {
+		tr := &Http{Url: httpServer.URL, Compression: data.Compression}
+
+		rc := common.EmptyRequestContext()
+		rc.RawRequest = []byte(data.Request)
+		if err := tr.PerformRequest(&rc); err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+
+		if requestEncoding != data.Encoding {
+			t.Errorf("%d request was sent with encoding %q", k, requestEncoding)
+		}
+
+		if string(rc.RawResponse) != string(response) {
+			t.Errorf("%d response was not decompressed", k)
+		}
+	}
+
+	// Decompressed responses are size-limited
+	tr := &Http{Url: httpServer.URL, MaxResponseSize: 1000}
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(small)
+	if err := tr.PerformRequest(&rc); err != compression.ErrTooLarge {
+		t.Errorf("Response size was not limited")
+	}
+}
@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Outcome of a pending call
+type CallResult struct {
+	Raw []byte
+	Err error
+}
+
+// Calls waiting for their responses over a persistent connection, routed by request id
+// A batch call is registered under all of its ids and gets a single result. The zero value is ready to use.
+type PendingCalls struct {
+	calls map[string]chan CallResult
+	mu    sync.Mutex
+}
+
+// Register a call waiting for the responses to the given ids
+func (p *PendingCalls) Add(ids []string) (<-chan CallResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.calls == nil {
+		p.calls = map[string]chan CallResult{}
+	}
+
+	for _, id := range ids {
+		if _, ok := p.calls[id]; ok {
+			return nil, fmt.Errorf("request id %s is already pending", id)
+		}
+	}
+
+	ch := make(chan CallResult, 1)
+	for _, id := range ids {
+		p.calls[id] = ch
+	}
+
+	return ch, nil
+}
+
+// Deliver the message to the call waiting for any of the ids, false if there is no such call
+func (p *PendingCalls) Deliver(ids []string, message []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range ids {
+		if ch, ok := p.calls[id]; ok && id != "" {
+			ch <- CallResult{Raw: message}
+			p.removeLocked(ch)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stop waiting for the responses of the call
+func (p *PendingCalls) Remove(call <-chan CallResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeLocked(call)
+}
+
+func (p *PendingCalls) removeLocked(call <-chan CallResult) {
+	for id, ch := range p.calls {
+		if (<-chan CallResult)(ch) == call {
+			delete(p.calls, id)
+		}
+	}
+}
+
+// Fail all pending calls with the error
+func (p *PendingCalls) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	failed := map[chan CallResult]bool{}
+	for id, ch := range p.calls {
+		if !failed[ch] {
+			ch <- CallResult{Err: err}
+			failed[ch] = true
+		}
+		delete(p.calls, id)
+	}
+}
+
+// The part of a message needed for routing
+type MessageProbe struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Check if the message is a response rather than a request
+func (m MessageProbe) IsResponse() bool {
+	return m.Method == "" && (m.Result != nil || m.Error != nil)
+}
+
+// Get the id of the message as a string, "" if there is none
+func (m MessageProbe) MessageId() string {
+	if len(m.Id) == 0 || string(m.Id) == "null" {
+		return ""
+	}
+
+	if s, err := strconv.Unquote(string(m.Id)); err == nil {
+		return s
+	}
+
+	return string(m.Id)
+}
+
+// Probe a single message or every message of a batch
+func ProbeMessage(message []byte) (probes []MessageProbe, batch bool, err error) {
+	trimmed := bytes.TrimLeft(message, " \t\r\n")
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &probes)
+		return probes, true, err
+	}
+
+	probe := MessageProbe{}
+	if err = json.Unmarshal(trimmed, &probe); err != nil {
+		return nil, false, err
+	}
+
+	return []MessageProbe{probe}, false, nil
+}
+
+// Collect the ids of all messages in a raw message (single or batch)
+func MessageIds(message []byte) ([]string, error) {
+	probes, _, err := ProbeMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(probes))
+	for _, probe := range probes {
+		if id := probe.MessageId(); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,433 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// The error for calls that were pending when the connection dropped
+var ErrConnectionLost = errors.New("connection lost before the response was received")
+
+// The error for calls made while the connection is being re-established
+var ErrNotConnected = errors.New("not connected")
+
+// The error for calls made through a closed transport
+var ErrTransportClosed = errors.New("transport closed")
+
+// Opens a new message connection
+type Dialer func() (common.MessageConn, error)
+
+// Handles a notification sent by the server
+type NotificationHandler func(n common.Request)
+
+// Persistent connection transport
+// Runs any number of concurrent calls over a single connection and routes the responses by id.
+// Notifications from the server are delivered to the registered handlers.
+// When the connection drops, pending calls fail with an error wrapping ErrConnectionLost and the connection
+// is re-established in the background with exponential backoff.
+type Persistent struct {
+	Logged
+	Dial                 Dialer
+	PreProcessingStages  []common.Stage
+	PostProcessingStages []common.Stage
+	// Delay before the first reconnection attempt (100ms by default), doubled after each failure
+	ReconnectDelay time.Duration
+	// Upper bound of the reconnection delay (30s by default)
+	MaxReconnectDelay time.Duration
+	// Called after each successful (re)connection
+	OnConnect func()
+	// Do not reconnect in the background, the next request dials again instead
+	DisableReconnect bool
+
+	conn         common.MessageConn
+	generation   int
+	reconnecting bool
+	// Closed once the dial in progress ends
+	dialing      chan struct{}
+	closed       bool
+	pending      PendingCalls
+	handlers     map[string][]NotificationHandler
+	onDisconnect []func(err error)
+	mu           sync.Mutex
+}
+
+// Create a new persistent transport using the given dialer
+func NewPersistent(dial Dialer) *Persistent {
+	return &Persistent{Dial: dial}
+}
+
+func (t *Persistent) getLogger() *log.Logger {
+	if t.logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+
+	return t.logger
+}
+
+// Connect now instead of waiting for the first request
+func (t *Persistent) Connect() error {
+	_, _, err := t.connection()
+	return err
+}
+
+// Get the current connection, dialing if there is none
+func (t *Persistent) connection() (common.MessageConn, int, error) {
+	t.mu.Lock()
+
+	if t.closed {
+		t.mu.Unlock()
+		return nil, 0, ErrTransportClosed
+	}
+
+	if t.conn != nil {
+		defer t.mu.Unlock()
+		return t.conn, t.generation, nil
+	}
+
+	if t.reconnecting {
+		t.mu.Unlock()
+		return nil, 0, ErrNotConnected
+	}
+
+	t.mu.Unlock()
+
+	return t.dial()
+}
+
+// Dial (without holding the lock, so a slow dial does not block other calls) and start reading
+// Concurrent callers wait for the dial in progress instead of dialing again
+func (t *Persistent) dial() (common.MessageConn, int, error) {
+	t.mu.Lock()
+
+	if t.conn != nil {
+		defer t.mu.Unlock()
+		return t.conn, t.generation, nil
+	}
+
+	if t.dialing != nil {
+		wait := t.dialing
+		t.mu.Unlock()
+		<-wait
+		return t.current()
+	}
+
+	if t.Dial == nil {
+		t.mu.Unlock()
+		return nil, 0, fmt.Errorf("no dialer")
+	}
+
+	done := make(chan struct{})
+	t.dialing = done
+	dial := t.Dial
+	t.mu.Unlock()
+
+	conn, err := dial()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.dialing = nil
+	close(done)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if t.closed {
+		_ = conn.Close()
+		return nil, 0, ErrTransportClosed
+	}
+
+	t.conn = conn
+	t.generation++
+	go t.readLoop(conn, t.generation)
+
+	if t.OnConnect != nil {
+		go t.OnConnect()
+	}
+
+	return conn, t.generation, nil
+}
+
+// Get the current connection without dialing
+func (t *Persistent) current() (common.MessageConn, int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, 0, ErrTransportClosed
+	}
+
+	if t.conn == nil {
+		return nil, 0, ErrNotConnected
+	}
+
+	return t.conn, t.generation, nil
+}
+
+// Read incoming messages until the connection fails
+func (t *Persistent) readLoop(conn common.MessageConn, generation int) {
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			t.connectionLost(generation, err)
+			return
+		}
+
+		t.dispatch(message)
+	}
+}
+
+// Fail the pending calls and start reconnecting
+func (t *Persistent) connectionLost(generation int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if generation != t.generation || t.conn == nil {
+		return
+	}
+
+	t.getLogger().Println("connection lost", err.Error())
+
+	// Closing may take a while, it must not hold the lock
+	go t.conn.Close()
+	t.conn = nil
+	lost := fmt.Errorf("%w: %s", ErrConnectionLost, err.Error())
+	t.pending.Fail(lost)
+
+	for _, f := range t.onDisconnect {
+		go f(lost)
+	}
+
+	if !t.closed && !t.DisableReconnect {
+		t.reconnecting = true
+		go t.reconnect()
+	}
+}
+
+// Re-establish the connection with exponential backoff
+func (t *Persistent) reconnect() {
+	delay := t.ReconnectDelay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+
+	maxDelay := t.MaxReconnectDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	for {
+		time.Sleep(delay)
+
+		t.mu.Lock()
+		if t.closed {
+			t.reconnecting = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+
+		_, _, err := t.dial()
+		if err == nil || err == ErrTransportClosed {
+			t.mu.Lock()
+			t.reconnecting = false
+			t.mu.Unlock()
+			return
+		}
+
+		t.getLogger().Println("reconnection failed", err.Error())
+
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// Route an incoming message to the pending call or to the notification handlers
+func (t *Persistent) dispatch(message []byte) {
+	if len(bytes.TrimLeft(message, " \t\r\n")) == 0 {
+		return
+	}
+
+	probes, _, err := ProbeMessage(message)
+	if err != nil {
+		t.getLogger().Println("bad message", err.Error())
+		return
+	}
+
+	// Notifications from the server
+	if len(probes) == 1 && probes[0].Method != "" {
+		t.notify(message)
+		return
+	}
+
+	// Responses (a batch response goes to the call waiting for any of its ids)
+	ids := make([]string, 0, len(probes))
+	for _, probe := range probes {
+		ids = append(ids, probe.MessageId())
+	}
+
+	if !t.pending.Deliver(ids, message) {
+		t.getLogger().Println("unexpected message", string(message))
+	}
+}
+
+// Deliver a notification to the handlers registered for its method and to catch-all handlers
+func (t *Persistent) notify(message []byte) {
+	n := common.Request{}
+	if err := json.Unmarshal(message, &n); err != nil {
+		t.getLogger().Println("bad notification", err.Error())
+		return
+	}
+
+	t.mu.Lock()
+	handlers := append(append([]NotificationHandler{}, t.handlers[n.Method]...), t.handlers[""]...)
+	t.mu.Unlock()
+
+	for _, h := range handlers {
+		h(n)
+	}
+}
+
+// Register a handler for server notifications with the given method ("" means any method)
+func (t *Persistent) OnNotification(method string, h NotificationHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.handlers == nil {
+		t.handlers = map[string][]NotificationHandler{}
+	}
+
+	t.handlers[method] = append(t.handlers[method], h)
+}
+
+// Register a function to be called when the connection drops
+func (t *Persistent) OnDisconnect(f func(err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onDisconnect = append(t.onDisconnect, f)
+}
+
+// Send the request and wait for the response (unless it is made of notifications only)
+func (t *Persistent) PerformRequest(rc *common.RequestContext) error {
+	rc.ApplyPipeline(&t.PreProcessingStages)
+
+	ids, err := MessageIds(rc.RawRequest)
+	if err != nil {
+		return err
+	}
+
+	conn, generation, err := t.connection()
+	if err != nil {
+		return err
+	}
+
+	// Nothing to wait for
+	if len(ids) == 0 {
+		if err = conn.WriteMessage(rc.RawRequest); err != nil {
+			return err
+		}
+		rc.ApplyPipeline(&t.PostProcessingStages)
+		return nil
+	}
+
+	call, err := t.pending.Add(ids)
+	if err != nil {
+		return err
+	}
+
+	// The calls pending on a connection are failed when it is lost, a call added after that must not wait
+	if _, current, err := t.current(); err != nil || current != generation {
+		t.pending.Remove(call)
+		if err == nil {
+			err = ErrNotConnected
+		}
+		return err
+	}
+
+	if err = conn.WriteMessage(rc.RawRequest); err != nil {
+		t.pending.Remove(call)
+		return err
+	}
+
+	select {
+	case result := <-call:
+		if result.Err != nil {
+			return result.Err
+		}
+		rc.RawResponse = result.Raw
+	case <-rc.GetContext().Done():
+		t.pending.Remove(call)
+		t.cancelRequests(conn, ids)
+		return rc.GetContext().Err()
+	}
+
+	rc.ApplyPipeline(&t.PostProcessingStages)
+	return nil
+}
+
+// Tell the server to stop working on the requests whose caller gave up
+func (t *Persistent) cancelRequests(conn common.MessageConn, ids []string) {
+	for _, id := range ids {
+		raw, _ := json.Marshal(id)
+
+		n, err := common.MakeNotification(common.CancelRequestMethod, common.CancelParams{Id: raw})
+		if err != nil {
+			continue
+		}
+
+		if message, err := json.Marshal(n); err == nil {
+			_ = conn.WriteMessage(message)
+		}
+	}
+}
+
+func (t *Persistent) AddPreProcessingStage(stage common.Stage) {
+	t.PreProcessingStages = append(t.PreProcessingStages, stage)
+}
+
+func (t *Persistent) AddPostProcessingStage(stage common.Stage) {
+	t.PostProcessingStages = append(t.PostProcessingStages, stage)
+}
+
+// Check if the transport is connected right now
+func (t *Persistent) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.conn != nil
+}
+
+// Close the connection and stop reconnecting, pending calls fail with ErrTransportClosed
+// The connection is closed without holding the lock, as that may take a while (e.g. waiting for a child process)
+func (t *Persistent) Close() error {
+	t.mu.Lock()
+
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+
+	t.pending.Fail(ErrTransportClosed)
+
+	conn := t.conn
+	t.conn = nil
+	t.mu.Unlock()
+
+	if conn != nil {
+		return conn.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// A connection that blocks reading until it is closed
+type test_IdleConn struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *test_IdleConn) ReadMessage() ([]byte, error) {
+	<-c.closed
+	return nil, ErrTransportClosed
+}
+
+func (c *test_IdleConn) WriteMessage(message []byte) error {
+	return nil
+}
+
+func (c *test_IdleConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestPersistent_SlowDial(t *testing.T) {
+	started := make(chan bool, 2)
+	release := make(chan bool)
+	conn := &test_IdleConn{closed: make(chan struct{})}
+
+	tr := NewPersistent(func() (common.MessageConn, error) {
+		started <- true
+		<-release
+		return conn, nil
+	})
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- tr.Connect()
+	}()
+	<-started
+
+	// A concurrent caller waits for the same dial
+	go func() {
+		errs <- tr.Connect()
+	}()
+
+	// A slow dial does not block the other calls
+	closed := make(chan error)
+	go func() {
+		closed <- tr.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("close failed: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("close was blocked by the dial")
+	}
+
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrTransportClosed {
+			t.Errorf("%d connection dialed for a closed transport was used: %v", i, err)
+		}
+	}
+
+	select {
+	case <-conn.closed:
+	default:
+		t.Errorf("connection dialed for a closed transport was not closed")
+	}
+
+	if len(started) != 0 {
+		t.Errorf("concurrent callers dialed twice")
+	}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"net"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+)
+
+// Stream transport
+// A Persistent transport over a TCP or Unix domain socket connection with pluggable framing
+type Stream struct {
+	Persistent
+	Network string
+	Address string
+	Framer  framing.Framer
+	// Limit for the size of incoming messages, zero means framing.DefaultMaxMessageSize
+	MaxMessageSize int64
+	// Connection timeout, 30s by default
+	DialTimeout time.Duration
+}
+
+// Create a new TCP transport for the given address
+func NewTcp(address string, framer framing.Framer) *Stream {
+	return NewStream("tcp", address, framer)
+}
+
+// Create a new Unix domain socket transport for the given path
+func NewUnix(path string, framer framing.Framer) *Stream {
+	return NewStream("unix", path, framer)
+}
+
+// Create a new stream transport, nil framer means framing.Newline
+func NewStream(network string, address string, framer framing.Framer) *Stream {
+	if framer == nil {
+		framer = framing.Newline{}
+	}
+
+	t := &Stream{
+		Network: network,
+		Address: address,
+		Framer:  framer,
+	}
+	t.Dial = t.dial
+
+	return t
+}
+
+func (t *Stream) dial() (common.MessageConn, error) {
+	timeout := t.DialTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	conn, err := net.DialTimeout(t.Network, t.Address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	fc := framing.NewConn(conn, t.Framer)
+	fc.MaxMessageSize = t.MaxMessageSize
+
+	return fc, nil
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+	servertransport "github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+func TestStream_PerformRequest(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WebSocketHandler{}, "Handle_")
+
+	for k, framer := range []framing.Framer{framing.Newline{}, framing.ContentLength{}, framing.LengthPrefix{}} {
+		tcp, err := servertransport.NewTcpTransport("127.0.0.1:0", s, framer)
+		if err != nil {
+			t.Fatalf("could not listen: %s", err.Error())
+		}
+		unix, err := servertransport.NewUnixTransport(filepath.Join(t.TempDir(), "rpc.sock"), s, framer)
+		if err != nil {
+			t.Fatalf("could not listen: %s", err.Error())
+		}
+		tcp.Start()
+		unix.Start()
+
+		for _, tr := range []*Stream{NewTcp(tcp.Addr().String(), framer), NewUnix(unix.Addr().String(), framer)} {
+			rc := common.EmptyRequestContext()
+			rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":1}}`)
+
+			if err = tr.PerformRequest(&rc); err != nil {
+				t.Errorf("%d %s request failed: %s", k, tr.Network, err.Error())
+			} else if string(rc.RawResponse) != `{"jsonrpc":"2.0","id":"1","result":1}` {
+				t.Errorf("%d %s wrong response %s", k, tr.Network, string(rc.RawResponse))
+			}
+
+			_ = tr.Close()
+		}
+
+		_ = tcp.Shutdown(context.Background())
+		_ = unix.Shutdown(context.Background())
+	}
+}
+
+type test_WaitHandler struct {
+	cancelled chan error
+}
+
+func (h test_WaitHandler) Handle_wait(rc *common.RequestContext, params struct{}) (result bool, jsonRpcError common.Error, err error) {
+	select {
+	case <-rc.GetContext().Done():
+		h.cancelled <- rc.GetContext().Err()
+	case <-time.After(5 * time.Second):
+		h.cancelled <- nil
+	}
+	return
+}
+
+func TestStream_PerformRequest_Cancel(t *testing.T) {
+	h := test_WaitHandler{cancelled: make(chan error, 1)}
+	s := server.NewServer()
+	s.AddHandler(h, "Handle_")
+
+	tcp, err := servertransport.NewTcpTransport("127.0.0.1:0", s, framing.Newline{})
+	if err != nil {
+		t.Fatalf("could not listen: %s", err.Error())
+	}
+	tcp.MaxInFlight = 1
+	tcp.Start()
+	defer func() { _ = tcp.Shutdown(context.Background()) }()
+
+	tr := NewTcp(tcp.Addr().String(), framing.Newline{})
+	defer func() { _ = tr.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	rc := common.EmptyRequestContext()
+	rc.Ctx = ctx
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"wait","params":{}}`)
+
+	if err = tr.PerformRequest(&rc); err != context.DeadlineExceeded {
+		t.Errorf("expected the call to time out, got %v", err)
+	}
+
+	select {
+	case err = <-h.cancelled:
+		if err != context.Canceled {
+			t.Errorf("handler was not cancelled: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler did not finish")
+	}
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+)
+
+// Subprocess transport
+// Starts a JSON-RPC server as a child process and talks to it over its standard input and output
+// (LSP-style Content-Length framing by default). The child's standard error is kept separate.
+// When the child exits, pending calls fail and the next request starts a new child.
+type Subprocess struct {
+	Persistent
+	Command string
+	Args    []string
+	Env     []string
+	Dir     string
+	// Where the standard error of the child goes, os.Stderr by default
+	Stderr io.Writer
+	Framer framing.Framer
+	// Limit for the size of incoming messages, zero means framing.DefaultMaxMessageSize
+	MaxMessageSize int64
+	// How long to wait for the child to exit after its input is closed before killing it (5s by default)
+	ExitTimeout time.Duration
+}
+
+// A message connection to a running child process
+type processConn struct {
+	*framing.Conn
+	cmd       *exec.Cmd
+	stdin     io.Closer
+	exited    chan struct{}
+	exitErr   error
+	timeout   time.Duration
+	closeOnce sync.Once
+}
+
+// The output and the input of a child process
+type processStream struct {
+	io.ReadCloser
+	io.Writer
+}
+
+// Create a new subprocess transport for the given command
+func NewSubprocess(command string, args ...string) *Subprocess {
+	t := &Subprocess{
+		Command: command,
+		Args:    args,
+		Framer:  framing.ContentLength{},
+	}
+	t.Dial = t.start
+	t.DisableReconnect = true
+
+	return t
+}
+
+// Start the child process
+func (t *Subprocess) start() (common.MessageConn, error) {
+	cmd := exec.Command(t.Command, t.Args...)
+	cmd.Env = t.Env
+	cmd.Dir = t.Dir
+
+	cmd.Stderr = t.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	// A plain pipe rather than StdoutPipe, so that waiting for the child does not race with reading
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = w
+
+	err = cmd.Start()
+	_ = w.Close()
+	if err != nil {
+		_ = stdout.Close()
+		return nil, err
+	}
+
+	pc := &processConn{
+		Conn:    framing.NewConn(processStream{stdout, stdin}, t.Framer),
+		cmd:     cmd,
+		stdin:   stdin,
+		exited:  make(chan struct{}),
+		timeout: t.ExitTimeout,
+	}
+	pc.MaxMessageSize = t.MaxMessageSize
+
+	go func() {
+		pc.exitErr = cmd.Wait()
+		close(pc.exited)
+	}()
+
+	return pc, nil
+}
+
+// Read a message, reporting the exit status of the child once its output ends
+func (pc *processConn) ReadMessage() ([]byte, error) {
+	message, err := pc.Conn.ReadMessage()
+	if err == nil {
+		return message, nil
+	}
+
+	select {
+	case <-pc.exited:
+		if pc.exitErr != nil {
+			return nil, fmt.Errorf("process exited: %s", pc.exitErr.Error())
+		}
+		return nil, fmt.Errorf("process exited")
+	case <-time.After(100 * time.Millisecond):
+		return nil, err
+	}
+}
+
+// Close the input of the child and wait for it to exit, killing it if it does not, then close its output
+func (pc *processConn) Close() error {
+	pc.closeOnce.Do(func() {
+		_ = pc.stdin.Close()
+
+		timeout := pc.timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+
+		select {
+		case <-pc.exited:
+		case <-time.After(timeout):
+			_ = pc.cmd.Process.Kill()
+			<-pc.exited
+		}
+
+		// The output of the child is not needed anymore
+		_ = pc.Conn.Close()
+	})
+
+	return nil
+}
+
+// Get the process id of the running child (0 if there is none)
+func (t *Subprocess) Pid() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pc, ok := t.conn.(*processConn); ok {
+		return pc.cmd.Process.Pid
+	}
+
+	return 0
+}
@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	servertransport "github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+type test_SubprocessHandler struct{}
+
+func (h test_SubprocessHandler) Handle_exit(params struct{}) (result int, jsonRpcError common.Error, err error) {
+	os.Exit(3)
+	return
+}
+
+// Not a real test: this is the child process serving over stdio
+func TestSubprocessHelper(t *testing.T) {
+	switch os.Getenv("GOJSONRPC_TEST_CHILD") {
+	case "1":
+	case "stuck":
+		// A child ignoring the end of its input
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	default:
+		return
+	}
+
+	s := server.NewServer()
+	s.AddHandler(test_WebSocketHandler{}, "Handle_")
+	s.AddHandler(test_SubprocessHandler{}, "Handle_")
+
+	os.Stderr.WriteString("child started\n")
+	_ = servertransport.ServeStdio(s)
+	os.Exit(0)
+}
+
+func TestSubprocess_PerformRequest(t *testing.T) {
+	stderr := bytes.Buffer{}
+
+	tr := NewSubprocess(os.Args[0], "-test.run=TestSubprocessHelper")
+	tr.Env = append(os.Environ(), "GOJSONRPC_TEST_CHILD=1")
+	tr.Stderr = &stderr
+	defer tr.Close()
+
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":1}}`)
+	if err := tr.PerformRequest(&rc); err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	} else if string(rc.RawResponse) != `{"jsonrpc":"2.0","id":"1","result":1}` {
+		t.Errorf("wrong response %s", string(rc.RawResponse))
+	}
+
+	pid := tr.Pid()
+
+	// The child exits while the call is pending
+	rc = common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"2","method":"exit","params":{}}`)
+	if err := tr.PerformRequest(&rc); !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("pending call did not fail properly: %v", err)
+	} else if err.Error() != ErrConnectionLost.Error()+": process exited: exit status 3" {
+		t.Errorf("exit status was not reported: %s", err.Error())
+	}
+
+	// A new child is started
+	rc = common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"3","method":"sleep","params":{"delay":1}}`)
+	if err := tr.PerformRequest(&rc); err != nil {
+		t.Errorf("request to a new child failed: %s", err.Error())
+	}
+	if tr.Pid() == pid || tr.Pid() == 0 {
+		t.Errorf("new child was not started")
+	}
+
+	_ = tr.Close()
+	if !bytes.Contains(stderr.Bytes(), []byte("child started")) {
+		t.Errorf("child stderr was not passed through")
+	}
+}
+
+func TestSubprocess_Close(t *testing.T) {
+	tr := NewSubprocess(os.Args[0], "-test.run=TestSubprocessHelper")
+	tr.Env = append(os.Environ(), "GOJSONRPC_TEST_CHILD=stuck")
+	tr.ExitTimeout = 200 * time.Millisecond
+
+	if err := tr.Connect(); err != nil {
+		t.Fatalf("could not start the child: %s", err.Error())
+	}
+
+	closed := make(chan bool)
+	go func() {
+		_ = tr.Close()
+		close(closed)
+	}()
+
+	// The transport is not locked while waiting for the child
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan bool)
+	go func() {
+		_ = tr.Connected()
+		rc := common.EmptyRequestContext()
+		rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":1}}`)
+		if err := tr.PerformRequest(&rc); err != ErrTransportClosed {
+			t.Errorf("request to a closed transport did not fail properly: %v", err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("transport was locked while waiting for the child")
+	}
+
+	// The stuck child is killed
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("close did not kill the child")
+	}
+}
+
+func TestSubprocess_CloseReleasesPipes(t *testing.T) {
+	fds := func() int {
+		entries, err := ioutil.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("open files can not be counted here")
+		}
+		return len(entries)
+	}
+
+	tr := NewSubprocess(os.Args[0], "-test.run=TestSubprocessHelper")
+	tr.Env = append(os.Environ(), "GOJSONRPC_TEST_CHILD=1")
+	tr.Stderr = &bytes.Buffer{}
+
+	before := fds()
+	for k := 0; k < 10; k++ {
+		if err := tr.Connect(); err != nil {
+			t.Fatalf("%d could not start the child: %s", k, err.Error())
+		}
+		tr.mu.Lock()
+		conn := tr.conn
+		tr.mu.Unlock()
+		_ = conn.Close()
+
+		// Wait for the connection to be dropped, so that the next one starts a new child
+		for tr.Connected() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if after := fds(); after > before+2 {
+		t.Errorf("pipes leaked: %d open files before, %d after", before, after)
+	}
+	_ = tr.Close()
+}
@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Create a TLS config with the client certificate loaded from files (for mutual TLS)
+// The server certificate is verified against the given CA files or the system roots if there are none
+func NewClientTLSConfig(certFile string, keyFile string, caFiles ...string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if len(caFiles) == 0 {
+		return config, nil
+	}
+
+	config.RootCAs = x509.NewCertPool()
+	for _, file := range caFiles {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", file)
+		}
+	}
+
+	return config, nil
+}
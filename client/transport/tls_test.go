@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+func TestHttp_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	// A self-signed client certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	clientCert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	_ = ioutil.WriteFile(filepath.Join(dir, "client.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, "client.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":"` + r.TLS.PeerCertificates[0].Subject.CommonName + `"}`))
+	}))
+	httpServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	httpServer.TLS.ClientCAs.AddCert(clientCert)
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw}), 0600)
+
+	config, err := NewClientTLSConfig(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("could not create the config: %s", err.Error())
+	}
+
+	tr := &Http{Url: httpServer.URL, TLSConfig: config}
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"whoami"}`)
+	if err = tr.PerformRequest(&rc); err != nil || string(rc.RawResponse) != `{"jsonrpc":"2.0","id":"1","result":"alice"}` {
+		t.Errorf("Got response %s (%v)", string(rc.RawResponse), err)
+	}
+
+	// The defaults of the default transport are kept
+	defaults := http.DefaultTransport.(*http.Transport)
+	if client, ok := tr.client().Transport.(*http.Transport); !ok || client.TLSHandshakeTimeout != defaults.TLSHandshakeTimeout || client.IdleConnTimeout != defaults.IdleConnTimeout || client.Proxy == nil {
+		t.Errorf("Transport defaults were lost")
+	}
+
+	// No certificate, no call
+	config.Certificates = nil
+	tr = &Http{Url: httpServer.URL, TLSConfig: config}
+	rc = common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"whoami"}`)
+	if err = tr.PerformRequest(&rc); err == nil {
+		t.Errorf("Call without a certificate succeeded")
+	}
+
+	if _, err = NewClientTLSConfig(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "client.key")); err == nil {
+		t.Errorf("Bad CA file was accepted")
+	}
+}
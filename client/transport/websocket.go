@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+// WebSocket transport
+// A Persistent transport dialing a ws:// or wss:// Url
+type WebSocket struct {
+	Persistent
+	Url       string
+	Header    http.Header
+	TLSConfig *tls.Config
+	// Limit for the size of incoming messages, zero means websocket.DefaultMaxMessageSize
+	MaxMessageSize int64
+}
+
+// Create a new WebSocket transport for the given url
+func NewWebSocket(url string) *WebSocket {
+	t := &WebSocket{Url: url}
+	t.Dial = t.dial
+
+	return t
+}
+
+func (t *WebSocket) dial() (common.MessageConn, error) {
+	conn, err := websocket.Dial(t.Url, t.Header, t.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn.MaxMessageSize = t.MaxMessageSize
+
+	return conn, nil
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	servertransport "github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+type test_WebSocketHandler struct{}
+
+func (h test_WebSocketHandler) Handle_sleep(params struct {
+	Delay int `json:"delay"`
+}) (result int, jsonRpcError common.Error, err error) {
+	time.Sleep(time.Duration(params.Delay) * time.Millisecond)
+	result = params.Delay
+	return
+}
+
+func test_WebSocketServer() (*servertransport.WebSocketTransport, *httptest.Server, string) {
+	s := server.NewServer()
+	s.AddHandler(test_WebSocketHandler{}, "Handle_")
+
+	wst := servertransport.NewWebSocketTransport(s)
+	httpServer := httptest.NewServer(wst)
+
+	return wst, httpServer, "ws" + strings.TrimPrefix(httpServer.URL, "http")
+}
+
+func TestWebSocket_PerformRequest(t *testing.T) {
+	wst, httpServer, url := test_WebSocketServer()
+	defer httpServer.Close()
+
+	tr := NewWebSocket(url)
+	defer tr.Close()
+
+	notifications := make(chan common.Request, 1)
+	tr.OnNotification("event", func(n common.Request) {
+		notifications <- n
+	})
+
+	// Concurrent calls complete out of order and get their own responses
+	var wg sync.WaitGroup
+	for _, delay := range []string{"50", "0", "20"} {
+		wg.Add(1)
+		go func(delay string) {
+			defer wg.Done()
+
+			rc := common.EmptyRequestContext()
+			rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"` + delay + `","method":"sleep","params":{"delay":` + delay + `}}`)
+
+			if err := tr.PerformRequest(&rc); err != nil {
+				t.Errorf("request failed: %s", err.Error())
+			} else if string(rc.RawResponse) != `{"jsonrpc":"2.0","id":"`+delay+`","result":`+delay+`}` {
+				t.Errorf("wrong response %s", string(rc.RawResponse))
+			}
+		}(delay)
+	}
+	wg.Wait()
+
+	// Batch
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`[{"jsonrpc":"2.0","id":"b1","method":"sleep","params":{"delay":0}},{"jsonrpc":"2.0","method":"sleep","params":{"delay":0}}]`)
+	if err := tr.PerformRequest(&rc); err != nil {
+		t.Errorf("batch failed: %s", err.Error())
+	} else if string(rc.RawResponse) != `[{"jsonrpc":"2.0","id":"b1","result":0}]` {
+		t.Errorf("wrong batch response %s", string(rc.RawResponse))
+	}
+
+	// Server push
+	_ = wst.Broadcast("event", 1)
+	select {
+	case n := <-notifications:
+		if string(n.Params) != "1" {
+			t.Errorf("wrong notification params %s", string(n.Params))
+		}
+	case <-time.After(time.Second):
+		t.Errorf("notification was not delivered")
+	}
+
+	// Cancelled call
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rc = common.EmptyRequestContext()
+	rc.Ctx = ctx
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"c","method":"sleep","params":{"delay":100}}`)
+	if err := tr.PerformRequest(&rc); err != context.DeadlineExceeded {
+		t.Errorf("call was not cancelled: %v", err)
+	}
+}
+
+func TestWebSocket_Reconnect(t *testing.T) {
+	wst, httpServer, url := test_WebSocketServer()
+	defer httpServer.Close()
+
+	tr := NewWebSocket(url)
+	tr.ReconnectDelay = 10 * time.Millisecond
+	defer tr.Close()
+
+	connects := make(chan bool, 2)
+	tr.OnConnect = func() {
+		connects <- true
+	}
+
+	if err := tr.Connect(); err != nil {
+		t.Fatalf("could not connect: %s", err.Error())
+	}
+	<-connects
+
+	// The pending call fails when the server drops the connection
+	errs := make(chan error)
+	go func() {
+		rc := common.EmptyRequestContext()
+		rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":1000}}`)
+		errs <- tr.PerformRequest(&rc)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	wst.Close()
+
+	if err := <-errs; !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("pending call did not fail properly: %v", err)
+	}
+
+	select {
+	case <-connects:
+	case <-time.After(time.Second):
+		t.Fatalf("transport did not reconnect")
+	}
+
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"2","method":"sleep","params":{"delay":0}}`)
+	if err := tr.PerformRequest(&rc); err != nil {
+		t.Errorf("request after reconnection failed: %s", err.Error())
+	}
+
+	_ = tr.Close()
+	if err := tr.PerformRequest(&rc); err != ErrTransportClosed {
+		t.Errorf("closed transport performed a request")
+	}
+}
@@ -1,7 +1,11 @@
 package client
 
 import (
+	"encoding/json"
 	"log"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/yekhlakov/gojsonrpc/common"
 )
@@ -14,10 +18,165 @@ type Transport interface {
 }
 
 type Client struct {
-	T      Transport
-	logger *log.Logger
+	T        Transport
+	logger   *log.Logger
+	ids      IDGenerator
+	retry    *RetryPolicy
+	subs     *subscriptions
+	progress *progressHandlers
+	mu       sync.Mutex
+}
+
+// Retry policy of the client
+// Only the methods marked as idempotent are retried, and only after a transport error
+// or a JSON-RPC error with one of RetryCodes
+type RetryPolicy struct {
+	// Total number of attempts including the first one
+	MaxAttempts int
+	// Delay before the first retry, it is multiplied by Multiplier (2 by default) for each next retry
+	InitialDelay time.Duration
+	Multiplier   float64
+	// Upper bound of the delay, zero means no bound
+	MaxDelay time.Duration
+	// Fraction of the delay (0..1) that is randomized
+	Jitter            float64
+	RetryCodes        []json.Number
+	IdempotentMethods map[string]bool
+}
+
+// Source of JSON-RPC request ids
+// Implementations must be safe for concurrent use
+type IDGenerator interface {
+	NextID() (string, error)
+}
+
+// Generates decimal integers 1, 2, 3...
+type SequentialIDGenerator struct {
+	last uint64
+}
+
+// Generates random (version 4) UUIDs
+type UUIDv4Generator struct{}
+
+// Generates time-ordered (version 7) UUIDs
+type UUIDv7Generator struct{}
+
+// Generates hex-encoded strings of Size cryptographically random bytes (16 by default)
+type RandomIDGenerator struct {
+	Size int
+}
+
+// Returns the given Ids one by one and errors out when they are exhausted
+// Meant for golden tests
+type FixedIDGenerator struct {
+	Ids  []string
+	next int
+	mu   sync.Mutex
 }
 
 // Dummy interface for method signature containers
 type Handler interface {
 }
+
+// A batch of JSON-RPC calls and notifications sent as a single JSON array
+type Batch struct {
+	client   *Client
+	requests []common.Request
+	calls    []*BatchCall
+	sent     bool
+}
+
+// A handle for a single call of a Batch
+// Response and Err are filled in when the batch is sent
+type BatchCall struct {
+	Request  common.Request
+	Response common.Response
+	Err      error
+}
+
+// State of a circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// Circuit breaker settings
+type BreakerSettings struct {
+	// Trip after this many failures in a row, zero disables the check
+	ConsecutiveFailures int
+	// Trip when the failure rate over the sliding Window reaches ErrorRate (0..1),
+	// provided there were at least MinRequests requests in the window; zero rate disables the check
+	ErrorRate   float64
+	Window      time.Duration
+	MinRequests int
+	// How long the breaker stays open before letting probe requests through
+	OpenTimeout time.Duration
+	// Number of concurrent probe requests allowed in the half-open state (1 by default)
+	HalfOpenRequests int
+	// Track a separate breaker for each method instead of one for the whole transport
+	PerMethod bool
+	// JSON-RPC error codes that count as failures (transport errors always do)
+	FailureCodes []json.Number
+}
+
+// A Transport wrapper that stops calling the wrapped transport when it keeps failing
+type CircuitBreaker struct {
+	Next     Transport
+	Settings BreakerSettings
+	breakers map[string]*breaker
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+// State of a single breaker
+type breaker struct {
+	state       BreakerState
+	consecutive int
+	openedAt    time.Time
+	probes      int
+	events      []breakerEvent
+}
+
+// Outcome of a request in the sliding window
+type breakerEvent struct {
+	at     time.Time
+	failed bool
+}
+
+// A subscription to server-push events
+// Events are decoded into the element type of the channel given to Client.Subscribe
+type Subscription struct {
+	Id       string
+	client   *Client
+	channel  reflect.Value
+	queue    chan json.RawMessage
+	err      chan error
+	done     chan struct{}
+	closeErr error
+	mu       sync.Mutex
+}
+
+// Subscriptions of a client
+type subscriptions struct {
+	transport          NotificationSource
+	notificationMethod string
+	unsubscribeMethod  string
+	active             map[string]*Subscription
+	// Events that arrived before their subscription was registered
+	early map[string][]json.RawMessage
+	mu    sync.Mutex
+}
+
+// Handles the progress reports of a call
+// It is called by the transport as the reports come, so it must not block
+type ProgressHandler func(value json.RawMessage)
+
+// Progress handlers of the calls in flight, by token
+type progressHandlers struct {
+	transport NotificationSource
+	handlers  map[string]ProgressHandler
+	mu        sync.Mutex
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// The method of the notification cancelling an in-flight request
+const CancelRequestMethod = "$/cancelRequest"
+
+// Params of the cancel notification
+type CancelParams struct {
+	Id json.RawMessage `json:"id"`
+}
+
+// Create a new Canceller
+func NewCanceller() *Canceller {
+	return &Canceller{calls: map[string]*trackedCall{}}
+}
+
+// Make the request cancellable by its id
+// The request context gets a cancellable context; the returned function must be called when the request
+// is done, it reports whether the request was cancelled
+func (c *Canceller) Track(rc *RequestContext) (done func() bool) {
+	ctx, cancel := context.WithCancel(rc.GetContext())
+	rc.Ctx = ctx
+
+	id := rc.JsonRpcRequest.Id
+	call := &trackedCall{cancel: cancel}
+
+	c.mu.Lock()
+	// Requests with duplicate ids can not be told apart so only the first one is cancellable
+	_, duplicate := c.calls[id]
+	if id != "" && !duplicate {
+		c.calls[id] = call
+	}
+	c.mu.Unlock()
+
+	return func() bool {
+		c.mu.Lock()
+		if c.calls[id] == call {
+			delete(c.calls, id)
+		}
+		cancelled := call.cancelled
+		c.mu.Unlock()
+
+		cancel()
+		return cancelled
+	}
+}
+
+// Cancel the in-flight request with the given id, returns false if there is no such request
+func (c *Canceller) Cancel(id string) bool {
+	c.mu.Lock()
+	call, ok := c.calls[id]
+	if ok {
+		call.cancelled = true
+	}
+	c.mu.Unlock()
+
+	if ok {
+		call.cancel()
+	}
+
+	return ok
+}
+
+// Get the id of the request to cancel as a string (numbers are taken verbatim)
+func (p CancelParams) RequestId() string {
+	if len(p.Id) == 0 || string(p.Id) == "null" {
+		return ""
+	}
+
+	if s, err := strconv.Unquote(string(p.Id)); err == nil {
+		return s
+	}
+
+	return string(p.Id)
+}
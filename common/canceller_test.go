@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCanceller(t *testing.T) {
+	c := NewCanceller()
+
+	rc := EmptyRequestContext()
+	rc.JsonRpcRequest.Id = "1"
+	done := c.Track(&rc)
+
+	duplicate := EmptyRequestContext()
+	duplicate.JsonRpcRequest.Id = "1"
+	duplicateDone := c.Track(&duplicate)
+
+	if c.Cancel("2") {
+		t.Errorf("Unknown request was cancelled")
+	}
+
+	if !c.Cancel("1") {
+		t.Errorf("Request was not cancelled")
+	}
+
+	if rc.GetContext().Err() == nil || duplicate.GetContext().Err() != nil {
+		t.Errorf("Wrong contexts were cancelled")
+	}
+
+	if !done() || duplicateDone() {
+		t.Errorf("Cancellation was reported wrongly")
+	}
+
+	if c.Cancel("1") {
+		t.Errorf("Finished request was cancelled")
+	}
+}
+
+func TestCancelParams_RequestId(t *testing.T) {
+	testData := map[string]string{
+		`{"id":"abc"}`: "abc",
+		`{"id":42}`:    "42",
+		`{"id":null}`:  "",
+		`{}`:           "",
+	}
+
+	for raw, id := range testData {
+		p := CancelParams{}
+		_ = json.Unmarshal([]byte(raw), &p)
+		if p.RequestId() != id {
+			t.Errorf("%s got id %s", raw, p.RequestId())
+		}
+	}
+}
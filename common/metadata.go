@@ -0,0 +1,72 @@
+package common
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// Transport-level metadata of a request in a transport-neutral form
+// Transports put it into the Request Context Data under MetadataKey
+type Metadata struct {
+	// Name of the transport, e.g. "http", "websocket", "tcp"
+	Transport string
+	// Address of the other side
+	RemoteAddr string
+	// Headers of the request (or of the handshake request of a WebSocket connection), empty for other transports
+	Headers http.Header
+	// TLS state of the connection, nil for plain connections
+	TLS *tls.ConnectionState
+	// Headers to send with the response, only HTTP endpoints send them (persistent transports ignore them)
+	ResponseHeaders http.Header
+}
+
+// Create metadata of a request coming through the transport from the address
+func NewMetadata(transport string, remoteAddr string) *Metadata {
+	return &Metadata{
+		Transport:       transport,
+		RemoteAddr:      remoteAddr,
+		Headers:         http.Header{},
+		ResponseHeaders: http.Header{},
+	}
+}
+
+// Create metadata of an HTTP request (or a handshake request), the response headers are sent
+// with the given ones (e.g. those of the http.ResponseWriter), a new set is created if nil
+func NewHttpMetadata(transport string, r *http.Request, responseHeaders http.Header) *Metadata {
+	if responseHeaders == nil {
+		responseHeaders = http.Header{}
+	}
+
+	return &Metadata{
+		Transport:       transport,
+		RemoteAddr:      r.RemoteAddr,
+		Headers:         r.Header,
+		TLS:             r.TLS,
+		ResponseHeaders: responseHeaders,
+	}
+}
+
+// Get the first value of the request header
+func (m *Metadata) Header(name string) string {
+	return m.Headers.Get(name)
+}
+
+// Set a header of the response
+func (m *Metadata) SetResponseHeader(name string, value string) {
+	m.ResponseHeaders.Set(name, value)
+}
+
+// Add a cookie to the response
+func (m *Metadata) SetCookie(cookie *http.Cookie) {
+	if v := cookie.String(); v != "" {
+		m.ResponseHeaders.Add("Set-Cookie", v)
+	}
+}
+
+// Get the cookie of the request
+func (m *Metadata) Cookie(name string) (*http.Cookie, bool) {
+	r := http.Request{Header: m.Headers}
+
+	c, err := r.Cookie(name)
+	return c, err == nil
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com/rpc", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Request-Id", "abc")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "lol"})
+
+	responseHeaders := http.Header{}
+	m := NewHttpMetadata("http", r, responseHeaders)
+
+	if m.Transport != "http" || m.RemoteAddr != "10.0.0.1:1234" || m.Header("x-request-id") != "abc" {
+		t.Errorf("Got metadata %v", m)
+	}
+
+	if c, ok := m.Cookie("session"); !ok || c.Value != "lol" {
+		t.Errorf("Cookie was not found")
+	}
+
+	if _, ok := m.Cookie("nope"); ok {
+		t.Errorf("Unknown cookie was found")
+	}
+
+	m.SetResponseHeader("X-Result", "1")
+	m.SetCookie(&http.Cookie{Name: "session", Value: "kek", HttpOnly: true})
+	m.SetCookie(&http.Cookie{Name: "bad name"})
+
+	if responseHeaders.Get("X-Result") != "1" || len(responseHeaders["Set-Cookie"]) != 1 || responseHeaders.Get("Set-Cookie") != "session=kek; HttpOnly" {
+		t.Errorf("Got response headers %v", responseHeaders)
+	}
+
+	// Other transports have no headers
+	m = NewMetadata("tcp", "127.0.0.1:5555")
+	m.SetResponseHeader("X-Result", "1")
+	if m.Header("X-Request-Id") != "" || m.ResponseHeaders.Get("X-Result") != "1" {
+		t.Errorf("Got metadata %v", m)
+	}
+
+	rc := EmptyRequestContext()
+	if _, ok := rc.GetMetadata(); ok {
+		t.Errorf("Empty context has metadata")
+	}
+
+	rc.Data[MetadataKey] = m
+	if got, ok := rc.GetMetadata(); !ok || got != m {
+		t.Errorf("Metadata was not found")
+	}
+}
@@ -1,10 +1,19 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
 
+// Request Context Data keys used by transports
+const (
+	NotifierKey          = "transport.notifier"
+	ResponseSentHooksKey = "transport.response_sent_hooks"
+	CancellerKey         = "transport.canceller"
+	MetadataKey          = "transport.metadata"
+)
+
 // Create an empty Request Context
 func EmptyRequestContext() RequestContext {
 	return RequestContext{
@@ -14,7 +23,17 @@ func EmptyRequestContext() RequestContext {
 		RawRequest:      nil,
 		Logger:          nil,
 		Data:            map[string]interface{}{},
+		Ctx:             context.Background(),
+	}
+}
+
+// Get the Go context of the request (never nil)
+func (rc *RequestContext) GetContext() context.Context {
+	if rc.Ctx == nil {
+		return context.Background()
 	}
+
+	return rc.Ctx
 }
 
 func (rc *RequestContext) MakeEmptyResponse() {
@@ -65,6 +84,57 @@ func (rc *RequestContext) ParseRawResponse() (err error) {
 	return json.Unmarshal(rc.RawResponse, &rc.JsonRpcResponse)
 }
 
+// Check if a response should be sent for the processed request
+// Notifications get none unless they turned out to be unparseable or invalid
+func (rc *RequestContext) ShouldRespond() bool {
+	if !rc.JsonRpcRequest.IsNotification() {
+		return true
+	}
+
+	e := Error{}
+	if rc.JsonRpcResponse.Error == nil || json.Unmarshal(rc.JsonRpcResponse.Error, &e) != nil {
+		return false
+	}
+
+	return e.Code == ParseError.Code || e.Code == InvalidRequestError.Code
+}
+
+// Get the notifier of the persistent connection the request came through
+// There is none for one-shot transports like HTTP
+func (rc *RequestContext) GetNotifier() (Notifier, bool) {
+	n, ok := rc.Data[NotifierKey].(Notifier)
+	return n, ok
+}
+
+// Get the canceller of the persistent connection the request came through
+func (rc *RequestContext) GetCanceller() (*Canceller, bool) {
+	c, ok := rc.Data[CancellerKey].(*Canceller)
+	return c, ok
+}
+
+// Get the transport metadata of the request (headers, remote address etc.)
+func (rc *RequestContext) GetMetadata() (*Metadata, bool) {
+	m, ok := rc.Data[MetadataKey].(*Metadata)
+	return m, ok
+}
+
+// Register a function to be called once the response is sent (or would have been sent for a notification)
+// Only persistent transports call these functions
+func (rc *RequestContext) OnResponseSent(f func()) {
+	hooks, _ := rc.Data[ResponseSentHooksKey].([]func())
+	rc.Data[ResponseSentHooksKey] = append(hooks, f)
+}
+
+// Call the functions registered with OnResponseSent
+func (rc *RequestContext) ResponseSent() {
+	hooks, _ := rc.Data[ResponseSentHooksKey].([]func())
+	delete(rc.Data, ResponseSentHooksKey)
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
 // Apply a processing pipeline to the context
 func (rc *RequestContext) ApplyPipeline(stages *[]Stage) (ok bool) {
 	ok = true
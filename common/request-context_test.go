@@ -280,3 +280,31 @@ func TestRequestContext_ApplyPipeline2(t *testing.T) {
 		t.Errorf("pipeline was not applied correctly")
 	}
 }
+
+func TestRequestContext_ShouldRespond(t *testing.T) {
+	testData := []struct {
+		Request  Request
+		Error    *Error
+		Expected bool
+	}{
+		{Request{JsonRPC: "2.0", Id: "1", Method: "test"}, nil, true},
+		{Request{JsonRPC: "2.0", Method: "test"}, nil, false},
+		{Request{JsonRPC: "2.0", Method: "test"}, &MethodNotFoundError, false},
+		{Request{JsonRPC: "1.0", Method: "test"}, &InvalidRequestError, true},
+		{Request{}, &ParseError, true},
+	}
+
+	for k, data := range testData {
+		rc := EmptyRequestContext()
+		rc.JsonRpcRequest = data.Request
+		if data.Error != nil {
+			rc.MakeErrorResponse(*data.Error)
+		} else {
+			rc.MakeEmptyResponse()
+		}
+
+		if rc.ShouldRespond() != data.Expected {
+			t.Errorf("%d wrong response decision", k)
+		}
+	}
+}
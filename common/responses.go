@@ -29,6 +29,40 @@ var InternalError = Error{
     Message: "Internal error",
 }
 
+var RequestCancelledError = Error{
+    Code:    "-32800",
+    Message: "Request cancelled",
+}
+
+var UnauthorizedError = Error{
+    Code:    "-32001",
+    Message: "Unauthorized",
+}
+
+var ForbiddenError = Error{
+    Code:    "-32003",
+    Message: "Forbidden",
+}
+
+// Create a notification (a Request with no id) for the given method and params
+func MakeNotification(method string, params interface{}) (Request, error) {
+    p, err := json.Marshal(params)
+    if err != nil {
+        return Request{}, err
+    }
+
+    return Request{
+        JsonRPC: "2.0",
+        Method:  method,
+        Params:  p,
+    }, nil
+}
+
+// Check if the Request is a notification (that is it has a method but no id and expects no response)
+func (rq Request) IsNotification() bool {
+    return rq.Id == "" && rq.Method != ""
+}
+
 // Create a Response to the given Request and put the given Error in it
 func (rq Request) MakeErrorResponse(e Error) Response {
     errorData, err := json.Marshal(e)
@@ -113,3 +113,17 @@ func TestRequest_MakeResponse(t *testing.T) {
     }
 
 }
+
+func TestRequest_IsNotification(t *testing.T) {
+    if (Request{JsonRPC: "2.0", Id: "1", Method: "test"}).IsNotification() {
+        t.Errorf("request with id is a notification")
+    }
+
+    if !(Request{JsonRPC: "2.0", Method: "test"}).IsNotification() {
+        t.Errorf("request without id is not a notification")
+    }
+
+    if (Request{}).IsNotification() {
+        t.Errorf("empty request is a notification")
+    }
+}
@@ -1,14 +1,16 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"sync"
 )
 
 // General JSON-RPC request
 type Request struct {
 	JsonRPC string          `json:"jsonrpc"`
-	Id      string          `json:"id"`
+	Id      string          `json:"id,omitempty"`
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params"`
 }
@@ -41,6 +43,7 @@ type RequestContext struct {
 	RawResponse     json.RawMessage
 	Logger          *log.Logger
 	Data            map[string]interface{}
+	Ctx             context.Context
 }
 
 // Generalized processing stage
@@ -48,3 +51,32 @@ type RequestContext struct {
 // If a stage returns false, further stages won't be processed
 // If it is a pre-processing pipeline, the request won't be actually handled
 type Stage func(context *RequestContext) bool
+
+// A persistent connection exchanging whole JSON-RPC messages
+// (WebSocket messages, framed stream chunks etc.)
+// WriteMessage must be safe for concurrent use
+type MessageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(message []byte) error
+	Close() error
+}
+
+// Sends notifications back over the persistent connection a request came through
+// Transports put it into the Request Context Data under NotifierKey
+type Notifier interface {
+	Notify(method string, params interface{}) error
+	// Closed when the connection is closed
+	Done() <-chan struct{}
+}
+
+// Keeps track of the in-flight requests of a persistent connection so that they may be cancelled by id
+type Canceller struct {
+	calls map[string]*trackedCall
+	mu    sync.Mutex
+}
+
+// An in-flight request tracked by a Canceller
+type trackedCall struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
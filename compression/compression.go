@@ -0,0 +1,159 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// HTTP content codings (gzip and deflate) for the HTTP transports
+// Deflate is the zlib format as defined by the HTTP spec
+
+// Supported content codings
+const (
+	Gzip     = "gzip"
+	Deflate  = "deflate"
+	Identity = "identity"
+)
+
+// The Accept-Encoding header value listing the supported codings
+const AcceptEncoding = Gzip + ", " + Deflate
+
+// Default limit for the size of a decompressed body
+const DefaultMaxSize = 32 << 20
+
+// Default size below which bodies are not compressed
+const DefaultMinSize = 1024
+
+// The error returned when a decompressed body exceeds the size limit
+var ErrTooLarge = fmt.Errorf("decompressed body too large")
+
+// The error returned for an unknown content coding
+var ErrUnsupported = fmt.Errorf("unsupported content encoding")
+
+// Check if the coding is supported (an empty one means identity)
+func Supported(encoding string) bool {
+	switch normalize(encoding) {
+	case "", Identity, Gzip, Deflate:
+		return true
+	}
+
+	return false
+}
+
+func normalize(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "x-gzip" {
+		return Gzip
+	}
+
+	return encoding
+}
+
+// Compress the body with the coding
+func Compress(body []byte, encoding string) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	var w io.WriteCloser
+	switch normalize(encoding) {
+	case "", Identity:
+		return body, nil
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Deflate:
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, ErrUnsupported
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Read and decompress the body, no more than limit bytes of decompressed data are accepted
+// A non-positive limit means DefaultMaxSize
+func Decompress(r io.Reader, encoding string, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+
+	switch normalize(encoding) {
+	case "", Identity:
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case Deflate:
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, ErrUnsupported
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, ErrTooLarge
+	}
+
+	return body, nil
+}
+
+// Choose the coding for a response according to the Accept-Encoding header
+// Returns an empty string if the body should not be compressed
+func Negotiate(acceptEncoding string) string {
+	weights := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		weights[normalize(fields[0])] = q
+	}
+
+	best, bestQ := "", 0.0
+
+	// Gzip wins the ties as it comes first
+	for _, coding := range []string{Gzip, Deflate} {
+		q, ok := weights[coding]
+		if !ok {
+			// The wildcard applies to the codings not listed explicitly
+			q = weights["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,69 @@
+package compression
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	body := []byte(strings.Repeat(`{"jsonrpc":"2.0","id":"1","result":"lol"}`, 100))
+
+	for _, encoding := range []string{"", Identity, Gzip, "x-gzip", Deflate} {
+		compressed, err := Compress(body, encoding)
+		if err != nil {
+			t.Errorf("%s compression failed: %s", encoding, err.Error())
+			continue
+		}
+
+		if encoding != "" && encoding != Identity && len(compressed) >= len(body) {
+			t.Errorf("%s body was not compressed", encoding)
+		}
+
+		if decompressed, err := Decompress(bytes.NewReader(compressed), encoding, 0); err != nil || !bytes.Equal(decompressed, body) {
+			t.Errorf("%s body was not restored", encoding)
+		}
+
+		if _, err = Decompress(bytes.NewReader(compressed), encoding, int64(len(body)-1)); err != ErrTooLarge {
+			t.Errorf("%s size limit was not applied", encoding)
+		}
+	}
+
+	if _, err := Compress(body, "br"); err != ErrUnsupported {
+		t.Errorf("Unknown coding was accepted for compression")
+	}
+
+	if _, err := Decompress(bytes.NewReader(body), "br", 0); err != ErrUnsupported {
+		t.Errorf("Unknown coding was accepted for decompression")
+	}
+
+	if _, err := Decompress(bytes.NewReader(body), Gzip, 0); err == nil {
+		t.Errorf("Bad gzip stream was accepted")
+	}
+
+	if Supported("br") || !Supported("GZIP") || !Supported("") {
+		t.Errorf("Supported codings are wrong")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	testData := map[string]string{
+		"":                                "",
+		"identity":                        "",
+		"br":                              "",
+		"gzip":                            Gzip,
+		"deflate":                         Deflate,
+		"deflate, gzip":                   Gzip,
+		"gzip;q=0.5, deflate":             Deflate,
+		"gzip;q=0, deflate;q=0":           "",
+		"*":                               Gzip,
+		"gzip;q=0, *":                     Deflate,
+		"br, x-gzip;q=0.8, deflate;q=0.1": Gzip,
+	}
+
+	for header, encoding := range testData {
+		if got := Negotiate(header); got != encoding {
+			t.Errorf("%q got %q", header, got)
+		}
+	}
+}
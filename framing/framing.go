@@ -0,0 +1,230 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Message framing for stream connections (TCP, Unix sockets, stdio)
+
+// Default limit for the size of a single message
+const DefaultMaxMessageSize = 16 << 20
+
+// A way to split a byte stream into messages
+type Framer interface {
+	// Read a single message, messages longer than limit (DefaultMaxMessageSize if not positive) are rejected
+	ReadFrame(r *bufio.Reader, limit int64) ([]byte, error)
+	// Write a single message
+	WriteFrame(w io.Writer, message []byte) error
+}
+
+// Newline-delimited JSON: each message is a single line
+type Newline struct{}
+
+// LSP-style framing: a header block with Content-Length, an empty line and the message itself
+type ContentLength struct{}
+
+// Length-prefixed binary framing: a 4-byte big-endian length followed by the message
+type LengthPrefix struct{}
+
+// The error returned when a message exceeds the size limit
+var ErrMessageTooLarge = fmt.Errorf("message too large")
+
+// Get the effective size limit
+func maxSize(limit int64) int64 {
+	if limit <= 0 {
+		return DefaultMaxMessageSize
+	}
+
+	return limit
+}
+
+// Read a message of the announced length
+// The buffer grows as the data arrives, so a peer announcing a huge message does not make us allocate it up front
+func readMessage(r io.Reader, length int64) ([]byte, error) {
+	message := bytes.Buffer{}
+
+	n, err := io.CopyN(&message, r, length)
+	if n < length {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return message.Bytes(), nil
+}
+
+func (f Newline) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	limit = maxSize(limit)
+
+	for {
+		var line []byte
+
+		for {
+			chunk, err := r.ReadSlice('\n')
+			if int64(len(line)+len(chunk)) > limit+1 {
+				return nil, ErrMessageTooLarge
+			}
+			line = append(line, chunk...)
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				if err == io.EOF && len(bytes.TrimSpace(line)) > 0 {
+					return line, nil
+				}
+				return nil, err
+			}
+			break
+		}
+
+		// Skip empty lines
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (f Newline) WriteFrame(w io.Writer, message []byte) error {
+	// A message must stay on a single line
+	if bytes.ContainsAny(message, "\r\n") {
+		compact := bytes.Buffer{}
+		if err := json.Compact(&compact, message); err != nil {
+			return err
+		}
+		message = compact.Bytes()
+	}
+
+	frame := make([]byte, 0, len(message)+1)
+	frame = append(frame, message...)
+	frame = append(frame, '\n')
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func (f ContentLength) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseInt(strings.TrimSpace(header.Get("Content-Length")), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header")
+	}
+
+	if length < 0 || length > maxSize(limit) {
+		return nil, ErrMessageTooLarge
+	}
+
+	return readMessage(r, length)
+}
+
+func (f ContentLength) WriteFrame(w io.Writer, message []byte) error {
+	frame := make([]byte, 0, len(message)+32)
+	frame = append(frame, "Content-Length: "...)
+	frame = strconv.AppendInt(frame, int64(len(message)), 10)
+	frame = append(frame, "\r\n\r\n"...)
+	frame = append(frame, message...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func (f LengthPrefix) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(prefix[:]))
+	if length > maxSize(limit) {
+		return nil, ErrMessageTooLarge
+	}
+
+	return readMessage(r, length)
+}
+
+func (f LengthPrefix) WriteFrame(w io.Writer, message []byte) error {
+	if int64(len(message)) > 0xffffffff {
+		return ErrMessageTooLarge
+	}
+
+	frame := make([]byte, 4, len(message)+4)
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	frame = append(frame, message...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Get a framer by name: "newline", "content-length" or "length-prefix"
+func ByName(name string) (Framer, error) {
+	switch name {
+	case "newline":
+		return Newline{}, nil
+	case "content-length":
+		return ContentLength{}, nil
+	case "length-prefix":
+		return LengthPrefix{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown framing %s", name)
+}
+
+// A message connection over a byte stream
+type Conn struct {
+	rwc    io.ReadWriteCloser
+	br     *bufio.Reader
+	framer Framer
+	// Messages larger than this are rejected, zero means DefaultMaxMessageSize
+	MaxMessageSize int64
+	wmu            sync.Mutex
+}
+
+// Wrap a byte stream into a message connection, nil framer means Newline
+func NewConn(rwc io.ReadWriteCloser, framer Framer) *Conn {
+	if framer == nil {
+		framer = Newline{}
+	}
+
+	return &Conn{
+		rwc:    rwc,
+		br:     bufio.NewReader(rwc),
+		framer: framer,
+	}
+}
+
+// Read a single message
+func (c *Conn) ReadMessage() ([]byte, error) {
+	limit := c.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	return c.framer.ReadFrame(c.br, limit)
+}
+
+// Write a single message, safe for concurrent use
+func (c *Conn) WriteMessage(message []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.framer.WriteFrame(c.rwc, message)
+}
+
+// Close the underlying stream
+func (c *Conn) Close() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,102 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFramers(t *testing.T) {
+	messages := []string{`{"jsonrpc":"2.0","id":"1","method":"test"}`, `[]`, strings.Repeat("x", 5000)}
+
+	for _, framer := range []Framer{Newline{}, ContentLength{}, LengthPrefix{}} {
+		buffer := bytes.Buffer{}
+
+		for _, m := range messages {
+			if err := framer.WriteFrame(&buffer, []byte(m)); err != nil {
+				t.Errorf("%T could not write frame: %s", framer, err.Error())
+			}
+		}
+
+		r := bufio.NewReaderSize(&buffer, 16)
+		for _, m := range messages {
+			f, err := framer.ReadFrame(r, DefaultMaxMessageSize)
+			if err != nil {
+				t.Errorf("%T could not read frame: %s", framer, err.Error())
+			} else if string(f) != m {
+				t.Errorf("%T frame was not read properly", framer)
+			}
+		}
+
+		if _, err := framer.ReadFrame(r, DefaultMaxMessageSize); err == nil {
+			t.Errorf("%T read a frame from an empty stream", framer)
+		}
+
+		buffer.Reset()
+		_ = framer.WriteFrame(&buffer, []byte(messages[0]))
+		if _, err := framer.ReadFrame(bufio.NewReader(&buffer), 10); err != ErrMessageTooLarge {
+			t.Errorf("%T read a frame over the limit", framer)
+		}
+	}
+}
+
+func TestNewline_WriteFrame(t *testing.T) {
+	buffer := bytes.Buffer{}
+
+	_ = Newline{}.WriteFrame(&buffer, []byte("{\n  \"a\": 1\n}"))
+	if buffer.String() != "{\"a\":1}\n" {
+		t.Errorf("multiline message was not compacted: %s", buffer.String())
+	}
+}
+
+func TestContentLength_ReadFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Type: application/json\r\ncontent-length: 2\r\n\r\n[]"))
+
+	if f, err := (ContentLength{}).ReadFrame(r, DefaultMaxMessageSize); err != nil || string(f) != "[]" {
+		t.Errorf("frame with extra headers was not read")
+	}
+
+	r = bufio.NewReader(strings.NewReader("Content-Type: application/json\r\n\r\n[]"))
+	if _, err := (ContentLength{}).ReadFrame(r, DefaultMaxMessageSize); err == nil {
+		t.Errorf("frame without length was read")
+	}
+}
+
+func TestReadFrame_Limits(t *testing.T) {
+	testData := []struct {
+		Framer Framer
+		Frame  string
+		Limit  int64
+		Err    error
+	}{
+		// Announced lengths over the limit are rejected before anything is allocated
+		{ContentLength{}, "Content-Length: 9999999999\r\n\r\n[]", DefaultMaxMessageSize, ErrMessageTooLarge},
+		{ContentLength{}, "Content-Length: 9999999999\r\n\r\n[]", 0, ErrMessageTooLarge},
+		{ContentLength{}, "Content-Length: 3\r\n\r\n[]", 2, ErrMessageTooLarge},
+		{LengthPrefix{}, "\xff\xff\xff\xff[]", 0, ErrMessageTooLarge},
+		{Newline{}, strings.Repeat("x", DefaultMaxMessageSize+2) + "\n", -1, ErrMessageTooLarge},
+		// Truncated messages
+		{ContentLength{}, "Content-Length: 1000\r\n\r\n[]", 0, io.ErrUnexpectedEOF},
+		{LengthPrefix{}, "\x00\x00\x03\xe8[]", 0, io.ErrUnexpectedEOF},
+	}
+
+	for k, data := range testData {
+		if _, err := data.Framer.ReadFrame(bufio.NewReader(strings.NewReader(data.Frame)), data.Limit); err != data.Err {
+			t.Errorf("%d %T: unexpected error %v", k, data.Framer, err)
+		}
+	}
+}
+
+func TestByName(t *testing.T) {
+	for _, name := range []string{"newline", "content-length", "length-prefix"} {
+		if _, err := ByName(name); err != nil {
+			t.Errorf("framer %s was not found", name)
+		}
+	}
+
+	if _, err := ByName("lol"); err == nil {
+		t.Errorf("unknown framer was found")
+	}
+}
@@ -0,0 +1,106 @@
+package peer
+
+import (
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+// Connect to a WebSocket url and start a Peer over the connection
+func DialWebSocket(url string, s *server.JsonRpcServer) (*Peer, error) {
+	conn, err := websocket.Dial(url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p := New(conn, s)
+	p.Transport = "websocket"
+	p.RemoteAddr = url
+	p.Start()
+
+	return p, nil
+}
+
+// Connect to a TCP address or a Unix socket and start a Peer over the connection
+func Dial(network string, address string, framer framing.Framer, s *server.JsonRpcServer) (*Peer, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	p := New(framing.NewConn(conn, framer), s)
+	p.Transport = network
+	p.RemoteAddr = conn.RemoteAddr().String()
+	p.Start()
+
+	return p, nil
+}
+
+// The standard input and output of the process
+type stdio struct{}
+
+func (stdio) Read(b []byte) (int, error) {
+	return os.Stdin.Read(b)
+}
+
+func (stdio) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
+
+func (stdio) Close() error {
+	return os.Stdin.Close()
+}
+
+// Create a Peer over the standard input and output with Content-Length framing
+// Run should be called to start serving
+func NewStdio(s *server.JsonRpcServer) *Peer {
+	p := New(framing.NewConn(stdio{}, framing.ContentLength{}), s)
+	p.Transport = "stdio"
+	p.RemoteAddr = "stdio"
+
+	return p
+}
+
+// Create an http.Handler that upgrades connections to WebSocket and serves a Peer over each
+// onConnect is called for each new peer before its read loop starts
+func NewWebSocketHandler(s *server.JsonRpcServer, onConnect func(p *Peer)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+
+		p := New(conn, s)
+		p.Transport = "websocket"
+		p.RemoteAddr = r.RemoteAddr
+		p.HttpRequest = r
+		if onConnect != nil {
+			onConnect(p)
+		}
+
+		_ = p.Run()
+	})
+}
+
+// Accept connections from the listener and serve a Peer over each until the listener is closed
+// onConnect is called for each new peer before its read loop starts
+func Accept(l net.Listener, framer framing.Framer, s *server.JsonRpcServer, onConnect func(p *Peer)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		p := New(framing.NewConn(conn, framer), s)
+		p.Transport = l.Addr().Network()
+		p.RemoteAddr = conn.RemoteAddr().String()
+		if onConnect != nil {
+			onConnect(p)
+		}
+		p.Start()
+	}
+}
@@ -0,0 +1,380 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/yekhlakov/gojsonrpc/client"
+	"github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// The key of Request Context Data holding the *Peer the request came from
+const PeerKey = "peer"
+
+// The error for calls that were pending when the connection was closed
+var ErrPeerClosed = errors.New("peer connection closed")
+
+// The error response for requests coming while MaxInFlight requests are being served
+var BusyError = common.Error{
+	Code:    "-32000",
+	Message: "Too many requests in flight",
+}
+
+// The default limit of incoming requests served at once
+const DefaultMaxInFlight = 64
+
+// A JSON-RPC peer
+// Both sides of a persistent connection may call methods of each other: incoming requests are served
+// by Server, incoming responses are routed to the pending calls, all by a single read loop.
+// A Peer is a client.Transport too, so a client.Client may be built on top of it.
+type Peer struct {
+	Server *server.JsonRpcServer
+	IDs    client.IDGenerator
+	// Stages applied to outgoing calls
+	PreProcessingStages  []common.Stage
+	PostProcessingStages []common.Stage
+	// Maximum number of incoming requests served at once, zero means DefaultMaxInFlight
+	// Requests over the limit get BusyError right away: the read loop can not wait for a slot,
+	// as the responses the handlers in flight may be waiting for come through it too
+	MaxInFlight int
+	// The name of the transport and the address of the other side, passed to the server in the metadata
+	Transport  string
+	RemoteAddr string
+	// The handshake request for peers accepted over HTTP (nil otherwise), its headers go to the metadata
+	HttpRequest *http.Request
+	conn        common.MessageConn
+	pending     transport.PendingCalls
+	slots       chan struct{}
+	slotsOnce   sync.Once
+	canceller   *common.Canceller
+	logger      *log.Logger
+	ctx         context.Context
+	cancel      context.CancelFunc
+	err         error
+	mu          sync.Mutex
+	closeOnce   sync.Once
+}
+
+// Create a new Peer over the connection, serving requests with the given server (nil means no methods)
+// The read loop is not started until Run is called
+func New(conn common.MessageConn, s *server.JsonRpcServer) *Peer {
+	if s == nil {
+		s = server.NewServer()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Peer{
+		Server:    s,
+		IDs:       &client.RandomIDGenerator{},
+		conn:      conn,
+		canceller: common.NewCanceller(),
+		logger:    log.New(ioutil.Discard, "", 0),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Get the peer the request came from
+func GetPeer(rc *common.RequestContext) (*Peer, bool) {
+	p, ok := rc.Data[PeerKey].(*Peer)
+	return p, ok
+}
+
+// Set the logger for the peer and its server
+func (p *Peer) SetLogger(logger *log.Logger) error {
+	if logger == nil {
+		return fmt.Errorf("nil logger not allowed")
+	}
+
+	p.logger = logger
+	p.Server.Logger = logger
+
+	return nil
+}
+
+// Run the read loop until the connection fails or the peer is closed
+func (p *Peer) Run() error {
+	for {
+		message, err := p.conn.ReadMessage()
+		if err != nil {
+			_ = p.shutdown(fmt.Errorf("%w: %s", ErrPeerClosed, err.Error()))
+			return err
+		}
+
+		p.dispatch(message)
+	}
+}
+
+// Start the read loop in the background
+func (p *Peer) Start() {
+	go func() { _ = p.Run() }()
+}
+
+// Get a channel that is closed when the peer is closed
+func (p *Peer) Done() <-chan struct{} {
+	return p.ctx.Done()
+}
+
+// Get the error that closed the peer
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Close the connection, pending calls fail with ErrPeerClosed
+// (when the connection fails, they get an error wrapping ErrPeerClosed)
+func (p *Peer) Close() error {
+	return p.shutdown(ErrPeerClosed)
+}
+
+func (p *Peer) shutdown(reason error) (err error) {
+	p.closeOnce.Do(func() {
+		p.mu.Lock()
+		p.err = reason
+		p.mu.Unlock()
+
+		p.pending.Fail(reason)
+
+		p.cancel()
+		err = p.conn.Close()
+	})
+
+	return
+}
+
+// Route an incoming message: requests go to the server, responses go to the pending calls
+func (p *Peer) dispatch(message []byte) {
+	trimmed := bytes.TrimLeft(message, " \t\r\n")
+	if len(trimmed) == 0 {
+		return
+	}
+
+	probes, batch, err := transport.ProbeMessage(trimmed)
+	if err != nil || len(probes) == 0 || !probes[0].IsResponse() {
+		// Requests, notifications and garbage are all up to the server
+		p.serveLimited(message, probes)
+		return
+	}
+
+	if !batch {
+		p.deliver(probes[0].MessageId(), message)
+		return
+	}
+
+	// A batch of responses, the calls are single so each response goes on its own
+	var items []json.RawMessage
+	_ = json.Unmarshal(trimmed, &items)
+	for k, item := range items {
+		p.deliver(probes[k].MessageId(), item)
+	}
+}
+
+// Deliver a response to the pending call
+func (p *Peer) deliver(id string, response json.RawMessage) {
+	if !p.pending.Deliver([]string{id}, response) {
+		p.logger.Println("unexpected response", string(response))
+	}
+}
+
+// Serve an incoming message in the background if there is a free slot, reject it otherwise
+func (p *Peer) serveLimited(message []byte, probes []transport.MessageProbe) {
+	// Cancellation must get through even when the peer is busy, it is cheap so it is handled right here
+	if len(probes) == 1 && probes[0].Method == common.CancelRequestMethod {
+		p.serve(message)
+		return
+	}
+
+	p.slotsOnce.Do(func() {
+		limit := p.MaxInFlight
+		if limit <= 0 {
+			limit = DefaultMaxInFlight
+		}
+		p.slots = make(chan struct{}, limit)
+	})
+
+	select {
+	case p.slots <- struct{}{}:
+		go func() {
+			defer func() { <-p.slots }()
+			p.serve(message)
+		}()
+	default:
+		p.reject(probes)
+	}
+}
+
+// Respond with BusyError to every request of the message (notifications are dropped)
+func (p *Peer) reject(probes []transport.MessageProbe) {
+	responses := []common.Response{}
+	for _, probe := range probes {
+		id := probe.MessageId()
+		if id == "" {
+			continue
+		}
+
+		r := common.Request{JsonRPC: "2.0", Id: id}
+		responses = append(responses, r.MakeErrorResponse(BusyError))
+	}
+
+	p.logger.Println("too many requests in flight, rejected", len(responses))
+
+	var raw []byte
+	switch {
+	case len(responses) == 0:
+		return
+	case len(probes) == 1:
+		raw, _ = json.Marshal(responses[0])
+	default:
+		raw, _ = json.Marshal(responses)
+	}
+
+	if err := p.send(raw); err != nil {
+		p.logger.Println("response write error", err.Error())
+	}
+}
+
+// Serve an incoming request (or batch) and send the response
+func (p *Peer) serve(message []byte) {
+	rc := common.EmptyRequestContext()
+	rc.Logger = p.logger
+	rc.Ctx = p.ctx
+	rc.Data[PeerKey] = p
+	rc.Data[common.NotifierKey] = p
+	rc.Data[common.CancellerKey] = p.canceller
+	rc.RawRequest = message
+
+	if p.HttpRequest != nil {
+		rc.Data[common.MetadataKey] = common.NewHttpMetadata(p.Transport, p.HttpRequest, nil)
+	} else {
+		rc.Data[common.MetadataKey] = common.NewMetadata(p.Transport, p.RemoteAddr)
+	}
+
+	_ = p.Server.ProcessRawInput(&rc)
+
+	if len(rc.RawResponse) > 0 && rc.ShouldRespond() {
+		if err := p.conn.WriteMessage(rc.RawResponse); err != nil {
+			p.logger.Println("response write error", err.Error())
+		}
+	}
+
+	rc.ResponseSent()
+}
+
+// Send a request to the other side and wait for the response
+// Implements client.Transport, so only single requests and notifications are supported
+func (p *Peer) PerformRequest(rc *common.RequestContext) error {
+	rc.ApplyPipeline(&p.PreProcessingStages)
+
+	probe := transport.MessageProbe{}
+	if err := json.Unmarshal(rc.RawRequest, &probe); err != nil {
+		return err
+	}
+	id := probe.MessageId()
+
+	if id == "" {
+		if err := p.send(rc.RawRequest); err != nil {
+			return err
+		}
+		rc.ApplyPipeline(&p.PostProcessingStages)
+		return nil
+	}
+
+	call, err := p.pending.Add([]string{id})
+	if err != nil {
+		return err
+	}
+
+	// The closing peer fails the pending calls, a call added after that must not wait
+	if err = p.send(rc.RawRequest); err != nil {
+		p.pending.Remove(call)
+		return err
+	}
+
+	select {
+	case result := <-call:
+		if result.Err != nil {
+			return result.Err
+		}
+		rc.RawResponse = result.Raw
+	case <-rc.GetContext().Done():
+		p.pending.Remove(call)
+		// Let the other side stop working on it
+		_ = p.Notify(common.CancelRequestMethod, common.CancelParams{Id: probe.Id})
+		return rc.GetContext().Err()
+	}
+
+	rc.ApplyPipeline(&p.PostProcessingStages)
+	return nil
+}
+
+// Write a raw message unless the peer is closed
+func (p *Peer) send(message []byte) error {
+	if err := p.Err(); err != nil {
+		return err
+	}
+
+	return p.conn.WriteMessage(message)
+}
+
+func (p *Peer) AddPreProcessingStage(stage common.Stage) {
+	p.PreProcessingStages = append(p.PreProcessingStages, stage)
+}
+
+func (p *Peer) AddPostProcessingStage(stage common.Stage) {
+	p.PostProcessingStages = append(p.PostProcessingStages, stage)
+}
+
+// Call a method of the other side
+func (p *Peer) Call(ctx context.Context, method string, params interface{}) (response common.Response, err error) {
+	id, err := p.IDs.NextID()
+	if err != nil {
+		return
+	}
+
+	rc := common.EmptyRequestContext()
+	rc.Logger = p.logger
+	rc.Ctx = ctx
+
+	if rc.JsonRpcRequest, err = common.MakeNotification(method, params); err != nil {
+		return
+	}
+	rc.JsonRpcRequest.Id = id
+
+	if err = rc.RebuildRawRequest(); err != nil {
+		return
+	}
+
+	if err = p.PerformRequest(&rc); err != nil {
+		return
+	}
+
+	err = rc.ParseRawResponse()
+	return rc.JsonRpcResponse, err
+}
+
+// Send a notification to the other side
+func (p *Peer) Notify(method string, params interface{}) error {
+	n, err := common.MakeNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return p.send(raw)
+}
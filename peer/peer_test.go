@@ -0,0 +1,301 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/client"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+// Agent side methods
+type test_AgentHandler struct{}
+
+func (h test_AgentHandler) Handle_status(params struct{}) (result string, jsonRpcError common.Error, err error) {
+	result = "idle"
+	return
+}
+
+// Controller side methods, calling back into the agent
+type test_ControllerHandler struct{}
+
+func (h test_ControllerHandler) Handle_register(rc *common.RequestContext, params struct {
+	Name string `json:"name"`
+}) (result string, jsonRpcError common.Error, err error) {
+	p, _ := GetPeer(rc)
+
+	response, err := p.Call(rc.GetContext(), "status", nil)
+	if err != nil {
+		return
+	}
+
+	result = params.Name + " is " + string(response.Result)
+	return
+}
+
+func TestPeer(t *testing.T) {
+	controllerServer := server.NewServer()
+	controllerServer.AddHandler(test_ControllerHandler{}, "Handle_")
+
+	agentServer := server.NewServer()
+	agentServer.AddHandler(test_AgentHandler{}, "Handle_")
+
+	controllers := make(chan *Peer, 1)
+	httpServer := httptest.NewServer(NewWebSocketHandler(controllerServer, func(p *Peer) {
+		controllers <- p
+	}))
+	defer httpServer.Close()
+
+	agent, err := DialWebSocket("ws"+strings.TrimPrefix(httpServer.URL, "http"), agentServer)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	defer agent.Close()
+
+	controller := <-controllers
+
+	// The agent calls the controller which calls the agent back within the same call
+	response, err := agent.Call(context.Background(), "register", map[string]string{"name": "lol"})
+	if err != nil {
+		t.Errorf("call failed: %s", err.Error())
+	} else if string(response.Result) != `"lol is \"idle\""` {
+		t.Errorf("wrong result %s", string(response.Result))
+	}
+
+	// The controller calls the agent on its own, through a client
+	c := client.New()
+	_ = c.SetTransport(controller)
+
+	response, err = c.Request("status", nil)
+	if err != nil || string(response.Result) != `"idle"` {
+		t.Errorf("controller call failed")
+	}
+
+	response, err = c.Request("nope", nil)
+	if err != nil || response.Error == nil {
+		t.Errorf("unknown method did not produce an error")
+	}
+}
+
+// Reports the metadata of the request
+type test_MetadataHandler struct{}
+
+func (h test_MetadataHandler) Handle_whoami(rc *common.RequestContext, params struct{}) (result string, jsonRpcError common.Error, err error) {
+	if m, ok := rc.GetMetadata(); ok {
+		result = m.Transport + " " + m.Header("Authorization")
+	}
+	return
+}
+
+func TestPeer_Metadata(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_MetadataHandler{}, "Handle_")
+
+	httpServer := httptest.NewServer(NewWebSocketHandler(s, nil))
+	defer httpServer.Close()
+
+	// The headers of the handshake request are passed to the server
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), http.Header{"Authorization": {"Bearer x"}}, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	p := New(conn, nil)
+	p.Start()
+	defer p.Close()
+
+	response, err := p.Call(context.Background(), "whoami", nil)
+	if err != nil {
+		t.Fatalf("call failed: %s", err.Error())
+	} else if string(response.Result) != `"websocket Bearer x"` {
+		t.Errorf("wrong metadata %s", string(response.Result))
+	}
+
+	// Peers over plain streams get the transport and the address
+	a, b := net.Pipe()
+	left := New(framing.NewConn(a, framing.Newline{}), nil)
+	right := New(framing.NewConn(b, framing.Newline{}), s)
+	right.Transport = "pipe"
+	defer left.Close()
+	defer right.Close()
+	left.Start()
+	right.Start()
+
+	response, err = left.Call(context.Background(), "whoami", nil)
+	if err != nil {
+		t.Fatalf("call failed: %s", err.Error())
+	} else if string(response.Result) != `"pipe "` {
+		t.Errorf("wrong metadata %s", string(response.Result))
+	}
+}
+
+func TestPeer_Close(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err.Error())
+	}
+	defer l.Close()
+
+	agents := make(chan *Peer, 1)
+	go func() {
+		_ = Accept(l, framing.Newline{}, nil, func(p *Peer) {
+			agents <- p
+		})
+	}()
+
+	controller, err := Dial("tcp", l.Addr().String(), framing.Newline{}, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+
+	agent := <-agents
+
+	// The agent never answers this one
+	agent.Server.AddHandler(test_SlowHandler{}, "Handle_")
+
+	errs := make(chan error)
+	go func() {
+		_, err := controller.Call(context.Background(), "slow", nil)
+		errs <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	_ = controller.Close()
+
+	if err = <-errs; !errors.Is(err, ErrPeerClosed) {
+		t.Errorf("pending call did not fail properly: %v", err)
+	}
+
+	select {
+	case <-agent.Done():
+	case <-time.After(time.Second):
+		t.Errorf("other side was not closed")
+	}
+
+	if err = controller.Notify("test", nil); err == nil {
+		t.Errorf("notification was sent through a closed peer")
+	}
+}
+
+type test_SlowHandler struct{}
+
+func (h test_SlowHandler) Handle_slow(rc *common.RequestContext, params interface{}) (result string, jsonRpcError common.Error, err error) {
+	<-rc.GetContext().Done()
+	return
+}
+
+func TestPeer_Cancel(t *testing.T) {
+	a, b := net.Pipe()
+
+	left := New(framing.NewConn(a, framing.Newline{}), nil)
+	right := New(framing.NewConn(b, framing.Newline{}), nil)
+	defer left.Close()
+	defer right.Close()
+
+	cancelled := make(chan error, 1)
+	right.Server.AddHandler(test_CancelHandler{cancelled}, "Handle_")
+
+	left.Start()
+	right.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := left.Call(ctx, "wait", nil); err != context.DeadlineExceeded {
+		t.Errorf("expected the call to time out, got %v", err)
+	}
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("handler was not cancelled: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler did not finish")
+	}
+}
+
+type test_CancelHandler struct {
+	cancelled chan error
+}
+
+func (h test_CancelHandler) Handle_wait(rc *common.RequestContext, params interface{}) (result string, jsonRpcError common.Error, err error) {
+	select {
+	case <-rc.GetContext().Done():
+		h.cancelled <- rc.GetContext().Err()
+	case <-time.After(5 * time.Second):
+		h.cancelled <- nil
+	}
+	return
+}
+
+type test_BusyHandler struct {
+	started   chan bool
+	cancelled chan error
+}
+
+func (h test_BusyHandler) Handle_wait(rc *common.RequestContext, params interface{}) (result string, jsonRpcError common.Error, err error) {
+	h.started <- true
+	<-rc.GetContext().Done()
+	h.cancelled <- rc.GetContext().Err()
+	return
+}
+
+func TestPeer_MaxInFlight(t *testing.T) {
+	a, b := net.Pipe()
+
+	left := New(framing.NewConn(a, framing.Newline{}), nil)
+	right := New(framing.NewConn(b, framing.Newline{}), nil)
+	defer left.Close()
+	defer right.Close()
+
+	h := test_BusyHandler{started: make(chan bool, 1), cancelled: make(chan error, 1)}
+	right.Server.AddHandler(h, "Handle_")
+	right.MaxInFlight = 1
+
+	left.Start()
+	right.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := left.Call(ctx, "wait", nil)
+		errs <- err
+	}()
+	<-h.started
+
+	// The only slot is taken, the next request is rejected instead of waiting
+	response, err := left.Call(context.Background(), "wait", nil)
+	if err != nil {
+		t.Fatalf("call failed: %s", err.Error())
+	}
+	if !strings.Contains(string(response.Error), BusyError.Code.String()) {
+		t.Errorf("expected a busy error, got %s", string(response.Error))
+	}
+
+	// Cancellation gets through while the peer is busy
+	cancel()
+
+	select {
+	case err = <-h.cancelled:
+		if err != context.Canceled {
+			t.Errorf("handler was not cancelled: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("cancellation did not get through")
+	}
+
+	if err = <-errs; err != context.Canceled {
+		t.Errorf("expected the call to be cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Progress reporting for long-running calls
+// The caller puts a token into the params (under TokenField); the handler reports progress with the Reporter
+// taken from the Request Context, and the reports are sent back as notifications carrying the same token.
+// Reports are only sent over persistent transports, elsewhere they are dropped.
+
+// The method of the progress notifications
+const Method = "$/progress"
+
+// The field of the request params holding the progress token
+const TokenField = "progressToken"
+
+// The key of Request Context Data holding the *Reporter
+const Key = "progress.reporter"
+
+// Params of the progress notifications
+type Params struct {
+	Token json.RawMessage `json:"token"`
+	Value json.RawMessage `json:"value"`
+}
+
+// A typical progress value
+type Value struct {
+	Message string `json:"message,omitempty"`
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total,omitempty"`
+}
+
+// Sends progress notifications for a single call
+// Reports coming faster than the interval are coalesced and only the latest one is sent.
+// Nothing is sent after the response. A nil Reporter silently drops all reports.
+type Reporter struct {
+	token    json.RawMessage
+	notifier common.Notifier
+	interval time.Duration
+	last     time.Time
+	pending  json.RawMessage
+	// Cancels the scheduled flush
+	cancel  func() bool
+	stopped bool
+	mu      sync.Mutex
+	// Notifications being sent, Stop waits for them
+	sends sync.WaitGroup
+	now   func() time.Time
+	after func(d time.Duration, f func()) func() bool
+}
+
+// Create a pre-processing stage giving the requests that carry a progress token a Reporter
+// sending at most one notification per interval
+func Stage(interval time.Duration) common.Stage {
+	return func(rc *common.RequestContext) bool {
+		// The Data is shared by the requests of a batch, so a stale reporter must not leak to the next one
+		delete(rc.Data, Key)
+
+		n, ok := rc.GetNotifier()
+		if !ok {
+			return true
+		}
+
+		token := Token(rc.JsonRpcRequest.Params)
+		if token == nil {
+			return true
+		}
+
+		r := NewReporter(n, token, interval)
+		rc.Data[Key] = r
+		rc.OnResponseSent(r.Stop)
+
+		return true
+	}
+}
+
+// Create a Reporter sending notifications with the given token through the notifier
+func NewReporter(n common.Notifier, token json.RawMessage, interval time.Duration) *Reporter {
+	return &Reporter{token: token, notifier: n, interval: interval}
+}
+
+// Get the progress token from the request params, nil if there is none
+func Token(params json.RawMessage) json.RawMessage {
+	trimmed := bytes.TrimLeft(params, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(trimmed, &fields) != nil {
+		return nil
+	}
+
+	token := fields[TokenField]
+	if len(token) == 0 || string(token) == "null" {
+		return nil
+	}
+
+	return token
+}
+
+// Get the Reporter of the request, nil if progress can not be reported
+func From(rc *common.RequestContext) *Reporter {
+	r, _ := rc.Data[Key].(*Reporter)
+	return r
+}
+
+func (r *Reporter) clock() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+
+	return r.now()
+}
+
+// Call f after the delay, return the function cancelling the call
+func (r *Reporter) schedule(d time.Duration, f func()) func() bool {
+	if r.after == nil {
+		return time.AfterFunc(d, f).Stop
+	}
+
+	return r.after(d, f)
+}
+
+// Report the progress, the value may be anything marshalable (e.g. Value)
+func (r *Reporter) Report(value interface{}) error {
+	if r == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+
+	now := r.clock()
+	wait := r.interval - now.Sub(r.last)
+	if wait <= 0 {
+		r.pending = nil
+		r.last = now
+		r.sends.Add(1)
+		r.mu.Unlock()
+		return r.send(raw)
+	}
+
+	// Send the latest value once the interval passes
+	r.pending = raw
+	if r.cancel == nil {
+		r.cancel = r.schedule(wait, r.flush)
+	}
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Report the progress as a Value
+func (r *Reporter) Update(done int64, total int64, message string) error {
+	return r.Report(Value{Message: message, Done: done, Total: total})
+}
+
+// Send the coalesced report
+func (r *Reporter) flush() {
+	r.mu.Lock()
+
+	r.cancel = nil
+	raw := r.pending
+	if r.stopped || raw == nil {
+		r.mu.Unlock()
+		return
+	}
+
+	r.pending = nil
+	r.last = r.clock()
+	r.sends.Add(1)
+	r.mu.Unlock()
+
+	_ = r.send(raw)
+}
+
+// Send a notification without holding the lock (the notifier may take a while or report again)
+// The send must be added to r.sends under lock, before the reporter is stopped
+func (r *Reporter) send(raw json.RawMessage) error {
+	defer r.sends.Done()
+
+	return r.notifier.Notify(Method, Params{Token: r.token, Value: raw})
+}
+
+// Stop reporting (e.g. once the response is sent), pending reports are dropped
+// Waits for the notification being sent, so nothing is sent after Stop returns
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.stopped = true
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	r.mu.Unlock()
+
+	r.sends.Wait()
+}
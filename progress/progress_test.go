@@ -0,0 +1,187 @@
+package progress
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+type test_Notifier struct {
+	values []string
+	mu     sync.Mutex
+}
+
+func (n *test_Notifier) Notify(method string, params interface{}) error {
+	p := params.(Params)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.values = append(n.values, string(p.Token)+" "+string(p.Value))
+	return nil
+}
+
+func (n *test_Notifier) Done() <-chan struct{} {
+	return nil
+}
+
+func (n *test_Notifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string{}, n.values...)
+}
+
+func TestToken(t *testing.T) {
+	testData := map[string]string{
+		`{"progressToken":"abc","x":1}`: `"abc"`,
+		`{"progressToken":7}`:           `7`,
+		`{"progressToken":null}`:        ``,
+		`{"x":1}`:                       ``,
+		`[1,2]`:                         ``,
+		``:                              ``,
+	}
+
+	for params, token := range testData {
+		if got := Token(json.RawMessage(params)); string(got) != token {
+			t.Errorf("%s got token %s", params, string(got))
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	stage := Stage(0)
+	n := &test_Notifier{}
+
+	// No notifier (e.g. HTTP): reports are dropped
+	rc := common.EmptyRequestContext()
+	rc.JsonRpcRequest.Params = json.RawMessage(`{"progressToken":"t"}`)
+	stage(&rc)
+	if From(&rc) != nil || From(&rc).Report(1) != nil {
+		t.Errorf("Reporter was created without a notifier")
+	}
+
+	// No token
+	rc.Data[common.NotifierKey] = n
+	rc.JsonRpcRequest.Params = json.RawMessage(`{}`)
+	stage(&rc)
+	if From(&rc) != nil {
+		t.Errorf("Reporter was created without a token")
+	}
+
+	rc.JsonRpcRequest.Params = json.RawMessage(`{"progressToken":"t"}`)
+	stage(&rc)
+	r := From(&rc)
+	if r == nil {
+		t.Fatalf("Reporter was not created")
+	}
+
+	_ = r.Update(1, 2, "half")
+	rc.ResponseSent()
+	_ = r.Report(2)
+
+	if sent := n.sent(); len(sent) != 1 || sent[0] != `"t" {"message":"half","done":1,"total":2}` {
+		t.Errorf("Got reports %v", sent)
+	}
+
+	// A request of the same batch without a token gets no stale reporter
+	rc.JsonRpcRequest.Params = json.RawMessage(`{}`)
+	stage(&rc)
+	if From(&rc) != nil {
+		t.Errorf("Reporter leaked to another request")
+	}
+}
+
+func TestReporter_Throttle(t *testing.T) {
+	n := &test_Notifier{}
+	r := NewReporter(n, json.RawMessage(`1`), 50*time.Millisecond)
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	var flushes []func()
+	cancelled := 0
+	r.after = func(d time.Duration, f func()) func() bool {
+		if d != 50*time.Millisecond {
+			t.Errorf("flush scheduled after %s", d)
+		}
+		flushes = append(flushes, f)
+		return func() bool {
+			cancelled++
+			return true
+		}
+	}
+
+	for i := 1; i <= 5; i++ {
+		_ = r.Report(i)
+	}
+
+	// The first report goes right away, the latest one of the rest after the interval
+	if sent := n.sent(); len(sent) != 1 || sent[0] != "1 1" {
+		t.Errorf("Got reports %v", sent)
+	}
+	if len(flushes) != 1 {
+		t.Fatalf("expected a single scheduled flush, got %d", len(flushes))
+	}
+
+	now = now.Add(50 * time.Millisecond)
+	flushes[0]()
+	if sent := n.sent(); len(sent) != 2 || sent[1] != "1 5" {
+		t.Errorf("Got reports %v", sent)
+	}
+
+	// Pending reports are dropped once the response is sent
+	now = now.Add(50 * time.Millisecond)
+	_ = r.Report(6)
+	_ = r.Report(7)
+	r.Stop()
+	if cancelled != 1 {
+		t.Errorf("scheduled flush was not cancelled")
+	}
+
+	flushes[len(flushes)-1]()
+	if sent := n.sent(); len(sent) != 3 || sent[2] != "1 6" {
+		t.Errorf("Report was sent after the response: %v", sent)
+	}
+}
+
+// A notifier reporting again while sending, as the notifier of a handler may do
+type test_ReentrantNotifier struct {
+	test_Notifier
+	r        *Reporter
+	reported bool
+}
+
+func (n *test_ReentrantNotifier) Notify(method string, params interface{}) error {
+	if !n.reported {
+		n.reported = true
+		_ = n.r.Update(0, 0, "again")
+	}
+
+	return n.test_Notifier.Notify(method, params)
+}
+
+func TestReporter_NotifyWithoutLock(t *testing.T) {
+	n := &test_ReentrantNotifier{}
+	n.r = NewReporter(n, json.RawMessage(`1`), 0)
+
+	done := make(chan bool)
+	go func() {
+		_ = n.r.Report(1)
+		n.r.Stop()
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Report blocked while the notification was sent")
+	}
+
+	if sent := n.sent(); len(sent) != 2 || sent[0] != `1 {"message":"again","done":0}` || sent[1] != "1 1" {
+		t.Errorf("Got reports %v", sent)
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// The header carrying the API key by default
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// Default interval of checking the key file for changes
+const DefaultCheckInterval = 10 * time.Second
+
+// An entry of the API key file
+type APIKey struct {
+	Key    string   `json:"key"`
+	Id     string   `json:"id"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// The API key file: {"keys":[{"key":"...","id":"billing","scopes":["invoices:read"]}]}
+type APIKeyFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// Authenticates the requests by a static table of API keys loaded from a file
+// The file is reloaded once it changes; a file that fails to load is logged and the previous keys stay in use
+type APIKeys struct {
+	File string
+	// The header carrying the key, DefaultAPIKeyHeader if empty
+	Header string
+	// How often the file is checked for changes, DefaultCheckInterval if zero
+	CheckInterval time.Duration
+	Logger        *log.Logger
+	// Keys are looked up by their hashes
+	keys    map[[sha256.Size]byte]*Principal
+	modTime time.Time
+	checked time.Time
+	mu      sync.Mutex
+}
+
+// Create the authenticator with the keys loaded from the file
+func NewAPIKeys(file string) (*APIKeys, error) {
+	k := &APIKeys{
+		File:   file,
+		Logger: log.New(ioutil.Discard, "", 0),
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Load the keys from the file
+func (k *APIKeys) Reload() error {
+	info, err := os.Stat(k.File)
+	if err != nil {
+		return err
+	}
+
+	raw, err := ioutil.ReadFile(k.File)
+	if err != nil {
+		return err
+	}
+
+	file := APIKeyFile{}
+	if err = json.Unmarshal(raw, &file); err != nil {
+		return err
+	}
+
+	keys := map[[sha256.Size]byte]*Principal{}
+	for i, entry := range file.Keys {
+		if entry.Key == "" || entry.Id == "" {
+			return fmt.Errorf("key %d has no key or id", i)
+		}
+
+		keys[sha256.Sum256([]byte(entry.Key))] = &Principal{
+			Id:     entry.Id,
+			Scheme: "api_key",
+			Scopes: entry.Scopes,
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.checked = time.Now()
+
+	return nil
+}
+
+// Reload the keys if the file has changed since the last check
+func (k *APIKeys) check() {
+	interval := k.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	k.mu.Lock()
+	if time.Since(k.checked) < interval {
+		k.mu.Unlock()
+		return
+	}
+	k.checked = time.Now()
+	loaded := k.modTime
+	k.mu.Unlock()
+
+	info, err := os.Stat(k.File)
+	if err != nil || info.ModTime().Equal(loaded) {
+		return
+	}
+
+	if err = k.Reload(); err != nil && k.Logger != nil {
+		k.Logger.Println("api key reload error", err.Error())
+	}
+}
+
+func (k *APIKeys) Authenticate(ctx context.Context, m *common.Metadata) (*Principal, error) {
+	header := k.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	key := m.Header(header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	k.check()
+
+	k.mu.Lock()
+	p, ok := k.keys[sha256.Sum256([]byte(key))]
+	k.mu.Unlock()
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Each request gets a copy of its own
+	principal := *p
+	return &principal, nil
+}
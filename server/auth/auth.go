@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+// Authentication of calls
+// An Authenticator finds the credentials in the transport metadata of a request (see common.Metadata)
+// and tells who the caller is. Stage checks the calls of a JsonRpcServer and HttpStage those of
+// an HttpTransport endpoint, answering the failures with common.UnauthorizedError and 401 Unauthorized
+// (with the WWW-Authenticate challenges of the authenticators).
+// The authenticated Principal is kept in the Request Context, see From.
+// Authorize checks the scopes the methods of a server require (see JsonRpcServer.RequireScopes),
+// the calls of a principal lacking them fail with common.ForbiddenError.
+
+// The key of Request Context Data holding the *Principal of the request
+const PrincipalKey = "auth.principal"
+
+// The error returned by an Authenticator if the request carries no credentials of its kind
+var ErrNoCredentials = fmt.Errorf("no credentials")
+
+// The error returned by an Authenticator if the credentials are wrong
+var ErrInvalidCredentials = fmt.Errorf("invalid credentials")
+
+// An authenticated caller
+type Principal struct {
+	Id string `json:"id"`
+	// How the principal was authenticated, e.g. "api_key", "bearer", "basic"
+	Scheme string   `json:"scheme"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Anything else known about the principal (e.g. token claims)
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Check if the principal has the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds the principal by the credentials of a request
+type Authenticator interface {
+	// ErrNoCredentials is returned if the request has no credentials of the kind,
+	// any other error means the credentials are wrong
+	Authenticate(ctx context.Context, m *common.Metadata) (*Principal, error)
+}
+
+// An Authenticator that tells the client how to authenticate (the WWW-Authenticate header)
+type Challenger interface {
+	Challenge() string
+}
+
+// Get the principal of the authenticated request
+func From(rc *common.RequestContext) (*Principal, bool) {
+	p, ok := rc.Data[PrincipalKey].(*Principal)
+	return p, ok
+}
+
+// Authenticate the request with the first authenticator finding credentials in it
+// The principal is stored in the Request Context
+func Authenticate(rc *common.RequestContext, authenticators ...Authenticator) (*Principal, error) {
+	m, ok := rc.GetMetadata()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	for _, a := range authenticators {
+		p, err := a.Authenticate(rc.GetContext(), m)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, ErrInvalidCredentials
+		}
+
+		rc.Data[PrincipalKey] = p
+		return p, nil
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// A JsonRpcServer pre-processing stage rejecting the calls that fail authentication
+// Calls of a batch share the principal, so it is authenticated once
+func Stage(authenticators ...Authenticator) common.Stage {
+	return func(rc *common.RequestContext) bool {
+		if _, ok := From(rc); ok {
+			return true
+		}
+
+		if _, err := Authenticate(rc, authenticators...); err != nil {
+			if m, ok := rc.GetMetadata(); ok {
+				if c := challenges(authenticators); c != "" {
+					m.SetResponseHeader("WWW-Authenticate", c)
+				}
+			}
+
+			rc.MakeErrorResponse(common.UnauthorizedError)
+			return false
+		}
+
+		return true
+	}
+}
+
+// Get the challenges of the authenticators, comma-separated
+func challenges(authenticators []Authenticator) string {
+	c := []string{}
+	for _, a := range authenticators {
+		if challenger, ok := a.(Challenger); ok {
+			c = append(c, challenger.Challenge())
+		}
+	}
+
+	return strings.Join(c, ", ")
+}
+
+// A JsonRpcServer pre-processing stage (following the authentication ones) rejecting the calls
+// of the principals lacking the scopes required by the method
+func Authorize(s *server.JsonRpcServer) common.Stage {
+	return func(rc *common.RequestContext) bool {
+		method, ok := s.GetMethod(rc.JsonRpcRequest.Method)
+		if !ok || len(method.Scopes) == 0 {
+			return true
+		}
+
+		p, ok := From(rc)
+		if !ok {
+			rc.MakeErrorResponse(common.UnauthorizedError)
+			return false
+		}
+
+		for _, scope := range method.Scopes {
+			if !p.HasScope(scope) {
+				rc.MakeErrorResponse(common.ForbiddenError)
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// An HttpTransport stage rejecting the requests that fail authentication with 401 Unauthorized
+func HttpStage(authenticators ...Authenticator) transport.HttpStage {
+	return func(hrc *transport.HttpRequestContext) bool {
+		if _, err := Authenticate(&hrc.RequestContext, authenticators...); err == nil {
+			return true
+		}
+
+		for _, a := range authenticators {
+			if c, ok := a.(Challenger); ok {
+				hrc.HttpResponse.Header().Add("WWW-Authenticate", c.Challenge())
+			}
+		}
+
+		hrc.MakeErrorResponse(common.UnauthorizedError)
+		_ = hrc.RebuildRawResponse()
+		hrc.HttpStatus = http.StatusUnauthorized
+
+		return false
+	}
+}
+
+// Identify the owner of an SSE session (see transport.SSETransport.Owner) by the principal authenticated
+// by the pre server stages, e.g. HttpStage, or by the credentials if there is none
+func SSEOwner(r *http.Request, data map[string]interface{}) string {
+	if p, ok := data[PrincipalKey].(*Principal); ok {
+		return p.Scheme + ":" + p.Id
+	}
+
+	return transport.CredentialsOwner(r, data)
+}
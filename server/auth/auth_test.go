@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/server/transport"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+type test_WhoAmIHandler struct{}
+
+func (h test_WhoAmIHandler) Handle_whoami(rc *common.RequestContext, params struct{}) (string, common.Error, error) {
+	p, ok := From(rc)
+	if !ok {
+		return "", common.InternalError, nil
+	}
+
+	return p.Scheme + ":" + p.Id, common.Error{}, nil
+}
+
+func test_WriteKeys(t *testing.T, file string, keys string) {
+	if err := ioutil.WriteFile(file, []byte(keys), 0600); err != nil {
+		t.Fatalf("failed to write keys: %s", err.Error())
+	}
+}
+
+func test_Metadata(headers map[string]string) *common.Metadata {
+	m := common.NewMetadata("http", "127.0.0.1:1234")
+	for name, value := range headers {
+		m.Headers.Set(name, value)
+	}
+
+	return m
+}
+
+func test_Tokens() TokenVerifier {
+	return TokenVerifierFunc(func(ctx context.Context, token string) (*Principal, error) {
+		if token != "good-token" {
+			return nil, ErrInvalidCredentials
+		}
+
+		return &Principal{Id: "token-user", Scopes: []string{"read"}}, nil
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	test_WriteKeys(t, file, `{"keys":[{"key":"k1","id":"billing","scopes":["invoices:read"]}]}`)
+
+	keys, err := NewAPIKeys(file)
+	if err != nil {
+		t.Fatalf("failed to load keys: %s", err.Error())
+	}
+	keys.CheckInterval = time.Millisecond
+
+	testData := []struct {
+		Headers map[string]string
+		Id      string
+		Err     error
+	}{
+		{map[string]string{}, "", ErrNoCredentials},
+		{map[string]string{"X-API-Key": "k1"}, "billing", nil},
+		{map[string]string{"X-API-Key": "k2"}, "", ErrInvalidCredentials},
+	}
+
+	for k, data := range testData {
+		p, err := keys.Authenticate(context.Background(), test_Metadata(data.Headers))
+		if err != data.Err {
+			t.Errorf("%d unexpected error %v", k, err)
+		}
+		if err == nil && (p.Id != data.Id || p.Scheme != "api_key" || !p.HasScope("invoices:read")) {
+			t.Errorf("%d wrong principal %+v", k, p)
+		}
+	}
+
+	// A broken file keeps the old keys
+	test_WriteKeys(t, file, `{"keys":`)
+	_ = os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err := keys.Authenticate(context.Background(), test_Metadata(map[string]string{"X-API-Key": "k1"})); err != nil {
+		t.Errorf("keys were lost on a bad reload")
+	}
+
+	// A changed file is picked up
+	test_WriteKeys(t, file, `{"keys":[{"key":"k2","id":"reports"}]}`)
+	_ = os.Chtimes(file, time.Now(), time.Now().Add(2*time.Second))
+	time.Sleep(2 * time.Millisecond)
+
+	if p, err := keys.Authenticate(context.Background(), test_Metadata(map[string]string{"X-API-Key": "k2"})); err != nil || p.Id != "reports" {
+		t.Errorf("keys were not reloaded")
+	}
+	if _, err := keys.Authenticate(context.Background(), test_Metadata(map[string]string{"X-API-Key": "k1"})); err != ErrInvalidCredentials {
+		t.Errorf("removed key is still accepted")
+	}
+
+	if _, err := NewAPIKeys(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("missing key file was accepted")
+	}
+
+	test_WriteKeys(t, file, `{"keys":[{"key":"","id":"nobody"}]}`)
+	if _, err := NewAPIKeys(file); err == nil {
+		t.Errorf("empty key was accepted")
+	}
+}
+
+func TestBearer(t *testing.T) {
+	b := Bearer{Verifier: test_Tokens(), Realm: "api"}
+
+	testData := []struct {
+		Authorization string
+		Err           error
+	}{
+		{"", ErrNoCredentials},
+		{"Basic dXNlcjpwYXNz", ErrNoCredentials},
+		{"Bearer ", ErrNoCredentials},
+		{"Bearer bad-token", ErrInvalidCredentials},
+		{"Bearer good-token", nil},
+		{"bearer good-token", nil},
+	}
+
+	for k, data := range testData {
+		p, err := b.Authenticate(context.Background(), test_Metadata(map[string]string{"Authorization": data.Authorization}))
+		if err != data.Err {
+			t.Errorf("%d unexpected error %v", k, err)
+		}
+		if err == nil && (p.Id != "token-user" || p.Scheme != "bearer") {
+			t.Errorf("%d wrong principal %+v", k, p)
+		}
+	}
+
+	if b.Challenge() != `Bearer realm="api"` {
+		t.Errorf("wrong challenge %s", b.Challenge())
+	}
+}
+
+func TestBasic(t *testing.T) {
+	b := Basic{Verifier: Users(map[string]string{"admin": "secret"})}
+
+	testData := []struct {
+		Username string
+		Password string
+		Err      error
+	}{
+		{"admin", "secret", nil},
+		{"admin", "wrong", ErrInvalidCredentials},
+		{"nobody", "secret", ErrInvalidCredentials},
+		{"nobody", "", ErrInvalidCredentials},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(http.MethodPost, "/", nil)
+		r.SetBasicAuth(data.Username, data.Password)
+
+		p, err := b.Authenticate(context.Background(), common.NewHttpMetadata("http", r, nil))
+		if err != data.Err {
+			t.Errorf("%d unexpected error %v", k, err)
+		}
+		if err == nil && (p.Id != data.Username || p.Scheme != "basic") {
+			t.Errorf("%d wrong principal %+v", k, p)
+		}
+	}
+
+	if _, err := b.Authenticate(context.Background(), test_Metadata(map[string]string{})); err != ErrNoCredentials {
+		t.Errorf("missing credentials were not detected")
+	}
+}
+
+func TestStage(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WhoAmIHandler{}, "Handle_")
+	s.PreProcessingStages = append(s.PreProcessingStages, Stage(Bearer{Verifier: test_Tokens()}))
+
+	testData := []struct {
+		Authorization string
+		Out           string
+	}{
+		{"", `{"jsonrpc":"2.0","id":"1","error":{"code":-32001,"message":"Unauthorized"}}`},
+		{"Bearer bad-token", `{"jsonrpc":"2.0","id":"1","error":{"code":-32001,"message":"Unauthorized"}}`},
+		{"Bearer good-token", `{"jsonrpc":"2.0","id":"1","result":"bearer:token-user"}`},
+	}
+
+	for k, data := range testData {
+		rc := common.EmptyRequestContext()
+		rc.Data[common.MetadataKey] = test_Metadata(map[string]string{"Authorization": data.Authorization})
+		rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`)
+		_ = s.ProcessRawRequest(&rc)
+
+		if string(rc.RawResponse) != data.Out {
+			t.Errorf("%d wrong response %s", k, string(rc.RawResponse))
+		}
+	}
+
+	// The failures carry the challenges of the authenticators
+	rc := common.EmptyRequestContext()
+	m := test_Metadata(nil)
+	rc.Data[common.MetadataKey] = m
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`)
+	_ = s.ProcessRawRequest(&rc)
+	if c := m.ResponseHeaders.Get("WWW-Authenticate"); c != "Bearer" {
+		t.Errorf("wrong challenge %s", c)
+	}
+
+	// Calls without metadata are not authenticated
+	rc = common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`)
+	_ = s.ProcessRawRequest(&rc)
+
+	if _, ok := From(&rc); ok {
+		t.Errorf("call without metadata was authenticated")
+	}
+}
+
+func TestHttpStage(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WhoAmIHandler{}, "Handle_")
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	test_WriteKeys(t, file, `{"keys":[{"key":"k1","id":"billing"}]}`)
+	keys, _ := NewAPIKeys(file)
+
+	ht := transport.NewHttpTransport("")
+	_, _ = ht.AddEndpoint("/rpc", s, transport.WithPreServerStages(HttpStage(keys, Bearer{Verifier: test_Tokens(), Realm: "api"})))
+
+	httpServer := httptest.NewServer(ht.Mux)
+	defer httpServer.Close()
+
+	unauthorized := `{"jsonrpc":"2.0","error":{"code":-32001,"message":"Unauthorized"}}`
+
+	testData := []struct {
+		Header    string
+		Value     string
+		Status    int
+		Body      string
+		Challenge string
+	}{
+		{"", "", http.StatusUnauthorized, unauthorized, `Bearer realm="api"`},
+		{"X-API-Key", "k2", http.StatusUnauthorized, unauthorized, `Bearer realm="api"`},
+		{"X-API-Key", "k1", http.StatusOK, `{"jsonrpc":"2.0","id":"1","result":"api_key:billing"}`, ""},
+		{"Authorization", "Bearer good-token", http.StatusOK, `{"jsonrpc":"2.0","id":"1","result":"bearer:token-user"}`, ""},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/rpc", bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`))
+		r.Header.Set("Content-Type", "application/json")
+		if data.Header != "" {
+			r.Header.Set(data.Header, data.Value)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%d request failed: %s", k, err.Error())
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d wrong status %d", k, response.StatusCode)
+		}
+		if string(body) != data.Body {
+			t.Errorf("%d wrong body %s", k, string(body))
+		}
+		if response.Header.Get("WWW-Authenticate") != data.Challenge {
+			t.Errorf("%d wrong challenge %s", k, response.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestHttpStage_WebSocket(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WhoAmIHandler{}, "Handle_")
+	_ = s.RequireScopes("whoami", "read")
+	s.PreProcessingStages = append(s.PreProcessingStages, Authorize(s))
+
+	ht := transport.NewHttpTransport("")
+	_, _ = ht.AddWebSocketEndpoint("/ws", s, transport.WithPreServerStages(HttpStage(Bearer{Verifier: test_Tokens()})))
+
+	httpServer := httptest.NewServer(ht.Mux)
+	defer httpServer.Close()
+
+	// The principal authenticated by the handshake is kept for the calls over the connection
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", http.Header{"Authorization": {"Bearer good-token"}}, nil)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err.Error())
+	}
+	defer ws.Close()
+
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`))
+	if r, _ := ws.ReadMessage(); string(r) != `{"jsonrpc":"2.0","id":"1","result":"bearer:token-user"}` {
+		t.Errorf("wrong response %s", string(r))
+	}
+}
+
+func TestSSEOwner(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	test_WriteKeys(t, file, `{"keys":[{"key":"k1","id":"billing"},{"key":"k2","id":"reports"},{"key":"k3","id":"billing"}]}`)
+	keys, _ := NewAPIKeys(file)
+
+	ht := transport.NewHttpTransport("")
+	sse, _ := ht.AddSSEEndpoint("/events", transport.WithPreServerStages(HttpStage(keys)))
+	sse.Owner = SSEOwner
+
+	httpServer := httptest.NewServer(ht.Mux)
+	defer httpServer.Close()
+
+	open := func(key string, session string) *http.Response {
+		r, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/events?"+transport.SessionParam+"="+session, nil)
+		r.Header.Set("X-API-Key", key)
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("could not open the stream: %s", err.Error())
+		}
+		return response
+	}
+
+	response := open("k1", "")
+	defer response.Body.Close()
+	id := response.Header.Get(transport.SessionHeader)
+
+	// The session belongs to the principal rather than to the key
+	testData := []struct {
+		Key    string
+		Status int
+	}{
+		{"k2", http.StatusForbidden},
+		{"k3", http.StatusOK},
+	}
+
+	for k, data := range testData {
+		response := open(data.Key, id)
+		_ = response.Body.Close()
+		if response.StatusCode != data.Status {
+			t.Errorf("%d resumed with status %d", k, response.StatusCode)
+		}
+	}
+}
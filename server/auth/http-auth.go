@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// Checks a bearer token, returns the principal it belongs to
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*Principal, error)
+}
+
+// A function implementing TokenVerifier
+type TokenVerifierFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f TokenVerifierFunc) VerifyToken(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// Authenticates the requests by the bearer token in the Authorization header
+type Bearer struct {
+	Verifier TokenVerifier
+	Realm    string
+}
+
+func (b Bearer) Authenticate(ctx context.Context, m *common.Metadata) (*Principal, error) {
+	token, ok := authorization(m, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	p, err := b.Verifier.VerifyToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if p.Scheme == "" {
+		p.Scheme = "bearer"
+	}
+
+	return p, nil
+}
+
+func (b Bearer) Challenge() string {
+	return challenge("Bearer", b.Realm)
+}
+
+// Checks the user name and password, returns the principal they belong to
+type PasswordVerifier func(ctx context.Context, username string, password string) (*Principal, error)
+
+// Authenticates the requests by HTTP Basic credentials
+type Basic struct {
+	Verifier PasswordVerifier
+	Realm    string
+}
+
+func (b Basic) Authenticate(ctx context.Context, m *common.Metadata) (*Principal, error) {
+	r := http.Request{Header: m.Headers}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	p, err := b.Verifier(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if p.Scheme == "" {
+		p.Scheme = "basic"
+	}
+
+	return p, nil
+}
+
+func (b Basic) Challenge() string {
+	return challenge("Basic", b.Realm) + `, charset="UTF-8"`
+}
+
+// A PasswordVerifier checking the passwords against a static table of users
+// The principal id is the user name
+func Users(users map[string]string) PasswordVerifier {
+	hashes := map[string][sha256.Size]byte{}
+	for username, password := range users {
+		hashes[username] = sha256.Sum256([]byte(password))
+	}
+
+	return func(ctx context.Context, username string, password string) (*Principal, error) {
+		expected, ok := hashes[username]
+		given := sha256.Sum256([]byte(password))
+
+		// Compare in constant time whether the user exists or not
+		if subtle.ConstantTimeCompare(expected[:], given[:]) != 1 || !ok {
+			return nil, ErrInvalidCredentials
+		}
+
+		return &Principal{Id: username}, nil
+	}
+}
+
+// Get the credentials of the Authorization header with the given scheme
+func authorization(m *common.Metadata, scheme string) (string, bool) {
+	header := m.Header("Authorization")
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) || header[len(scheme)] != ' ' {
+		return "", false
+	}
+
+	credentials := strings.TrimSpace(header[len(scheme)+1:])
+	return credentials, credentials != ""
+}
+
+func challenge(scheme string, realm string) string {
+	if realm == "" {
+		return scheme
+	}
+
+	return scheme + ` realm="` + strings.ReplaceAll(realm, `"`, `\"`) + `"`
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// The claims of a verified token
+type Claims map[string]interface{}
+
+// The sub claim
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// The scopes of the scope (space-separated) or scp (a list or a space-separated string) claim
+func (c Claims) Scopes() []string {
+	switch scopes := c["scope"].(type) {
+	case string:
+		return strings.Fields(scopes)
+	}
+
+	switch scopes := c["scp"].(type) {
+	case string:
+		return strings.Fields(scopes)
+	case []interface{}:
+		result := []string{}
+		for _, s := range scopes {
+			if s, ok := s.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+
+	return nil
+}
+
+// Check if the aud claim (a string or a list) contains the audience
+func (c Claims) HasAudience(audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Decode the claims into a struct of the handler, e.g. struct{ Tenant string `json:"tenant"` }
+func (c Claims) Decode(v interface{}) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// Get the claims of the request authenticated by a token
+func ClaimsFrom(rc *common.RequestContext) (Claims, bool) {
+	p, ok := From(rc)
+	if !ok || p.Attributes == nil {
+		return nil, false
+	}
+
+	return Claims(p.Attributes), true
+}
+
+// Verifies signed JWTs (JWS compact serialization) with the local keys, see Bearer
+// The principal of a token is its subject with the scopes of the token, the claims are its Attributes
+type JWTVerifier struct {
+	Keys *KeySet
+	// The required iss claim, not checked if empty
+	Issuer string
+	// The required aud claim, not checked if empty
+	Audience string
+	// Tolerated clock skew for exp and nbf
+	Leeway time.Duration
+	// Tokens without exp are rejected if set
+	RequireExpiry bool
+}
+
+// Verify the token, return its claims
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	// The algorithm of the header must match the one of the key, so a public key can not be used as an HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.Keys.find(header.Alg, header.Kid) {
+		if verifySignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("bad token signature")
+	}
+
+	claims := Claims{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+
+	if err = v.check(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// Check the registered claims of the token
+func (v *JWTVerifier) check(claims Claims, now time.Time) error {
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok && v.RequireExpiry {
+		return fmt.Errorf("token has no expiry")
+	}
+	if ok && !now.Before(exp.Add(v.Leeway)) {
+		return fmt.Errorf("token has expired")
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.Leeway).Before(nbf) {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return fmt.Errorf("wrong token issuer")
+	}
+
+	if v.Audience != "" && !claims.HasAudience(v.Audience) {
+		return fmt.Errorf("wrong token audience")
+	}
+
+	return nil
+}
+
+func (v *JWTVerifier) VerifyToken(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Id:         claims.Subject(),
+		Scheme:     "jwt",
+		Scopes:     claims.Scopes(),
+		Attributes: claims,
+	}, nil
+}
+
+func verifySignature(key Key, signed []byte, signature []byte) bool {
+	switch key.Algorithm {
+	case HS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		public, ok := key.Key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], signature) == nil
+	case ES256:
+		public, ok := key.Key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, hash[:], r, s)
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// Get a NumericDate claim
+func numericDate(claims Claims, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("bad %s claim", name)
+	}
+
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("bad %s claim", name)
+	}
+
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+type test_UsersHandler struct{}
+
+func (h test_UsersHandler) Handle_delete(rc *common.RequestContext, params struct{}) (string, common.Error, error) {
+	claims, ok := ClaimsFrom(rc)
+	if !ok {
+		return "", common.InternalError, nil
+	}
+
+	tenant := struct {
+		Tenant string `json:"tenant"`
+	}{}
+	if err := claims.Decode(&tenant); err != nil {
+		return "", common.InternalError, nil
+	}
+
+	return claims.Subject() + "@" + tenant.Tenant, common.Error{}, nil
+}
+
+func (h test_UsersHandler) Handle_list(params struct{}) (string, common.Error, error) {
+	return "ok", common.Error{}, nil
+}
+
+// Make a signed token, the signing key is a []byte secret, an *rsa.PrivateKey or an *ecdsa.PrivateKey
+func test_Sign(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %s", err.Error())
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case nil:
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func test_WritePublicKey(t *testing.T, file string, public interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err.Error())
+	}
+
+	if err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err.Error())
+	}
+}
+
+func test_Claims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":    "alice",
+		"iss":    "https://issuer.example.com",
+		"aud":    []string{"api", "other"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"nbf":    time.Now().Add(-time.Minute).Unix(),
+		"scope":  "users:read admin:write",
+		"tenant": "acme",
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	return claims
+}
+
+func TestJWTVerifier(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("shared-secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	test_WritePublicKey(t, filepath.Join(dir, "rsa.pem"), &rsaKey.PublicKey)
+	test_WritePublicKey(t, filepath.Join(dir, "ec.pem"), &ecKey.PublicKey)
+
+	rsaPublic, err := LoadPEMKey(filepath.Join(dir, "rsa.pem"), "rsa-1")
+	if err != nil || rsaPublic.Algorithm != RS256 {
+		t.Fatalf("failed to load the RSA key: %v", err)
+	}
+	ecPublic, err := LoadPEMKey(filepath.Join(dir, "ec.pem"), "")
+	if err != nil || ecPublic.Algorithm != ES256 {
+		t.Fatalf("failed to load the EC key: %v", err)
+	}
+
+	v := &JWTVerifier{
+		Keys:     NewKeySet(NewHMACKey("hmac-1", secret), rsaPublic, ecPublic),
+		Issuer:   "https://issuer.example.com",
+		Audience: "api",
+	}
+
+	rsaDer, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	testData := []struct {
+		Name  string
+		Token string
+		Ok    bool
+	}{
+		{"HS256", test_Sign(t, HS256, "hmac-1", secret, test_Claims(nil)), true},
+		{"RS256", test_Sign(t, RS256, "rsa-1", rsaKey, test_Claims(nil)), true},
+		{"RS256 without kid", test_Sign(t, RS256, "", rsaKey, test_Claims(nil)), true},
+		{"ES256", test_Sign(t, ES256, "any", ecKey, test_Claims(nil)), true},
+		{"string audience", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"aud": "api"})), true},
+		{"leeway", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"exp": time.Now().Add(-time.Second).Unix()})), false},
+		{"wrong secret", test_Sign(t, HS256, "", []byte("other"), test_Claims(nil)), false},
+		{"wrong kid", test_Sign(t, RS256, "rsa-2", rsaKey, test_Claims(nil)), false},
+		{"alg none", test_Sign(t, "none", "", nil, test_Claims(nil)), false},
+		{"public key as secret", test_Sign(t, HS256, "rsa-1", rsaDer, test_Claims(nil)), false},
+		{"expired", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), false},
+		{"not yet valid", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})), false},
+		{"wrong issuer", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"wrong audience", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"aud": "other"})), false},
+		{"bad exp", test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"exp": "tomorrow"})), false},
+		{"malformed", "not.a-token", false},
+	}
+
+	for k, data := range testData {
+		claims, err := v.Verify(data.Token)
+		if (err == nil) != data.Ok {
+			t.Errorf("%d %s: unexpected result %v", k, data.Name, err)
+		}
+		if err == nil && claims.Subject() != "alice" {
+			t.Errorf("%d %s: wrong claims %v", k, data.Name, claims)
+		}
+	}
+
+	v.Leeway = time.Minute
+	if _, err := v.Verify(testData[5].Token); err != nil {
+		t.Errorf("leeway was not applied: %s", err.Error())
+	}
+
+	v.RequireExpiry = true
+	if _, err := v.Verify(test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"exp": nil}))); err == nil {
+		t.Errorf("token without expiry was accepted")
+	}
+
+	p, err := v.VerifyToken(context.Background(), testData[0].Token)
+	if err != nil || p.Id != "alice" || p.Scheme != "jwt" || !p.HasScope("admin:write") || p.Attributes["tenant"] != "acme" {
+		t.Errorf("wrong principal %+v", p)
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	b64 := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	coordinate := func(x interface{ FillBytes([]byte) []byte }) string {
+		return b64(x.FillBytes(make([]byte, 32)))
+	}
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64([]byte{1, 0, 1})},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": coordinate(ecKey.X), "y": coordinate(ecKey.Y)},
+			{"kty": "oct", "kid": "hmac-1", "k": b64([]byte("shared-secret"))},
+			{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "", "e": ""},
+		},
+	})
+	file := filepath.Join(dir, "jwks.json")
+	test_WriteKeys(t, file, string(jwks))
+
+	keys, err := LoadJWKS(file)
+	if err != nil {
+		t.Fatalf("failed to load the JWKS: %s", err.Error())
+	}
+	if len(keys.Keys) != 3 {
+		t.Fatalf("wrong number of keys %d", len(keys.Keys))
+	}
+
+	v := &JWTVerifier{Keys: keys}
+	tokens := []string{
+		test_Sign(t, RS256, "rsa-1", rsaKey, test_Claims(nil)),
+		test_Sign(t, ES256, "ec-1", ecKey, test_Claims(nil)),
+		test_Sign(t, HS256, "hmac-1", []byte("shared-secret"), test_Claims(nil)),
+	}
+	for k, token := range tokens {
+		if _, err := v.Verify(token); err != nil {
+			t.Errorf("%d token was not verified: %s", k, err.Error())
+		}
+	}
+
+	badData := []string{
+		`{"keys":[{"kty":"EC","crv":"P-384","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+		`{"keys":[{"kty":"RSA","alg":"PS256","n":"AQ","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"OKP"}]}`,
+		`{"keys":`,
+	}
+	for k, data := range badData {
+		test_WriteKeys(t, file, data)
+		if _, err := LoadJWKS(file); err == nil {
+			t.Errorf("%d bad JWKS was accepted", k)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	s := server.NewServer()
+	s.AddHandlerWithNamespace(test_UsersHandler{}, "Handle_", "users.")
+	if err := s.RequireScopes("users.delete", "admin:write"); err != nil {
+		t.Fatalf("failed to require scopes: %s", err.Error())
+	}
+	s.PreProcessingStages = append(s.PreProcessingStages,
+		Stage(Bearer{Verifier: &JWTVerifier{Keys: NewKeySet(NewHMACKey("", secret)), Audience: "api"}}),
+		Authorize(s),
+	)
+
+	// Auth failures get their HTTP statuses even without ErrorStatuses
+	ht := transport.NewHttpTransport("")
+	_, _ = ht.AddEndpoint("/rpc", s)
+
+	httpServer := httptest.NewServer(ht.Mux)
+	defer httpServer.Close()
+
+	admin := test_Sign(t, HS256, "", secret, test_Claims(nil))
+	reader := test_Sign(t, HS256, "", secret, test_Claims(map[string]interface{}{"scope": "users:read"}))
+
+	testData := []struct {
+		Token     string
+		Method    string
+		Status    int
+		Body      string
+		Challenge string
+	}{
+		{admin, "users.delete", http.StatusOK, `{"jsonrpc":"2.0","id":"1","result":"alice@acme"}`, ""},
+		{reader, "users.delete", http.StatusForbidden, `{"jsonrpc":"2.0","id":"1","error":{"code":-32003,"message":"Forbidden"}}`, ""},
+		{reader, "users.list", http.StatusOK, `{"jsonrpc":"2.0","id":"1","result":"ok"}`, ""},
+		{"", "users.list", http.StatusUnauthorized, `{"jsonrpc":"2.0","id":"1","error":{"code":-32001,"message":"Unauthorized"}}`, "Bearer"},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/rpc", bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"`+data.Method+`","params":{}}`))
+		r.Header.Set("Content-Type", "application/json")
+		if data.Token != "" {
+			r.Header.Set("Authorization", "Bearer "+data.Token)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%d request failed: %s", k, err.Error())
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d wrong status %d", k, response.StatusCode)
+		}
+		if string(body) != data.Body {
+			t.Errorf("%d wrong body %s", k, string(body))
+		}
+		if response.Header.Get("WWW-Authenticate") != data.Challenge {
+			t.Errorf("%d wrong challenge %s", k, response.Header.Get("WWW-Authenticate"))
+		}
+	}
+
+	// Calls without a principal are not authorized
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"users.delete","params":{}}`)
+	_ = rc.ParseRawRequest()
+	if Authorize(s)(&rc) {
+		t.Errorf("call without a principal was authorized")
+	}
+}
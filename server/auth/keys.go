@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+// Signature algorithms of the tokens
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// A key verifying the signatures of the tokens
+// Key is []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey (P-256) for ES256
+type Key struct {
+	// The kid of the key, a key without an id matches any token of its algorithm
+	Id        string
+	Algorithm string
+	Key       interface{}
+}
+
+// The keys of a verifier
+type KeySet struct {
+	Keys []Key
+}
+
+// Create a key set of the keys
+func NewKeySet(keys ...Key) *KeySet {
+	return &KeySet{Keys: keys}
+}
+
+// Create an HS256 key of the shared secret
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{Id: id, Algorithm: HS256, Key: secret}
+}
+
+// Load a public key (or a certificate) from a PEM file, the algorithm is chosen by the key type
+func LoadPEMKey(file string, id string) (Key, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Key{}, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return Key{}, fmt.Errorf("no PEM data in %s", file)
+	}
+
+	var public interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		public = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	return publicKey(id, public)
+}
+
+// Make the key of a public key
+func publicKey(id string, public interface{}) (Key, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return Key{Id: id, Algorithm: RS256, Key: k}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		return Key{Id: id, Algorithm: ES256, Key: k}, nil
+	}
+
+	return Key{}, fmt.Errorf("unsupported key type %T", public)
+}
+
+// A key of a JWKS file
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// Symmetric
+	K string `json:"k"`
+}
+
+// Load the keys of a JWKS file ({"keys":[...]}), encryption keys are skipped
+func LoadJWKS(file string) (*KeySet, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err = json.Unmarshal(raw, &jwks); err != nil {
+		return nil, err
+	}
+
+	set := NewKeySet()
+	for i, k := range jwks.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %s", i, err.Error())
+		}
+		if k.Alg != "" && k.Alg != key.Algorithm {
+			return nil, fmt.Errorf("key %d: unsupported algorithm %s", i, k.Alg)
+		}
+
+		set.Keys = append(set.Keys, key)
+	}
+
+	return set, nil
+}
+
+func (k jwk) key() (Key, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return Key{}, fmt.Errorf("bad symmetric key")
+		}
+		return NewHMACKey(k.Kid, secret), nil
+	case "RSA":
+		n, err1 := decodeInt(k.N)
+		e, err2 := decodeInt(k.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() {
+			return Key{}, fmt.Errorf("bad RSA key")
+		}
+		return publicKey(k.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())})
+	case "EC":
+		if k.Crv != "P-256" {
+			return Key{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err1 := decodeInt(k.X)
+		y, err2 := decodeInt(k.Y)
+		if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return Key{}, fmt.Errorf("bad EC key")
+		}
+		return publicKey(k.Kid, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	}
+
+	return Key{}, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("bad number")
+	}
+
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// Get the keys that may have signed a token with the algorithm and kid
+func (s *KeySet) find(algorithm string, id string) []Key {
+	keys := []Key{}
+	for _, k := range s.Keys {
+		if k.Algorithm == algorithm && (k.Id == "" || id == "" || k.Id == id) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,525 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/progress"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Asynchronous jobs
+// Methods made async with Manager.Async return a Ticket with the job id right away and run in the background.
+// The client polls rpc.job.status and fetches the outcome with rpc.job.result; rpc.job.cancel cancels the job.
+// Progress reported by the handler (see the progress package) is available in the job status.
+
+// Default time the finished jobs are kept for
+const DefaultTTL = time.Hour
+
+// Default minimum time between the sweeps done when jobs are started
+const DefaultSweepInterval = time.Minute
+
+// State of a job
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+var JobNotFoundError = common.Error{
+	Code:    "-32020",
+	Message: "Job not found",
+}
+
+var JobNotFinishedError = common.Error{
+	Code:    "-32021",
+	Message: "Job not finished",
+}
+
+var JobCancelledError = common.Error{
+	Code:    "-32022",
+	Message: "Job cancelled",
+}
+
+var JobInterruptedError = common.Error{
+	Code:    "-32023",
+	Message: "Job interrupted",
+}
+
+// A job record
+type Job struct {
+	Id         string          `json:"id"`
+	Method     string          `json:"method"`
+	Status     Status          `json:"status"`
+	Progress   json.RawMessage `json:"progress,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      json.RawMessage `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	// The job is removed after this time, zero for unfinished jobs
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// The response to a call of an async method
+type Ticket struct {
+	JobId string `json:"jobId"`
+}
+
+// Params of the built-in job methods
+type Params struct {
+	Id string `json:"id"`
+}
+
+// Runs async methods and keeps track of their jobs
+type Manager struct {
+	Store Store
+	// Time the finished jobs are kept for, DefaultTTL if zero
+	TTL time.Duration
+	// Maximum number of jobs running at once, zero means no limit; the rest wait in the pending state
+	MaxConcurrent int
+	// Minimum time between progress updates of a job
+	ProgressInterval time.Duration
+	// Minimum time between the sweeps done when jobs are started, DefaultSweepInterval if zero
+	SweepInterval time.Duration
+	Logger        *log.Logger
+	slots         chan struct{}
+	cancels       map[string]context.CancelFunc
+	lastSweep     time.Time
+	mu            sync.Mutex
+	now           func() time.Time
+}
+
+// Create a new job manager keeping the jobs in the store (nil means a MemoryStore)
+func NewManager(store Store) *Manager {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	return &Manager{
+		Store:   store,
+		Logger:  log.New(ioutil.Discard, "", 0),
+		cancels: map[string]context.CancelFunc{},
+		now:     time.Now,
+	}
+}
+
+// Register the built-in job methods with the server
+func (m *Manager) Register(s *server.JsonRpcServer) {
+	s.AddHandlerWithNamespace(handler{m}, "Handle_", "rpc.job.")
+}
+
+// Make the already registered methods of the server async
+// The methods keep their settings (e.g. Scopes and Safe), so they may be set before or after
+func (m *Manager) Async(s *server.JsonRpcServer, methods ...string) error {
+	for _, name := range methods {
+		method, ok := s.GetMethod(name)
+		if !ok {
+			return fmt.Errorf("unknown method %s", name)
+		}
+
+		wrapped := server.ExtractMethods(asyncMethod{m: m, method: method}, "Handle_")
+		if len(wrapped) != 1 {
+			return fmt.Errorf("could not wrap method %s", name)
+		}
+
+		// Only the invocation is replaced
+		async := method
+		async.Receiver = wrapped[0].Receiver
+		async.Method = wrapped[0].Method
+		async.ParamsType = wrapped[0].ParamsType
+		async.ResultType = wrapped[0].ResultType
+		async.WithContext = wrapped[0].WithContext
+		s.Methods[name] = async
+	}
+
+	return nil
+}
+
+// Generate a random job id
+func newJobId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now == nil {
+		return time.Now()
+	}
+
+	return m.now()
+}
+
+func (m *Manager) ttl() time.Duration {
+	if m.TTL <= 0 {
+		return DefaultTTL
+	}
+
+	return m.TTL
+}
+
+func (m *Manager) sweepInterval() time.Duration {
+	if m.SweepInterval <= 0 {
+		return DefaultSweepInterval
+	}
+
+	return m.SweepInterval
+}
+
+// Get a free slot for a job, blocks while the limit is reached
+func (m *Manager) acquire(ctx context.Context) bool {
+	if m.MaxConcurrent <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	if m.slots == nil {
+		m.slots = make(chan struct{}, m.MaxConcurrent)
+	}
+	slots := m.slots
+	m.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (m *Manager) release() {
+	if m.MaxConcurrent > 0 {
+		<-m.slots
+	}
+}
+
+// Start a job invoking the method in the background
+func (m *Manager) Start(rc *common.RequestContext, method server.JsonRpcMethod) (Job, error) {
+	// Listing the store may be costly, so it is not swept on every start
+	m.mu.Lock()
+	sweep := !m.clock().Before(m.lastSweep.Add(m.sweepInterval()))
+	if sweep {
+		m.lastSweep = m.clock()
+	}
+	m.mu.Unlock()
+
+	if sweep {
+		m.Sweep()
+	}
+
+	job := Job{
+		Id:        newJobId(),
+		Method:    method.Name,
+		Status:    StatusPending,
+		CreatedAt: m.clock(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The job is known to be run by this manager before it is stored, so Sweep never takes it for an interrupted one
+	m.mu.Lock()
+	if m.cancels == nil {
+		m.cancels = map[string]context.CancelFunc{}
+	}
+	m.cancels[job.Id] = cancel
+	m.mu.Unlock()
+
+	if err := m.Store.Put(job); err != nil {
+		m.mu.Lock()
+		delete(m.cancels, job.Id)
+		m.mu.Unlock()
+		cancel()
+		return job, err
+	}
+
+	// The job outlives the request, so it gets a context of its own
+	jrc := common.EmptyRequestContext()
+	jrc.JsonRpcRequest = rc.JsonRpcRequest
+	jrc.Logger = m.Logger
+	jrc.Ctx = ctx
+	for k, v := range rc.Data {
+		jrc.Data[k] = v
+	}
+	for _, k := range []string{common.NotifierKey, common.CancellerKey, common.ResponseSentHooksKey, progress.Key} {
+		delete(jrc.Data, k)
+	}
+
+	// The response headers of the request may be those of its http.ResponseWriter, which must not be
+	// touched once the response is sent, so the job gets a copy of the metadata with headers of its own
+	if md, ok := rc.GetMetadata(); ok {
+		mdCopy := *md
+		mdCopy.Headers = md.Headers.Clone()
+		mdCopy.ResponseHeaders = http.Header{}
+		jrc.Data[common.MetadataKey] = &mdCopy
+	}
+
+	go m.run(job, &jrc, method, cancel)
+
+	return job, nil
+}
+
+// Run the job and record its outcome
+func (m *Manager) run(job Job, rc *common.RequestContext, method server.JsonRpcMethod, cancel context.CancelFunc) {
+	defer func() {
+		cancel()
+
+		m.mu.Lock()
+		delete(m.cancels, job.Id)
+		m.mu.Unlock()
+	}()
+
+	if !m.acquire(rc.GetContext()) {
+		return
+	}
+	defer m.release()
+
+	if !m.update(job.Id, func(j *Job) bool {
+		if j.Status != StatusPending {
+			return false
+		}
+		j.Status = StatusRunning
+		return true
+	}) {
+		return
+	}
+
+	reporter := progress.NewReporter(&jobNotifier{m: m, id: job.Id, done: rc.GetContext().Done()}, json.RawMessage(`"`+job.Id+`"`), m.ProgressInterval)
+	rc.Data[progress.Key] = reporter
+
+	invoke(rc, method)
+	reporter.Stop()
+
+	m.update(job.Id, func(j *Job) bool {
+		// A cancelled job stays cancelled whatever the handler returned
+		if j.Status != StatusRunning {
+			return false
+		}
+
+		if rc.JsonRpcResponse.Error != nil {
+			j.Status = StatusFailed
+			j.Error = rc.JsonRpcResponse.Error
+		} else {
+			j.Status = StatusSucceeded
+			j.Result = rc.JsonRpcResponse.Result
+		}
+		j.FinishedAt = m.clock()
+		j.ExpiresAt = j.FinishedAt.Add(m.ttl())
+
+		return true
+	})
+}
+
+// Invoke the method, turning a panic into an internal error
+func invoke(rc *common.RequestContext, method server.JsonRpcMethod) {
+	defer func() {
+		if r := recover(); r != nil {
+			rc.Logger.Println("job panicked", r)
+			rc.MakeErrorResponse(common.InternalError)
+		}
+	}()
+
+	_ = server.InvokeMethod(rc, method)
+}
+
+// Change a job record, f returns false to leave it as is
+func (m *Manager) update(id string, f func(j *Job) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok, err := m.Store.Get(id)
+	if err != nil || !ok || !f(&job) {
+		return false
+	}
+
+	if err = m.Store.Put(job); err != nil {
+		m.Logger.Println("job store error", err.Error())
+		return false
+	}
+
+	return true
+}
+
+// Get a job record
+func (m *Manager) Get(id string) (Job, bool, error) {
+	job, ok, err := m.Store.Get(id)
+	if err != nil || !ok {
+		return job, false, err
+	}
+
+	if !job.ExpiresAt.IsZero() && !m.clock().Before(job.ExpiresAt) {
+		_ = m.Store.Delete(id)
+		return Job{}, false, nil
+	}
+
+	return job, true, nil
+}
+
+// Cancel a job, returns false if it is not pending or running
+func (m *Manager) Cancel(id string) bool {
+	cancelled := m.update(id, func(j *Job) bool {
+		if j.Status != StatusPending && j.Status != StatusRunning {
+			return false
+		}
+
+		j.Status = StatusCancelled
+		j.Error, _ = json.Marshal(JobCancelledError)
+		j.FinishedAt = m.clock()
+		j.ExpiresAt = j.FinishedAt.Add(m.ttl())
+		return true
+	})
+
+	if cancelled {
+		m.mu.Lock()
+		cancel := m.cancels[id]
+		m.mu.Unlock()
+
+		if cancel != nil {
+			cancel()
+		}
+	}
+
+	return cancelled
+}
+
+// Remove the expired jobs
+// Unfinished jobs not run by this manager were interrupted (e.g. the process crashed before they finished),
+// they fail with JobInterruptedError and expire as usual. So a store must not be shared by managers running at once.
+func (m *Manager) Sweep() {
+	jobs, err := m.Store.List()
+	if err != nil {
+		m.Logger.Println("job store error", err.Error())
+		return
+	}
+
+	now := m.clock()
+	for _, job := range jobs {
+		if !job.ExpiresAt.IsZero() && !now.Before(job.ExpiresAt) {
+			_ = m.Store.Delete(job.Id)
+			continue
+		}
+
+		if job.Status == StatusPending || job.Status == StatusRunning {
+			m.interrupt(job.Id)
+		}
+	}
+}
+
+// Fail an unfinished job that is not run by this manager
+func (m *Manager) interrupt(id string) {
+	m.update(id, func(j *Job) bool {
+		if _, ok := m.cancels[id]; ok || (j.Status != StatusPending && j.Status != StatusRunning) {
+			return false
+		}
+
+		j.Status = StatusFailed
+		j.Error, _ = json.Marshal(JobInterruptedError)
+		j.FinishedAt = m.clock()
+		j.ExpiresAt = j.FinishedAt.Add(m.ttl())
+		return true
+	})
+}
+
+// Records the progress reports of a job
+type jobNotifier struct {
+	m    *Manager
+	id   string
+	done <-chan struct{}
+}
+
+func (n *jobNotifier) Notify(method string, params interface{}) error {
+	p, ok := params.(progress.Params)
+	if !ok {
+		return nil
+	}
+
+	n.m.update(n.id, func(j *Job) bool {
+		j.Progress = p.Value
+		return j.Status == StatusRunning
+	})
+
+	return nil
+}
+
+func (n *jobNotifier) Done() <-chan struct{} {
+	return n.done
+}
+
+// Wraps a method to run it as a job
+type asyncMethod struct {
+	m      *Manager
+	method server.JsonRpcMethod
+}
+
+func (a asyncMethod) Handle_start(rc *common.RequestContext, params json.RawMessage) (Ticket, common.Error, error) {
+	job, err := a.m.Start(rc, a.method)
+	if err != nil {
+		a.m.Logger.Println("could not start a job", err.Error())
+		return Ticket{}, common.InternalError, nil
+	}
+
+	return Ticket{JobId: job.Id}, common.Error{}, nil
+}
+
+// Built-in job methods
+type handler struct {
+	m *Manager
+}
+
+func (h handler) Handle_status(params Params) (Job, common.Error, error) {
+	job, ok, err := h.m.Get(params.Id)
+	if err != nil {
+		return Job{}, common.InternalError, nil
+	}
+	if !ok {
+		return Job{}, JobNotFoundError, nil
+	}
+
+	// The result is fetched with rpc.job.result
+	job.Result = nil
+
+	return job, common.Error{}, nil
+}
+
+func (h handler) Handle_result(params Params) (json.RawMessage, common.Error, error) {
+	job, ok, err := h.m.Get(params.Id)
+	if err != nil {
+		return nil, common.InternalError, nil
+	}
+	if !ok {
+		return nil, JobNotFoundError, nil
+	}
+
+	switch job.Status {
+	case StatusSucceeded:
+		return job.Result, common.Error{}, nil
+	case StatusFailed, StatusCancelled:
+		e := common.Error{}
+		if json.Unmarshal(job.Error, &e) != nil {
+			e = common.InternalError
+		}
+		return nil, e, nil
+	}
+
+	return nil, JobNotFinishedError, nil
+}
+
+func (h handler) Handle_cancel(params Params) (bool, common.Error, error) {
+	if _, ok, _ := h.m.Get(params.Id); !ok {
+		return false, JobNotFoundError, nil
+	}
+
+	return h.m.Cancel(params.Id), common.Error{}, nil
+}
@@ -0,0 +1,385 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/progress"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/server/auth"
+)
+
+var test_ExportFailedError = common.Error{
+	Code:    "-1",
+	Message: "Export failed",
+}
+
+type test_JobHandler struct {
+	release chan struct{}
+}
+
+func (h test_JobHandler) Handle_export(rc *common.RequestContext, params struct {
+	Items int `json:"items"`
+}) (result int, jsonRpcError common.Error, err error) {
+	for i := 1; i <= params.Items; i++ {
+		_ = progress.From(rc).Update(int64(i), int64(params.Items), "")
+	}
+
+	if params.Items < 0 {
+		return 0, test_ExportFailedError, nil
+	}
+
+	return params.Items, common.Error{}, nil
+}
+
+func (h test_JobHandler) Handle_wait(rc *common.RequestContext, params struct{}) (result bool, jsonRpcError common.Error, err error) {
+	select {
+	case <-rc.GetContext().Done():
+	case <-h.release:
+	}
+	return true, common.Error{}, nil
+}
+
+func test_Server(m *Manager) (*server.JsonRpcServer, test_JobHandler) {
+	h := test_JobHandler{release: make(chan struct{})}
+
+	s := server.NewServer()
+	s.AddHandler(h, "Handle_")
+	m.Register(s)
+	_ = m.Async(s, "export", "wait")
+
+	return s, h
+}
+
+func test_Call(s *server.JsonRpcServer, method string, params string) common.Response {
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"` + method + `","params":` + params + `}`)
+	_ = s.ProcessRawInput(&rc)
+
+	return rc.JsonRpcResponse
+}
+
+func test_Start(t *testing.T, s *server.JsonRpcServer, method string, params string) string {
+	ticket := Ticket{}
+	response := test_Call(s, method, params)
+	if err := json.Unmarshal(response.Result, &ticket); err != nil || ticket.JobId == "" {
+		t.Fatalf("No job id in %s", string(response.Result))
+	}
+
+	return ticket.JobId
+}
+
+func test_WaitStatus(s *server.JsonRpcServer, id string, status Status) (job Job) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job = Job{}
+		_ = json.Unmarshal(test_Call(s, "rpc.job.status", `{"id":"`+id+`"}`).Result, &job)
+		if job.Status == status {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return
+}
+
+func TestManager_Async(t *testing.T) {
+	m := NewManager(nil)
+	s, _ := test_Server(m)
+
+	if err := m.Async(s, "nope"); err == nil {
+		t.Errorf("Unknown method was made async")
+	}
+
+	id := test_Start(t, s, "export", `{"items":3}`)
+
+	job := test_WaitStatus(s, id, StatusSucceeded)
+	if job.Status != StatusSucceeded || job.Method != "export" || job.ExpiresAt.IsZero() {
+		t.Fatalf("Job did not succeed: %v", job)
+	}
+
+	value := progress.Value{}
+	if _ = json.Unmarshal(job.Progress, &value); value.Done != 3 {
+		t.Errorf("Progress was not recorded: %s", string(job.Progress))
+	}
+
+	if job.Result != nil {
+		t.Errorf("Status contains the result")
+	}
+
+	if response := test_Call(s, "rpc.job.result", `{"id":"`+id+`"}`); string(response.Result) != "3" {
+		t.Errorf("Got result %s", string(response.Result))
+	}
+
+	// Failures are passed through
+	id = test_Start(t, s, "export", `{"items":-1}`)
+	test_WaitStatus(s, id, StatusFailed)
+
+	response := test_Call(s, "rpc.job.result", `{"id":"`+id+`"}`)
+	e := common.Error{}
+	if _ = json.Unmarshal(response.Error, &e); e.Code != test_ExportFailedError.Code {
+		t.Errorf("Got error %s", string(response.Error))
+	}
+
+	response = test_Call(s, "rpc.job.status", `{"id":"nope"}`)
+	if _ = json.Unmarshal(response.Error, &e); e.Code != JobNotFoundError.Code {
+		t.Errorf("Unknown job was found")
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	m := NewManager(nil)
+	m.MaxConcurrent = 1
+	s, h := test_Server(m)
+
+	first := test_Start(t, s, "wait", `{}`)
+	if job := test_WaitStatus(s, first, StatusRunning); job.Status != StatusRunning {
+		t.Fatalf("First job is not running")
+	}
+
+	second := test_Start(t, s, "wait", `{}`)
+
+	// The second one waits for a free slot
+	time.Sleep(20 * time.Millisecond)
+	if job, _, _ := m.Get(second); job.Status != StatusPending {
+		t.Errorf("Concurrency limit was not applied, status %s", job.Status)
+	}
+
+	e := common.Error{}
+	response := test_Call(s, "rpc.job.result", `{"id":"`+first+`"}`)
+	if _ = json.Unmarshal(response.Error, &e); e.Code != JobNotFinishedError.Code {
+		t.Errorf("Unfinished job has a result")
+	}
+
+	if response = test_Call(s, "rpc.job.cancel", `{"id":"`+first+`"}`); string(response.Result) != "true" {
+		t.Errorf("Job was not cancelled")
+	}
+
+	if response = test_Call(s, "rpc.job.cancel", `{"id":"`+first+`"}`); string(response.Result) != "false" {
+		t.Errorf("Job was cancelled twice")
+	}
+
+	response = test_Call(s, "rpc.job.result", `{"id":"`+first+`"}`)
+	if _ = json.Unmarshal(response.Error, &e); e.Code != JobCancelledError.Code {
+		t.Errorf("Cancelled job got %s", string(response.Error))
+	}
+
+	// The slot is free now
+	if job := test_WaitStatus(s, second, StatusRunning); job.Status != StatusRunning {
+		t.Errorf("Second job did not start")
+	}
+
+	close(h.release)
+	if job := test_WaitStatus(s, second, StatusSucceeded); job.Status != StatusSucceeded {
+		t.Errorf("Second job did not finish")
+	}
+
+	if job, _, _ := m.Get(first); job.Status != StatusCancelled {
+		t.Errorf("Cancelled job changed its status to %s", job.Status)
+	}
+}
+
+func TestManager_TTL(t *testing.T) {
+	now := time.Now()
+
+	m := NewManager(nil)
+	m.TTL = time.Minute
+	m.now = func() time.Time { return now }
+	s, _ := test_Server(m)
+
+	id := test_Start(t, s, "export", `{"items":1}`)
+	test_WaitStatus(s, id, StatusSucceeded)
+
+	if _, ok, _ := m.Get(id); !ok {
+		t.Fatalf("Job expired too early")
+	}
+
+	now = now.Add(time.Minute)
+	m.Sweep()
+
+	if jobs, _ := m.Store.List(); len(jobs) != 0 {
+		t.Errorf("Expired job was not removed")
+	}
+}
+
+// A store counting the listings
+type test_CountingStore struct {
+	*MemoryStore
+	lists int
+	mu    sync.Mutex
+}
+
+func (s *test_CountingStore) List() ([]Job, error) {
+	s.mu.Lock()
+	s.lists++
+	s.mu.Unlock()
+
+	return s.MemoryStore.List()
+}
+
+func TestManager_SweepInterval(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+
+	store := &test_CountingStore{MemoryStore: NewMemoryStore()}
+	m := NewManager(store)
+	m.SweepInterval = time.Minute
+	m.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	s, _ := test_Server(m)
+
+	for i := 0; i < 3; i++ {
+		test_Start(t, s, "export", `{"items":1}`)
+	}
+
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	test_Start(t, s, "export", `{"items":1}`)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.lists != 2 {
+		t.Errorf("Store was swept %d times", store.lists)
+	}
+}
+
+func TestManager_Interrupted(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Could not create a file store: %s", err.Error())
+	}
+
+	// Left unfinished by a process that crashed
+	_ = store.Put(Job{Id: "crashed", Method: "export", Status: StatusRunning, CreatedAt: time.Now()})
+
+	m := NewManager(store)
+	m.TTL = time.Minute
+	s, h := test_Server(m)
+	defer close(h.release)
+
+	// A job run by this manager is left alone
+	id := test_Start(t, s, "wait", `{}`)
+	test_WaitStatus(s, id, StatusRunning)
+
+	m.Sweep()
+
+	job, ok, _ := m.Get("crashed")
+	if !ok || job.Status != StatusFailed || job.ExpiresAt.IsZero() {
+		t.Fatalf("Interrupted job was not failed: %v", job)
+	}
+
+	response := test_Call(s, "rpc.job.result", `{"id":"crashed"}`)
+	if e := (common.Error{}); json.Unmarshal(response.Error, &e) != nil || e.Code != JobInterruptedError.Code {
+		t.Errorf("Got result error %s", string(response.Error))
+	}
+
+	if job, _, _ = m.Get(id); job.Status != StatusRunning {
+		t.Errorf("Running job was interrupted: %v", job)
+	}
+}
+
+func TestManager_AsyncScopes(t *testing.T) {
+	m := NewManager(nil)
+	h := test_JobHandler{release: make(chan struct{})}
+	defer close(h.release)
+
+	s := server.NewServer()
+	s.AddHandler(h, "Handle_")
+	m.Register(s)
+
+	// The scopes may be required before or after making the method async
+	_ = s.RequireScopes("export", "reports:write")
+	_ = m.Async(s, "export", "wait")
+	_ = s.RequireScopes("wait", "reports:write")
+
+	tokens := auth.TokenVerifierFunc(func(ctx context.Context, token string) (*auth.Principal, error) {
+		if token == "writer" {
+			return &auth.Principal{Id: token, Scopes: []string{"reports:write"}}, nil
+		}
+		return &auth.Principal{Id: token}, nil
+	})
+	s.PreProcessingStages = append(s.PreProcessingStages, auth.Stage(auth.Bearer{Verifier: tokens}), auth.Authorize(s))
+
+	testData := []struct {
+		Token  string
+		Method string
+		Error  json.Number
+	}{
+		{"reader", "export", common.ForbiddenError.Code},
+		{"reader", "wait", common.ForbiddenError.Code},
+		{"writer", "export", ""},
+		{"writer", "wait", ""},
+	}
+
+	for k, data := range testData {
+		m := common.NewMetadata("http", "127.0.0.1:1234")
+		m.Headers.Set("Authorization", "Bearer "+data.Token)
+
+		rc := common.EmptyRequestContext()
+		rc.Data[common.MetadataKey] = m
+		rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"` + data.Method + `","params":{}}`)
+		_ = s.ProcessRawInput(&rc)
+
+		e := common.Error{}
+		_ = json.Unmarshal(rc.JsonRpcResponse.Error, &e)
+		if e.Code != data.Error {
+			t.Errorf("%d got response %s", k, string(rc.RawResponse))
+		}
+
+		if method, _ := s.GetMethod(data.Method); !reflect.DeepEqual(method.Scopes, []string{"reports:write"}) {
+			t.Errorf("%d async method has scopes %v", k, method.Scopes)
+		}
+	}
+}
+
+type test_MetadataHandler struct{}
+
+func (h test_MetadataHandler) Handle_headers(rc *common.RequestContext, params struct{}) (result string, jsonRpcError common.Error, err error) {
+	if md, ok := rc.GetMetadata(); ok {
+		md.SetResponseHeader("X-Job", "done")
+		result = md.Header("Authorization")
+	}
+	return
+}
+
+func TestManager_Metadata(t *testing.T) {
+	m := NewManager(nil)
+	s := server.NewServer()
+	s.AddHandler(test_MetadataHandler{}, "Handle_")
+	m.Register(s)
+	_ = m.Async(s, "headers")
+
+	// The response headers stand for those of the http.ResponseWriter
+	responseHeaders := http.Header{}
+	r, _ := http.NewRequest("POST", "/", nil)
+	r.Header.Set("Authorization", "Bearer x")
+
+	rc := common.EmptyRequestContext()
+	rc.Data[common.MetadataKey] = common.NewHttpMetadata("http", r, responseHeaders)
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"headers","params":{}}`)
+	_ = s.ProcessRawInput(&rc)
+
+	ticket := Ticket{}
+	if err := json.Unmarshal(rc.JsonRpcResponse.Result, &ticket); err != nil || ticket.JobId == "" {
+		t.Fatalf("No job id in %s", string(rc.RawResponse))
+	}
+	test_WaitStatus(s, ticket.JobId, StatusSucceeded)
+
+	// The job sees the request headers but does not touch the response of the request
+	if response := test_Call(s, "rpc.job.result", `{"id":"`+ticket.JobId+`"}`); string(response.Result) != `"Bearer x"` {
+		t.Errorf("Job did not get the request headers: %s", string(response.Result))
+	}
+	if len(responseHeaders) != 0 {
+		t.Errorf("Job wrote the response headers of the request: %v", responseHeaders)
+	}
+}
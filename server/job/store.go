@@ -0,0 +1,203 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Storage of job records
+// Implementations must be safe for concurrent use
+type Store interface {
+	Put(job Job) error
+	// Get a job, ok is false if there is no such job
+	Get(id string) (job Job, ok bool, err error)
+	Delete(id string) error
+	List() ([]Job, error)
+}
+
+// Keeps the jobs in memory
+type MemoryStore struct {
+	jobs map[string]Job
+	mu   sync.RWMutex
+}
+
+// Keeps each job in a JSON file of the directory, so the finished jobs survive restarts
+type FileStore struct {
+	Dir string
+	mu  sync.RWMutex
+}
+
+// Create a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]Job{}}
+}
+
+func (s *MemoryStore) Put(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = map[string]Job{}
+	}
+	s.jobs[job.Id] = job
+
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Job, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	return job, ok, nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		r = append(r, job)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].CreatedAt.Before(r[j].CreatedAt) })
+
+	return r, nil
+}
+
+// Job ids are used as file names so they are restricted to a safe alphabet
+var validId = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// Create a new file-backed store, the directory is created if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validId.MatchString(id) {
+		return "", fmt.Errorf("bad job id %q", id)
+	}
+
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+func (s *FileStore) Put(job Job) error {
+	path, err := s.path(job.Id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write and rename so that readers never see a partial record
+	tmp, err := ioutil.TempFile(s.Dir, job.Id+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Get(id string) (Job, bool, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Job{}, false, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Job{}, false, nil
+	} else if err != nil {
+		return Job{}, false, err
+	}
+
+	job := Job{}
+	if err = json.Unmarshal(data, &job); err != nil {
+		return Job{}, false, err
+	}
+
+	return job, true, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) List() ([]Job, error) {
+	s.mu.RLock()
+	files, err := ioutil.ReadDir(s.Dir)
+	s.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]Job, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		job, ok, err := s.Get(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			r = append(r, job)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].CreatedAt.Before(r[j].CreatedAt) })
+
+	return r, nil
+}
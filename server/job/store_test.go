@@ -0,0 +1,47 @@
+package job
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Could not create a file store: %s", err.Error())
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for k, store := range []Store{NewMemoryStore(), fileStore} {
+		first := Job{Id: "a1", Method: "m", Status: StatusRunning, CreatedAt: now}
+		second := Job{Id: "b2", Method: "m", Status: StatusSucceeded, Result: []byte(`{"x":1}`), CreatedAt: now.Add(time.Second)}
+
+		if err = store.Put(second); err != nil {
+			t.Errorf("%d put failed: %s", k, err.Error())
+		}
+		_ = store.Put(first)
+
+		if job, ok, err := store.Get("b2"); err != nil || !ok || !reflect.DeepEqual(job, second) {
+			t.Errorf("%d got job %v", k, job)
+		}
+
+		if _, ok, _ := store.Get("nope"); ok {
+			t.Errorf("%d unknown job was found", k)
+		}
+
+		if jobs, _ := store.List(); len(jobs) != 2 || jobs[0].Id != "a1" || jobs[1].Id != "b2" {
+			t.Errorf("%d got jobs %v", k, jobs)
+		}
+
+		_ = store.Delete("a1")
+		if jobs, _ := store.List(); len(jobs) != 1 {
+			t.Errorf("%d job was not deleted", k)
+		}
+	}
+
+	if err = fileStore.Put(Job{Id: "../escape"}); err == nil {
+		t.Errorf("Bad job id was accepted")
+	}
+}
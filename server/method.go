@@ -20,8 +20,10 @@ func ExtractMethods(handler Handler, methodNamePrefix string) (r []JsonRpcMethod
 		}
 
 		// Should have exactly 2 input parameters: *receiver and *params
+		// or 3 input parameters: *receiver, *common.RequestContext and *params
 		// TODO: add support for Array-Params requests
-		if m.Type.NumIn() != 2 {
+		withContext := m.Type.NumIn() == 3 && m.Type.In(1) == reflect.TypeOf(&common.RequestContext{})
+		if m.Type.NumIn() != 2 && !withContext {
 			continue
 		}
 
@@ -39,11 +41,12 @@ func ExtractMethods(handler Handler, methodNamePrefix string) (r []JsonRpcMethod
 		}
 
 		description := JsonRpcMethod{
-			Receiver:   handler,
-			Name:       strings.TrimPrefix(m.Name, methodNamePrefix),
-			Method:     m,
-			ParamsType: m.Type.In(1),
-			ResultType: m.Type.Out(0),
+			Receiver:    handler,
+			Name:        strings.TrimPrefix(m.Name, methodNamePrefix),
+			Method:      m,
+			ParamsType:  m.Type.In(m.Type.NumIn() - 1),
+			ResultType:  m.Type.Out(0),
+			WithContext: withContext,
 		}
 
 		r = append(r, description)
@@ -188,3 +188,35 @@ func TestJsonRpcMethod_ExtractResult(t *testing.T) {
 	}
 
 }
+
+type test_ContextHandler struct{}
+
+func (c test_ContextHandler) Handle_method(rc *common.RequestContext, params struct {
+	Name string `json:"name"`
+}) (response string, jsonRpcError common.Error, err error) {
+	response = rc.JsonRpcRequest.Id + " " + params.Name
+	return
+}
+
+// Testing extraction and invocation of methods taking a Request Context
+func TestExtractMethods_WithContext(t *testing.T) {
+	m := ExtractMethods(test_ContextHandler{}, "Handle_")
+
+	if len(m) != 1 {
+		t.Fatalf("Method taking a context was not extracted")
+	}
+
+	if !m[0].WithContext || m[0].ParamsType.Kind() != reflect.Struct {
+		t.Errorf("Method taking a context was extracted wrongly")
+	}
+
+	rc := common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"test","method":"method","params":{"name":"lol"}}`)
+	_ = rc.ParseRawRequest()
+	_ = InvokeMethod(&rc, m[0])
+
+	if string(rc.JsonRpcResponse.Result) != `"test lol"` {
+		t.Errorf("Method taking a context was invoked wrongly")
+		t.Error(string(rc.JsonRpcResponse.Result))
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"reflect"
+
 	"github.com/yekhlakov/gojsonrpc/common"
 )
 
@@ -18,6 +20,11 @@ func InvokeMethod(rc *common.RequestContext, m JsonRpcMethod) (err error) {
 		return
 	}
 
+	// Context-aware methods get the Request Context right after the receiver
+	if m.WithContext {
+		boundParams = []reflect.Value{boundParams[0], reflect.ValueOf(rc), boundParams[1]}
+	}
+
 	// Call the method and get back the results which is an array of Values
 	results := m.Method.Func.Call(boundParams)
 
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/yekhlakov/gojsonrpc/common"
 )
@@ -24,12 +25,62 @@ func (e *JsonRpcServer) AddHandler(handler Handler, methodNamePrefix string) {
 	}
 }
 
+// Add a handler registering its methods under the given namespace, e.g. "rpc." + "unsubscribe"
+func (e *JsonRpcServer) AddHandlerWithNamespace(handler Handler, methodNamePrefix string, namespace string) {
+	methods := ExtractMethods(handler, methodNamePrefix)
+
+	for _, method := range methods {
+		method.Name = namespace + method.Name
+		e.Methods[method.Name] = method
+	}
+}
+
 // Get a method from the server
 func (e *JsonRpcServer) GetMethod(name string) (method JsonRpcMethod, ok bool) {
 	method, ok = e.Methods[name]
 	return
 }
 
+// Mark the registered methods as safe, i.e. read-only and cacheable
+func (e *JsonRpcServer) MarkSafe(methods ...string) error {
+	for _, name := range methods {
+		method, ok := e.Methods[name]
+		if !ok {
+			return fmt.Errorf("unknown method %s", name)
+		}
+
+		method.Safe = true
+		e.Methods[name] = method
+	}
+
+	return nil
+}
+
+// Require the scopes from the callers of the registered method, e.g. admin:write for users.delete
+// The scopes are added to the ones required before
+func (e *JsonRpcServer) RequireScopes(name string, scopes ...string) error {
+	method, ok := e.Methods[name]
+	if !ok {
+		return fmt.Errorf("unknown method %s", name)
+	}
+
+	method.Scopes = append(append([]string{}, method.Scopes...), scopes...)
+	e.Methods[name] = method
+
+	return nil
+}
+
+// Check if the server has any safe methods
+func (e *JsonRpcServer) HasSafeMethods() bool {
+	for _, method := range e.Methods {
+		if method.Safe {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Get RAW request (probably a batch), return RAW response
 func (e *JsonRpcServer) ProcessRawInput(context *common.RequestContext) (err error) {
 
@@ -83,13 +134,23 @@ func (e *JsonRpcServer) ProcessRawInput(context *common.RequestContext) (err err
 // Get a list of RAW requests of the batch, process each request, return RAW batch response
 func (e *JsonRpcServer) ProcessRawBatch(batch []json.RawMessage, context *common.RequestContext) (err error) {
 
-	results := make([]json.RawMessage, len(batch))
+	results := make([]json.RawMessage, 0, len(batch))
 
-	for i, rawRequest := range batch {
+	for _, rawRequest := range batch {
 		localContext := *context
 		localContext.RawRequest = rawRequest
 		_ = e.ProcessRawRequest(&localContext)
-		results[i] = localContext.RawResponse
+
+		// Notifications get no response
+		if localContext.ShouldRespond() {
+			results = append(results, localContext.RawResponse)
+		}
+	}
+
+	// A batch of notifications only gets no response at all
+	if len(batch) > 0 && len(results) == 0 {
+		context.RawResponse = nil
+		return
 	}
 
 	context.RawResponse, err = json.Marshal(results)
@@ -107,16 +168,38 @@ func (e *JsonRpcServer) ProcessRawRequest(context *common.RequestContext) (err e
 		return
 	}
 
-	// Get method from the server
-	if method, ok := e.GetMethod(context.JsonRpcRequest.Method); ok {
-		// Apply pre-processing pipeline
-		context.ApplyPipeline(&e.PreProcessingStages)
+	// Requests coming through persistent connections may be cancelled by id
+	if canceller, ok := context.GetCanceller(); ok {
+		if context.JsonRpcRequest.Method == common.CancelRequestMethod {
+			cancelRequest(canceller, context)
+			_ = context.RebuildRawResponse()
+			return
+		}
 
-		// InvokeMethod the method
-		err = InvokeMethod(context, method)
+		if context.JsonRpcRequest.Id != "" {
+			done := canceller.Track(context)
+			defer func() {
+				if done() {
+					context.MakeErrorResponse(common.RequestCancelledError)
+					_ = context.RebuildRawResponse()
+				}
+			}()
+		}
+	}
 
-		// Apply post-processing pipeline
-		context.ApplyPipeline(&e.PostProcessingStages)
+	// Get method from the server
+	if method, ok := e.GetMethod(context.JsonRpcRequest.Method); ok {
+		// Apply pre-processing pipeline, a failed stage (e.g. an authentication one) stops the processing
+		// leaving the response it has made
+		if context.ApplyPipeline(&e.PreProcessingStages) {
+			// InvokeMethod the method
+			err = InvokeMethod(context, method)
+
+			// Apply post-processing pipeline
+			context.ApplyPipeline(&e.PostProcessingStages)
+		} else if context.JsonRpcResponse.Error == nil && context.JsonRpcResponse.Result == nil {
+			context.MakeErrorResponse(common.InternalError)
+		}
 
 	} else {
 		context.MakeErrorResponse(common.MethodNotFoundError)
@@ -128,3 +211,15 @@ func (e *JsonRpcServer) ProcessRawRequest(context *common.RequestContext) (err e
 
 	return
 }
+
+// Handle the cancel notification, the result tells if the request was found
+func cancelRequest(canceller *common.Canceller, rc *common.RequestContext) {
+	params := common.CancelParams{}
+	if err := json.Unmarshal(rc.JsonRpcRequest.Params, &params); err != nil || params.RequestId() == "" {
+		rc.MakeErrorResponse(common.InvalidParamsError)
+		return
+	}
+
+	result, _ := json.Marshal(canceller.Cancel(params.RequestId()))
+	rc.JsonRpcResponse = rc.JsonRpcRequest.MakeResponse(result, nil)
+}
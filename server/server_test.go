@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/yekhlakov/gojsonrpc/common"
@@ -114,6 +115,57 @@ func TestJsonRpcServer_ProcessRawRequest(t *testing.T) {
 	}
 }
 
+func TestJsonRpcServer_PreProcessingStages(t *testing.T) {
+	invoked := 0
+
+	testData := []struct {
+		Stage   common.Stage
+		Out     string
+		Invoked int
+	}{
+		{
+			func(rc *common.RequestContext) bool { return true },
+			`{"jsonrpc":"2.0","id":"test","result":{"value":"lol"}}`,
+			1,
+		},
+		{
+			func(rc *common.RequestContext) bool {
+				rc.MakeErrorResponse(common.UnauthorizedError)
+				return false
+			},
+			`{"jsonrpc":"2.0","id":"test","error":{"code":-32001,"message":"Unauthorized"}}`,
+			0,
+		},
+		{
+			func(rc *common.RequestContext) bool { return false },
+			`{"jsonrpc":"2.0","id":"test","error":{"code":-32603,"message":"Internal error"}}`,
+			0,
+		},
+	}
+
+	for k, data := range testData {
+		s := NewServer()
+		s.AddHandler(test_PassHandler{}, "Handle_")
+		s.PreProcessingStages = append(s.PreProcessingStages, data.Stage)
+		s.PostProcessingStages = append(s.PostProcessingStages, func(rc *common.RequestContext) bool {
+			invoked++
+			return true
+		})
+
+		invoked = 0
+		rc := common.EmptyRequestContext()
+		rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"test","method":"pass","params":{"name":"lol"}}`)
+		_ = s.ProcessRawRequest(&rc)
+
+		if string(rc.RawResponse) != data.Out {
+			t.Errorf("%d Request was not processed properly: %s", k, string(rc.RawResponse))
+		}
+		if invoked != data.Invoked {
+			t.Errorf("%d Post-processing stages were applied %d times", k, invoked)
+		}
+	}
+}
+
 func TestJsonRpcServer_ProcessRawBatch(t *testing.T) {
 	testData := []struct {
 		Handler Handler
@@ -146,6 +198,23 @@ func TestJsonRpcServer_ProcessRawBatch(t *testing.T) {
 			},
 			`[{"jsonrpc":"2.0","id":"t1","result":{"value":"lol"}},{"jsonrpc":"2.0","id":"t2","error":{"code":-32601,"message":"Method not found"}}]`,
 		},
+		{
+			test_PassHandler{},
+			[]string{
+				`{"jsonrpc":"2.0","id":"t1","method":"pass","params":{"name":"lol"}}`,
+				`{"jsonrpc":"2.0","method":"pass","params":{"name":"kek"}}`,
+				`{"jsonrpc":"1.0","method":"pass","params":{"name":"kek"}}`,
+			},
+			`[{"jsonrpc":"2.0","id":"t1","result":{"value":"lol"}},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"}}]`,
+		},
+		{
+			test_PassHandler{},
+			[]string{
+				`{"jsonrpc":"2.0","method":"pass","params":{"name":"lol"}}`,
+				`{"jsonrpc":"2.0","method":"nope","params":{"name":"kek"}}`,
+			},
+			``,
+		},
 	}
 
 	for k, data := range testData {
@@ -242,3 +311,128 @@ func TestJsonRpcServer_ProcessRawInput(t *testing.T) {
 		}
 	}
 }
+
+func TestJsonRpcServer_AddHandlerWithNamespace(t *testing.T) {
+	s := NewServer()
+
+	s.AddHandlerWithNamespace(test_PassHandler{}, "Handle_", "test.")
+
+	if m, ok := s.GetMethod("test.pass"); !ok {
+		t.Errorf("Method was not added under the namespace")
+	} else if m.Name != "test.pass" {
+		t.Errorf("Method name was not prefixed")
+	}
+
+	if _, ok := s.GetMethod("pass"); ok {
+		t.Errorf("Method was added without the namespace")
+	}
+}
+
+func TestJsonRpcServer_MarkSafe(t *testing.T) {
+	s := NewServer()
+	s.AddHandler(test_PassHandler{}, "Handle_")
+	s.AddHandler(test_ConstHandler{}, "Handle_")
+
+	if s.HasSafeMethods() {
+		t.Errorf("Methods are safe by default")
+	}
+
+	if s.MarkSafe("nope") == nil {
+		t.Errorf("Unknown method was marked safe")
+	}
+
+	if err := s.MarkSafe("const"); err != nil {
+		t.Errorf("Method was not marked safe: %s", err.Error())
+	}
+
+	if m, _ := s.GetMethod("const"); !m.Safe || !s.HasSafeMethods() {
+		t.Errorf("Method is not safe")
+	}
+
+	if m, _ := s.GetMethod("pass"); m.Safe {
+		t.Errorf("Wrong method was marked safe")
+	}
+}
+
+func TestJsonRpcServer_RequireScopes(t *testing.T) {
+	s := NewServer()
+	s.AddHandler(test_PassHandler{}, "Handle_")
+	s.AddHandler(test_ConstHandler{}, "Handle_")
+
+	if s.RequireScopes("nope", "admin:write") == nil {
+		t.Errorf("Scopes were required for an unknown method")
+	}
+
+	_ = s.RequireScopes("pass", "admin:write")
+	_ = s.RequireScopes("pass", "admin:read")
+
+	if m, _ := s.GetMethod("pass"); strings.Join(m.Scopes, " ") != "admin:write admin:read" {
+		t.Errorf("Wrong scopes are required: %v", m.Scopes)
+	}
+
+	if m, _ := s.GetMethod("const"); len(m.Scopes) != 0 {
+		t.Errorf("Scopes were required for a wrong method")
+	}
+}
+
+type test_WaitHandler struct {
+	started chan struct{}
+}
+
+func (h test_WaitHandler) Handle_wait(rc *common.RequestContext, params struct{}) (result bool, jsonRpcError common.Error, err error) {
+	close(h.started)
+	<-rc.GetContext().Done()
+	return true, common.Error{}, nil
+}
+
+func TestJsonRpcServer_CancelRequest(t *testing.T) {
+	h := test_WaitHandler{started: make(chan struct{})}
+	s := NewServer()
+	s.AddHandler(h, "Handle_")
+
+	canceller := common.NewCanceller()
+
+	rc := common.EmptyRequestContext()
+	rc.Data[common.CancellerKey] = canceller
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"1","method":"wait","params":{}}`)
+
+	done := make(chan struct{})
+	go func() {
+		_ = s.ProcessRawInput(&rc)
+		close(done)
+	}()
+	<-h.started
+
+	testData := []struct {
+		Request  string
+		Response string
+	}{
+		{`{"jsonrpc":"2.0","id":"c1","method":"$/cancelRequest","params":{"id":"2"}}`, `{"jsonrpc":"2.0","id":"c1","result":false}`},
+		{`{"jsonrpc":"2.0","id":"c2","method":"$/cancelRequest","params":{}}`, `{"jsonrpc":"2.0","id":"c2","error":{"code":-32602,"message":"Invalid params"}}`},
+		{`{"jsonrpc":"2.0","id":"c3","method":"$/cancelRequest","params":{"id":"1"}}`, `{"jsonrpc":"2.0","id":"c3","result":true}`},
+	}
+
+	for k, data := range testData {
+		cancelRc := common.EmptyRequestContext()
+		cancelRc.Data[common.CancellerKey] = canceller
+		cancelRc.RawRequest = []byte(data.Request)
+		_ = s.ProcessRawInput(&cancelRc)
+
+		if string(cancelRc.RawResponse) != data.Response {
+			t.Errorf("%d got response %s", k, string(cancelRc.RawResponse))
+		}
+	}
+
+	<-done
+	if string(rc.RawResponse) != `{"jsonrpc":"2.0","id":"1","error":{"code":-32800,"message":"Request cancelled"}}` {
+		t.Errorf("Cancelled request got response %s", string(rc.RawResponse))
+	}
+
+	// Without a canceller the method is unknown
+	rc = common.EmptyRequestContext()
+	rc.RawRequest = []byte(`{"jsonrpc":"2.0","id":"c","method":"$/cancelRequest","params":{"id":"1"}}`)
+	_ = s.ProcessRawInput(&rc)
+	if !strings.Contains(string(rc.RawResponse), "-32601") {
+		t.Errorf("Cancel request was handled without a canceller: %s", string(rc.RawResponse))
+	}
+}
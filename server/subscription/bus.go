@@ -0,0 +1,88 @@
+package subscription
+
+import (
+	"sort"
+	"sync"
+)
+
+// An in-process event bus
+// Events published to a topic are passed to all the listeners of the topic
+type Bus struct {
+	listeners map[string]map[int]func(event interface{})
+	next      int
+	mu        sync.RWMutex
+}
+
+// Create a new event bus
+func NewBus() *Bus {
+	return &Bus{listeners: map[string]map[int]func(event interface{}){}}
+}
+
+// Listen to a topic until the returned function is called
+// Listeners are called synchronously by Publish so they must not block
+func (b *Bus) Listen(topic string, f func(event interface{})) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.listeners == nil {
+		b.listeners = map[string]map[int]func(event interface{}){}
+	}
+	if b.listeners[topic] == nil {
+		b.listeners[topic] = map[int]func(event interface{}){}
+	}
+
+	b.next++
+	key := b.next
+	b.listeners[topic][key] = f
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.listeners[topic], key)
+			if len(b.listeners[topic]) == 0 {
+				delete(b.listeners, topic)
+			}
+		})
+	}
+}
+
+// Publish an event to a topic, returns the number of listeners it was passed to
+func (b *Bus) Publish(topic string, event interface{}) int {
+	b.mu.RLock()
+	listeners := make([]func(event interface{}), 0, len(b.listeners[topic]))
+	for _, f := range b.listeners[topic] {
+		listeners = append(listeners, f)
+	}
+	b.mu.RUnlock()
+
+	for _, f := range listeners {
+		f(event)
+	}
+
+	return len(listeners)
+}
+
+// Get the topics that have listeners
+func (b *Bus) Topics() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	r := make([]string, 0, len(b.listeners))
+	for topic := range b.listeners {
+		r = append(r, topic)
+	}
+	sort.Strings(r)
+
+	return r
+}
+
+// Get the number of listeners of a topic
+func (b *Bus) Listeners(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.listeners[topic])
+}
@@ -0,0 +1,38 @@
+package subscription
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	var got []interface{}
+	cancelA := bus.Listen("a", func(event interface{}) { got = append(got, event) })
+	cancelB := bus.Listen("b", func(event interface{}) { got = append(got, event) })
+
+	if n := bus.Publish("a", 1); n != 1 {
+		t.Errorf("Event was passed to %d listeners", n)
+	}
+	if n := bus.Publish("c", 2); n != 0 {
+		t.Errorf("Event without listeners was passed to %d listeners", n)
+	}
+	bus.Publish("b", 3)
+
+	if !reflect.DeepEqual(got, []interface{}{1, 3}) {
+		t.Errorf("Got events %v", got)
+	}
+
+	if topics := bus.Topics(); !reflect.DeepEqual(topics, []string{"a", "b"}) {
+		t.Errorf("Got topics %v", topics)
+	}
+
+	cancelA()
+	cancelA()
+	cancelB()
+
+	if len(bus.Topics()) != 0 || bus.Publish("a", 4) != 0 {
+		t.Errorf("Listeners were not removed")
+	}
+}
@@ -0,0 +1,370 @@
+package subscription
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Server-push subscriptions
+// A handler creates a Subscription for the request it serves and returns it as the result, so the client
+// gets the subscription id. Events passed to Notify are queued and sent to the client as notifications
+// once the response is out, until the client unsubscribes or the connection is closed.
+
+// Default method of the event notifications
+const DefaultNotificationMethod = "rpc.subscription"
+
+// The method the clients call to cancel a subscription
+const UnsubscribeMethod = "rpc.unsubscribe"
+
+// Default number of events queued for a subscription
+const DefaultQueueSize = 64
+
+var (
+	ErrNotSupported         = errors.New("subscriptions require a persistent transport")
+	ErrTooManySubscriptions = errors.New("too many subscriptions for the connection")
+	ErrQueueOverflow        = errors.New("subscription queue overflow")
+	ErrUnsubscribed         = errors.New("unsubscribed")
+	ErrConnectionClosed     = errors.New("connection closed")
+)
+
+var NotSupportedError = common.Error{
+	Code:    "-32010",
+	Message: "Subscriptions not supported",
+}
+
+var TooManySubscriptionsError = common.Error{
+	Code:    "-32011",
+	Message: "Too many subscriptions",
+}
+
+// Params of the event notifications
+type Event struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// Params of the unsubscribe method
+type UnsubscribeParams struct {
+	Subscription string `json:"subscription"`
+}
+
+// Keeps track of the subscriptions of all connections
+type Manager struct {
+	// Method of the event notifications, DefaultNotificationMethod if empty
+	NotificationMethod string
+	// Number of events queued for each subscription, DefaultQueueSize if zero
+	QueueSize int
+	// Maximum number of subscriptions per connection, zero means no limit
+	MaxPerConnection int
+	// Drop the events that do not fit into the queue instead of closing the subscription
+	DropOnOverflow bool
+	subscriptions  map[string]*Subscription
+	byNotifier     map[common.Notifier]map[string]*Subscription
+	mu             sync.Mutex
+}
+
+// A single subscription
+type Subscription struct {
+	Id        string
+	Topic     string
+	manager   *Manager
+	notifier  common.Notifier
+	queue     chan json.RawMessage
+	active    chan struct{}
+	done      chan struct{}
+	err       error
+	onClose   []func()
+	mu        sync.Mutex
+	closeOnce sync.Once
+}
+
+// Create a new subscription manager
+func NewManager() *Manager {
+	return &Manager{
+		subscriptions: map[string]*Subscription{},
+		byNotifier:    map[common.Notifier]map[string]*Subscription{},
+	}
+}
+
+// Register the unsubscribe method with the server
+func (m *Manager) Register(s *server.JsonRpcServer) {
+	s.AddHandlerWithNamespace(handler{m}, "Handle_", "rpc.")
+}
+
+// Built-in methods of the manager
+type handler struct {
+	m *Manager
+}
+
+// Cancel a subscription of the calling connection
+func (h handler) Handle_unsubscribe(rc *common.RequestContext, params UnsubscribeParams) (bool, common.Error, error) {
+	n, ok := rc.GetNotifier()
+	if !ok {
+		return false, NotSupportedError, nil
+	}
+
+	return h.m.unsubscribe(n, params.Subscription), common.Error{}, nil
+}
+
+// Get the JSON-RPC error for an error returned by Subscribe
+func ErrorFor(err error) common.Error {
+	switch {
+	case err == nil:
+		return common.Error{}
+	case errors.Is(err, ErrNotSupported):
+		return NotSupportedError
+	case errors.Is(err, ErrTooManySubscriptions):
+		return TooManySubscriptionsError
+	}
+
+	return common.InternalError
+}
+
+// Generate a random subscription id
+func newSubscriptionId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "0x" + hex.EncodeToString(b)
+}
+
+// Create a subscription for the connection the request came through
+// The subscription starts sending events once the response to the request is sent;
+// it is closed right away if the response is an error
+func (m *Manager) Subscribe(rc *common.RequestContext) (*Subscription, error) {
+	n, ok := rc.GetNotifier()
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	size := m.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	s := &Subscription{
+		Id:       newSubscriptionId(),
+		manager:  m,
+		notifier: n,
+		queue:    make(chan json.RawMessage, size),
+		active:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	if m.subscriptions == nil {
+		m.subscriptions = map[string]*Subscription{}
+		m.byNotifier = map[common.Notifier]map[string]*Subscription{}
+	}
+
+	own, watched := m.byNotifier[n]
+	if m.MaxPerConnection > 0 && len(own) >= m.MaxPerConnection {
+		m.mu.Unlock()
+		return nil, ErrTooManySubscriptions
+	}
+	if !watched {
+		own = map[string]*Subscription{}
+		m.byNotifier[n] = own
+	}
+	own[s.Id] = s
+	m.subscriptions[s.Id] = s
+	m.mu.Unlock()
+
+	// Subscriptions die with the connection
+	if !watched {
+		go m.watch(n)
+	}
+
+	rc.OnResponseSent(func() {
+		if rc.JsonRpcRequest.IsNotification() || rc.JsonRpcResponse.Error != nil {
+			s.close(ErrUnsubscribed)
+			return
+		}
+		close(s.active)
+	})
+
+	go s.run()
+
+	return s, nil
+}
+
+// Create a subscription fed by a topic of the event bus
+func (m *Manager) SubscribeTopic(rc *common.RequestContext, bus *Bus, topic string) (*Subscription, error) {
+	s, err := m.Subscribe(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Topic = topic
+	s.OnClose(bus.Listen(topic, func(event interface{}) {
+		_ = s.Notify(event)
+	}))
+
+	return s, nil
+}
+
+// Close all the subscriptions of a connection once it is closed
+func (m *Manager) watch(n common.Notifier) {
+	<-n.Done()
+
+	m.mu.Lock()
+	own := m.byNotifier[n]
+	delete(m.byNotifier, n)
+	m.mu.Unlock()
+
+	for _, s := range own {
+		s.close(ErrConnectionClosed)
+	}
+}
+
+// Cancel a subscription if it belongs to the given connection
+func (m *Manager) unsubscribe(n common.Notifier, id string) bool {
+	m.mu.Lock()
+	s, ok := m.byNotifier[n][id]
+	m.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	s.close(ErrUnsubscribed)
+	return true
+}
+
+// Forget a closed subscription
+func (m *Manager) remove(s *Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.subscriptions, s.Id)
+	delete(m.byNotifier[s.notifier], s.Id)
+}
+
+// Get a live subscription by id
+func (m *Manager) Get(id string) (*Subscription, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscriptions[id]
+	return s, ok
+}
+
+// Get the number of live subscriptions
+func (m *Manager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.subscriptions)
+}
+
+// The subscription is sent to the client as its id
+func (s *Subscription) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Id)
+}
+
+// Queue an event for the client
+// When the queue is full the subscription is closed with ErrQueueOverflow
+// (or the event is dropped if the manager is set up so)
+func (s *Subscription) Notify(event interface{}) error {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+
+	select {
+	case s.queue <- raw:
+		return nil
+	default:
+	}
+
+	if !s.manager.DropOnOverflow {
+		s.close(ErrQueueOverflow)
+	}
+
+	return ErrQueueOverflow
+}
+
+// Get a channel that is closed when the subscription is closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Get the reason the subscription was closed
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Register a function to be called when the subscription is closed
+func (s *Subscription) OnClose(f func()) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.onClose = append(s.onClose, f)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	f()
+}
+
+// Close the subscription from the server side
+func (s *Subscription) Unsubscribe() {
+	s.close(ErrUnsubscribed)
+}
+
+func (s *Subscription) close(reason error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = reason
+		hooks := s.onClose
+		s.onClose = nil
+		s.mu.Unlock()
+
+		close(s.done)
+		s.manager.remove(s)
+
+		for _, f := range hooks {
+			f()
+		}
+	})
+}
+
+// Send the queued events once the subscription is active
+func (s *Subscription) run() {
+	select {
+	case <-s.active:
+	case <-s.done:
+		return
+	}
+
+	method := s.manager.NotificationMethod
+	if method == "" {
+		method = DefaultNotificationMethod
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case raw := <-s.queue:
+			if err := s.notifier.Notify(method, Event{Subscription: s.Id, Result: raw}); err != nil {
+				s.close(err)
+				return
+			}
+		}
+	}
+}
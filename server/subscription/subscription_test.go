@@ -0,0 +1,263 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/client"
+	clientTransport "github.com/yekhlakov/gojsonrpc/client/transport"
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/server/transport"
+)
+
+type test_Notifier struct {
+	notifications []common.Request
+	done          chan struct{}
+	mu            sync.Mutex
+}
+
+func (n *test_Notifier) Notify(method string, params interface{}) error {
+	r, err := common.MakeNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.notifications = append(n.notifications, r)
+	return nil
+}
+
+func (n *test_Notifier) Done() <-chan struct{} {
+	return n.done
+}
+
+func (n *test_Notifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(n.notifications)
+}
+
+func test_Context(n common.Notifier) *common.RequestContext {
+	rc := common.EmptyRequestContext()
+	rc.JsonRpcRequest = common.Request{JsonRPC: "2.0", Id: "1", Method: "subscribe"}
+	if n != nil {
+		rc.Data[common.NotifierKey] = n
+	}
+
+	return &rc
+}
+
+func test_WaitFor(f func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestManager_Subscribe(t *testing.T) {
+	m := NewManager()
+
+	if _, err := m.Subscribe(test_Context(nil)); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Subscribing without a notifier did not fail")
+	}
+
+	n := &test_Notifier{done: make(chan struct{})}
+	rc := test_Context(n)
+
+	s, err := m.Subscribe(rc)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %s", err.Error())
+	}
+
+	if raw, _ := json.Marshal(s); string(raw) != `"`+s.Id+`"` {
+		t.Errorf("Subscription marshaled to %s", string(raw))
+	}
+
+	// Events are held until the response is sent
+	_ = s.Notify(1)
+	time.Sleep(20 * time.Millisecond)
+	if n.count() != 0 {
+		t.Errorf("Event was sent before the response")
+	}
+
+	rc.ResponseSent()
+	_ = s.Notify(2)
+
+	if !test_WaitFor(func() bool { return n.count() == 2 }) {
+		t.Fatalf("Events were not sent")
+	}
+
+	event := Event{}
+	_ = json.Unmarshal(n.notifications[1].Params, &event)
+	if n.notifications[1].Method != DefaultNotificationMethod || event.Subscription != s.Id || string(event.Result) != "2" {
+		t.Errorf("Bad event notification %v", n.notifications[1])
+	}
+
+	// Closing the connection ends the subscription
+	close(n.done)
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Subscription was not closed with the connection")
+	}
+
+	if !errors.Is(s.Err(), ErrConnectionClosed) || m.Count() != 0 {
+		t.Errorf("Subscription was not cleaned up")
+	}
+}
+
+func TestManager_Subscribe_ErrorResponse(t *testing.T) {
+	m := NewManager()
+	n := &test_Notifier{done: make(chan struct{})}
+	rc := test_Context(n)
+
+	s, _ := m.Subscribe(rc)
+	rc.MakeErrorResponse(common.InternalError)
+	rc.ResponseSent()
+
+	if !errors.Is(s.Err(), ErrUnsubscribed) || m.Count() != 0 {
+		t.Errorf("Subscription survived an error response")
+	}
+}
+
+func TestManager_Limits(t *testing.T) {
+	m := NewManager()
+	m.MaxPerConnection = 1
+	m.QueueSize = 1
+
+	n := &test_Notifier{done: make(chan struct{})}
+
+	s, _ := m.Subscribe(test_Context(n))
+	if _, err := m.Subscribe(test_Context(n)); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("Subscription limit was not applied")
+	}
+
+	if _, err := m.Subscribe(test_Context(&test_Notifier{done: make(chan struct{})})); err != nil {
+		t.Errorf("Subscription limit applied to another connection")
+	}
+
+	// Not active yet, so the queue fills up
+	if err := s.Notify(1); err != nil {
+		t.Errorf("First event was not queued")
+	}
+	if err := s.Notify(2); !errors.Is(err, ErrQueueOverflow) || !errors.Is(s.Err(), ErrQueueOverflow) {
+		t.Errorf("Overflow did not close the subscription")
+	}
+
+	m.DropOnOverflow = true
+	s, _ = m.Subscribe(test_Context(n))
+	_ = s.Notify(1)
+	if err := s.Notify(2); !errors.Is(err, ErrQueueOverflow) || s.Err() != nil {
+		t.Errorf("Overflow closed the subscription despite DropOnOverflow")
+	}
+}
+
+func TestErrorFor(t *testing.T) {
+	testData := []struct {
+		Err  error
+		Code json.Number
+	}{
+		{nil, ""},
+		{ErrNotSupported, NotSupportedError.Code},
+		{ErrTooManySubscriptions, TooManySubscriptionsError.Code},
+		{errors.New("something"), common.InternalError.Code},
+	}
+
+	for k, data := range testData {
+		if e := ErrorFor(data.Err); e.Code != data.Code {
+			t.Errorf("%d got code %s", k, e.Code)
+		}
+	}
+}
+
+type test_TickHandler struct {
+	m   *Manager
+	bus *Bus
+}
+
+func (h test_TickHandler) Handle_subscribe(rc *common.RequestContext, params struct {
+	Topic string `json:"topic"`
+}) (*Subscription, common.Error, error) {
+	s, err := h.m.SubscribeTopic(rc, h.bus, params.Topic)
+	return s, ErrorFor(err), nil
+}
+
+func TestSubscription_EndToEnd(t *testing.T) {
+	m := NewManager()
+	bus := NewBus()
+
+	s := server.NewServer()
+	s.AddHandler(test_TickHandler{m, bus}, "Handle_")
+	m.Register(s)
+
+	st, err := transport.NewTcpTransport("127.0.0.1:0", s, framing.Newline{})
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	st.Start()
+	defer func() { _ = st.Shutdown(context.Background()) }()
+
+	ct := clientTransport.NewTcp(st.Addr().String(), framing.Newline{})
+	defer func() { _ = ct.Close() }()
+
+	c := client.New()
+	_ = c.SetTransport(ct)
+
+	ticks := make(chan int, 10)
+	sub, err := c.Subscribe(context.Background(), "subscribe", map[string]string{"topic": "ticks"}, ticks)
+	if err != nil {
+		t.Fatalf("Could not subscribe: %s", err.Error())
+	}
+
+	if bus.Listeners("ticks") != 1 {
+		t.Fatalf("Subscription is not listening to the topic")
+	}
+
+	for i := 1; i <= 3; i++ {
+		bus.Publish("ticks", i)
+		bus.Publish("other", -i)
+	}
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case tick := <-ticks:
+			if tick != i {
+				t.Errorf("Got tick %d instead of %d", tick, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Tick %d was not delivered", i)
+		}
+	}
+
+	if err = sub.Unsubscribe(context.Background()); err != nil {
+		t.Errorf("Could not unsubscribe: %s", err.Error())
+	}
+
+	if !test_WaitFor(func() bool { return m.Count() == 0 && bus.Listeners("ticks") == 0 }) {
+		t.Errorf("Subscription was not cleaned up after unsubscribe")
+	}
+
+	// Subscriptions of a closed connection are cleaned up too
+	if _, err = c.Subscribe(context.Background(), "subscribe", map[string]string{"topic": "ticks"}, ticks); err != nil {
+		t.Fatalf("Could not subscribe again: %s", err.Error())
+	}
+	_ = ct.Close()
+
+	if !test_WaitFor(func() bool { return m.Count() == 0 && bus.Listeners("ticks") == 0 }) {
+		t.Errorf("Subscription was not cleaned up after disconnect")
+	}
+}
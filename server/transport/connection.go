@@ -0,0 +1,392 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// The key of Request Context Data holding the *Connection the request came through
+const ConnectionKey = "transport.connection"
+
+// A persistent connection serving a JSON-RPC server
+// Incoming requests are processed concurrently and responses are written as soon as they are ready,
+// so the peer has to match them by id
+type Connection struct {
+	Id         string
+	Transport  string
+	RemoteAddr string
+	// The handshake request for connections that started as HTTP (nil otherwise)
+	HttpRequest *http.Request
+	// Request Context Data set by the stages admitting the handshake (e.g. the principal), copied into every request
+	HandshakeData map[string]interface{}
+	// Maximum number of requests processed at once, zero means no limit
+	MaxInFlight int
+	// The connection is closed after this long without incoming messages and requests in flight,
+	// zero means no timeout
+	IdleTimeout  time.Duration
+	inFlight     int64
+	lastActivity int64
+	handlers     sync.WaitGroup
+	conn         common.MessageConn
+	server       *server.JsonRpcServer
+	logger       *log.Logger
+	state        map[string]interface{}
+	onClose      []func(c *Connection)
+	canceller    *common.Canceller
+	mu           sync.Mutex
+	ctx          context.Context
+	cancel       context.CancelFunc
+	closeOnce    sync.Once
+}
+
+// A set of live connections of a transport
+type connectionSet struct {
+	connections map[string]*Connection
+	mu          sync.Mutex
+}
+
+// Create a new Connection serving the given server over the given message connection
+func NewConnection(s *server.JsonRpcServer, conn common.MessageConn, transportName string) *Connection {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	logger := s.Logger
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	return &Connection{
+		Id:        newConnectionId(),
+		Transport: transportName,
+		conn:      conn,
+		server:    s,
+		logger:    logger,
+		state:     map[string]interface{}{},
+		canceller: common.NewCanceller(),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Generate a random connection id
+func newConnectionId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Get the connection the request came through
+func GetConnection(rc *common.RequestContext) (*Connection, bool) {
+	c, ok := rc.Data[ConnectionKey].(*Connection)
+	return c, ok
+}
+
+// Read and process incoming messages until the connection is closed
+// Returns once all requests in flight are done. When the input ends (io.EOF) the responses to them
+// are still written before the connection is closed.
+func (c *Connection) Serve() error {
+	defer func() {
+		_ = c.Close()
+		c.handlers.Wait()
+	}()
+
+	var slots chan struct{}
+	if c.MaxInFlight > 0 {
+		slots = make(chan struct{}, c.MaxInFlight)
+	}
+
+	c.touch()
+	if c.IdleTimeout > 0 {
+		go c.watchIdle()
+	}
+
+	for {
+		message, err := c.conn.ReadMessage()
+		if err != nil {
+			// Only the input of the peer may be closed, the output is still there for the responses
+			if err == io.EOF {
+				c.handlers.Wait()
+			}
+			return err
+		}
+
+		c.touch()
+
+		// Cancellation must get through even when the connection is busy, it is cheap so it is handled
+		// right in the read loop (a goroutine for each one would let a peer start them without limit)
+		if slots != nil && isCancelRequest(message) {
+			c.handle(message)
+			continue
+		}
+
+		if slots != nil {
+			slots <- struct{}{}
+		}
+
+		atomic.AddInt64(&c.inFlight, 1)
+		c.handlers.Add(1)
+		go func() {
+			defer c.handlers.Done()
+			c.handle(message)
+			atomic.AddInt64(&c.inFlight, -1)
+			c.touch()
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+}
+
+// Check if the message is a cancel notification
+func isCancelRequest(message []byte) bool {
+	if !bytes.Contains(message, []byte(common.CancelRequestMethod)) {
+		return false
+	}
+
+	probe := struct {
+		Method string `json:"method"`
+	}{}
+	return json.Unmarshal(message, &probe) == nil && probe.Method == common.CancelRequestMethod
+}
+
+// Remember the time of the last activity
+func (c *Connection) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+// Close the connection once it stays idle for too long
+func (c *Connection) watchIdle() {
+	ticker := time.NewTicker(c.IdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+			if atomic.LoadInt64(&c.inFlight) == 0 && idle >= c.IdleTimeout {
+				c.logger.Println(c.Transport, "closing idle connection", c.Id)
+				_ = c.Close()
+				return
+			}
+		}
+	}
+}
+
+// Get the number of requests being processed right now
+func (c *Connection) InFlight() int {
+	return int(atomic.LoadInt64(&c.inFlight))
+}
+
+// Wait until no requests are being processed or the context is done
+func (c *Connection) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for c.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Process a single incoming message and write the response (if any)
+func (c *Connection) handle(message []byte) {
+	rc := c.NewRequestContext()
+	rc.RawRequest = message
+
+	_ = c.server.ProcessRawInput(&rc)
+
+	if len(rc.RawResponse) > 0 && rc.ShouldRespond() {
+		if err := c.conn.WriteMessage(rc.RawResponse); err != nil {
+			c.logger.Println(c.Transport, "response write error", err.Error())
+		}
+	}
+
+	rc.ResponseSent()
+}
+
+// Create a Request Context for a request coming through the connection
+func (c *Connection) NewRequestContext() common.RequestContext {
+	rc := common.EmptyRequestContext()
+	for k, v := range c.HandshakeData {
+		rc.Data[k] = v
+	}
+	// The hooks belong to the handshake rather than to the requests
+	delete(rc.Data, common.ResponseSentHooksKey)
+
+	rc.Logger = c.logger
+	rc.Ctx = c.ctx
+	rc.Data[ConnectionKey] = c
+	rc.Data[common.NotifierKey] = c
+	rc.Data[common.CancellerKey] = c.canceller
+
+	if c.HttpRequest != nil {
+		rc.Data[common.MetadataKey] = common.NewHttpMetadata(c.Transport, c.HttpRequest, nil)
+	} else {
+		rc.Data[common.MetadataKey] = common.NewMetadata(c.Transport, c.RemoteAddr)
+	}
+
+	return rc
+}
+
+// Send a notification to the peer
+func (c *Connection) Notify(method string, params interface{}) error {
+	n, err := common.MakeNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return c.Send(raw)
+}
+
+// Send a raw message to the peer
+func (c *Connection) Send(message []byte) error {
+	select {
+	case <-c.ctx.Done():
+		return fmt.Errorf("connection closed")
+	default:
+	}
+
+	return c.conn.WriteMessage(message)
+}
+
+// Get a value from the connection state
+func (c *Connection) Get(key string) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok = c.state[key]
+	return
+}
+
+// Put a value into the connection state
+func (c *Connection) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state[key] = value
+}
+
+// Get a channel that is closed when the connection is closed
+func (c *Connection) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Get the context that is cancelled when the connection is closed
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// Register a function to be called when the connection is closed
+func (c *Connection) OnClose(f func(c *Connection)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onClose = append(c.onClose, f)
+}
+
+// Close the connection, in-flight requests get their contexts cancelled
+func (c *Connection) Close() (err error) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		err = c.conn.Close()
+
+		c.mu.Lock()
+		hooks := c.onClose
+		c.mu.Unlock()
+
+		for _, f := range hooks {
+			f(c)
+		}
+	})
+
+	return
+}
+
+// Add a connection to the set and remove it once it is closed
+func (cs *connectionSet) add(c *Connection) {
+	cs.mu.Lock()
+	if cs.connections == nil {
+		cs.connections = map[string]*Connection{}
+	}
+	cs.connections[c.Id] = c
+	cs.mu.Unlock()
+
+	c.OnClose(func(c *Connection) {
+		cs.mu.Lock()
+		delete(cs.connections, c.Id)
+		cs.mu.Unlock()
+	})
+}
+
+// Get all live connections
+func (cs *connectionSet) Connections() []*Connection {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	r := make([]*Connection, 0, len(cs.connections))
+	for _, c := range cs.connections {
+		r = append(r, c)
+	}
+
+	return r
+}
+
+// Get a live connection by id
+func (cs *connectionSet) GetConnection(id string) *Connection {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.connections[id]
+}
+
+// Send a notification to all live connections
+// Write errors are ignored as the connections are going to be closed anyway
+func (cs *connectionSet) Broadcast(method string, params interface{}) error {
+	n, err := common.MakeNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cs.Connections() {
+		_ = c.Send(raw)
+	}
+
+	return nil
+}
+
+// Close all live connections
+func (cs *connectionSet) closeAll() {
+	for _, c := range cs.Connections() {
+		_ = c.Close()
+	}
+}
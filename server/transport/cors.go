@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS settings of an endpoint
+type CORS struct {
+	// Allowed origins: exact ones ("https://example.com"), patterns ("https://*.example.com") or "*" for any
+	AllowedOrigins []string
+	// Methods allowed for cross-origin calls, GET and POST if empty
+	AllowedMethods []string
+	// Request headers allowed for cross-origin calls (e.g. Authorization), Content-Type if empty, "*" for any
+	AllowedHeaders []string
+	// Response headers exposed to the browser
+	ExposedHeaders []string
+	// Allow requests with credentials (cookies, HTTP auth), not allowed together with "*" origins
+	AllowCredentials bool
+	// How long the browser may cache the preflight response, not sent if zero
+	MaxAge time.Duration
+}
+
+// Set the CORS settings of the endpoint (and its REST routes), nil turns CORS off
+func (t *HttpTransport) SetCORS(url string, cors *CORS) error {
+	if err := cors.validate(); err != nil {
+		return err
+	}
+
+	return t.updateEndpoint(url, func(c *endpointConfig) {
+		c.cors = cors
+	})
+}
+
+// Check the settings, any origin must not get the credentials of the user
+func (c *CORS) validate() error {
+	if c != nil && c.AllowCredentials && c.allowsAnyOrigin() {
+		return fmt.Errorf("credentials can not be allowed for any origin")
+	}
+
+	return nil
+}
+
+// Check if the origin is allowed
+func (c *CORS) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		if strings.Contains(allowed, "*") {
+			if ok, _ := path.Match(allowed, origin); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *CORS) allowsAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return []string{http.MethodGet, http.MethodPost}
+	}
+
+	return c.AllowedMethods
+}
+
+func (c *CORS) headers() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return []string{"Content-Type"}
+	}
+
+	return c.AllowedHeaders
+}
+
+// Check if the method is allowed
+func (c *CORS) allowsMethod(method string) bool {
+	for _, allowed := range c.methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if all the headers of the comma-separated list are allowed
+func (c *CORS) allowsHeaders(list string) bool {
+	for _, header := range strings.Split(list, ",") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+
+		allowed := false
+		for _, h := range c.headers() {
+			if h == "*" || strings.EqualFold(h, header) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Set the CORS headers of the response, answer the preflight requests
+func (c *CORS) handle(w http.ResponseWriter, r *http.Request) bool {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	if !c.allowsOrigin(origin) {
+		// The browser blocks the response as it has no CORS headers
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	// Any origin gets a literal "*", so the browser never sends the credentials (even if the settings were changed
+	// after being validated)
+	if c.allowsAnyOrigin() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+
+		if c.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		if len(c.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if !c.allowsMethod(r.Header.Get("Access-Control-Request-Method")) || !c.allowsHeaders(requestHeaders) {
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+	if requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	if c.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+func TestHttpTransport_SetCORS(t *testing.T) {
+	transport := test_HttpTransport()
+	public := server.NewServer()
+	public.AddHandler(test_GetHandler{}, "Handle_")
+	_, _ = transport.AddEndpoint("/public", public)
+	_, _ = transport.AddEndpoint("/private", server.NewServer())
+	_, _ = transport.EnableRest("/public")
+
+	if transport.SetCORS("/nope", &CORS{}) == nil {
+		t.Errorf("CORS was set for an unknown endpoint")
+	}
+
+	_ = transport.SetCORS("/public", &CORS{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.partner.com"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	testData := []struct {
+		Method         string
+		Path           string
+		Origin         string
+		RequestMethod  string
+		RequestHeaders string
+		Status         int
+		AllowOrigin    string
+		AllowHeaders   string
+		MaxAge         string
+		Expose         string
+	}{
+		// Preflights
+		{http.MethodOptions, "/public", "https://app.example.com", "POST", "content-type, authorization", http.StatusNoContent, "https://app.example.com", "content-type, authorization", "600", ""},
+		{http.MethodOptions, "/public", "https://x.partner.com", "POST", "", http.StatusNoContent, "https://x.partner.com", "", "600", ""},
+		{http.MethodOptions, "/public/echo", "https://app.example.com", "POST", "", http.StatusNoContent, "https://app.example.com", "", "600", ""},
+		{http.MethodOptions, "/public", "https://evil.com", "POST", "", http.StatusForbidden, "", "", "", ""},
+		{http.MethodOptions, "/public", "https://app.example.com", "DELETE", "", http.StatusForbidden, "", "", "", ""},
+		{http.MethodOptions, "/public", "https://app.example.com", "POST", "X-Custom", http.StatusForbidden, "", "", "", ""},
+		{http.MethodOptions, "/private", "https://app.example.com", "POST", "", http.StatusMethodNotAllowed, "", "", "", ""},
+		// Actual calls
+		{http.MethodPost, "/public", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com", "", "", "X-Request-Id"},
+		{http.MethodPost, "/public", "https://evil.com", "", "", http.StatusOK, "", "", "", ""},
+		{http.MethodPost, "/public", "", "", "", http.StatusOK, "", "", "", ""},
+		{http.MethodPost, "/private", "https://app.example.com", "", "", http.StatusOK, "", "", "", ""},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(data.Method, httpServer.URL+data.Path, bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"kek"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if data.Origin != "" {
+			r.Header.Set("Origin", data.Origin)
+		}
+		if data.RequestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", data.RequestMethod)
+		}
+		if data.RequestHeaders != "" {
+			r.Header.Set("Access-Control-Request-Headers", data.RequestHeaders)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+
+		header := response.Header
+		if header.Get("Access-Control-Allow-Origin") != data.AllowOrigin {
+			t.Errorf("%d got allowed origin %q", k, header.Get("Access-Control-Allow-Origin"))
+		}
+		if header.Get("Access-Control-Allow-Headers") != data.AllowHeaders {
+			t.Errorf("%d got allowed headers %q", k, header.Get("Access-Control-Allow-Headers"))
+		}
+		if header.Get("Access-Control-Max-Age") != data.MaxAge {
+			t.Errorf("%d got max age %q", k, header.Get("Access-Control-Max-Age"))
+		}
+		if header.Get("Access-Control-Expose-Headers") != data.Expose {
+			t.Errorf("%d got exposed headers %q", k, header.Get("Access-Control-Expose-Headers"))
+		}
+		if data.AllowOrigin != "" && header.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%d credentials were not allowed", k)
+		}
+	}
+
+	// A wildcard without credentials is sent as is
+	_ = transport.SetCORS("/private", &CORS{AllowedOrigins: []string{"*"}})
+	r, _ := http.NewRequest(http.MethodOptions, httpServer.URL+"/private", nil)
+	r.Header.Set("Origin", "https://any.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+	_ = response.Body.Close()
+	if response.Header.Get("Access-Control-Allow-Origin") != "*" || response.Header.Get("Access-Control-Allow-Methods") != "GET, POST" {
+		t.Errorf("Got headers %v", response.Header)
+	}
+
+	// A wildcard with credentials would let any site make calls on behalf of the user
+	anyWithCredentials := &CORS{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}
+	if transport.SetCORS("/private", anyWithCredentials) == nil {
+		t.Errorf("Credentials were allowed for any origin")
+	}
+	if _, err = transport.AddEndpoint("/other", server.NewServer(), WithCORS(anyWithCredentials)); err == nil {
+		t.Errorf("Endpoint allowing credentials for any origin was added")
+	}
+
+	// Settings changed after being set still never send the credentials to any origin
+	cors := &CORS{AllowedOrigins: []string{"*"}}
+	_ = transport.SetCORS("/private", cors)
+	cors.AllowCredentials = true
+
+	r, _ = http.NewRequest(http.MethodPost, httpServer.URL+"/private", bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"kek"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Origin", "https://evil.com")
+	if response, err = http.DefaultClient.Do(r); err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+	_ = response.Body.Close()
+	if response.Header.Get("Access-Control-Allow-Origin") != "*" || response.Header.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Got headers %v", response.Header)
+	}
+}
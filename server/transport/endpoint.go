@@ -0,0 +1,217 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// An endpoint of the HTTP transport
+// ServeMux can not unregister handlers, so the handlers of a url stay registered and serve the current
+// settings of the endpoint (or 404 Not Found once it is removed)
+type endpoint struct {
+	config *endpointConfig
+	// REST routes registered so far
+	routes map[string]bool
+	mu     sync.RWMutex
+}
+
+// Settings of an endpoint, they are replaced as a whole
+type endpointConfig struct {
+	server     *server.JsonRpcServer
+	handler    func(c *endpointConfig, w http.ResponseWriter, r *http.Request)
+	preStages  []HttpStage
+	postStages []HttpStage
+	cors       *CORS
+	rest       bool
+	// A stream endpoint (SSE or WebSocket) has no single response, so post server stages do not apply to it
+	stream bool
+	close  func()
+}
+
+// An option of an endpoint
+type EndpointOption func(c *endpointConfig)
+
+// Apply the stages (after the transport ones) before the JSON-RPC processing of the endpoint calls
+func WithPreServerStages(stages ...HttpStage) EndpointOption {
+	return func(c *endpointConfig) {
+		c.preStages = append(c.preStages, stages...)
+	}
+}
+
+// Apply the stages (before the transport ones) after the JSON-RPC processing of the endpoint calls
+func WithPostServerStages(stages ...HttpStage) EndpointOption {
+	return func(c *endpointConfig) {
+		c.postStages = append(c.postStages, stages...)
+	}
+}
+
+// Set the CORS settings of the endpoint
+func WithCORS(cors *CORS) EndpointOption {
+	return func(c *endpointConfig) {
+		c.cors = cors
+	}
+}
+
+func (e *endpoint) get() *endpointConfig {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.config
+}
+
+func (e *endpoint) set(c *endpointConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.config = c
+}
+
+// Serve the request with the current settings of the endpoint
+func (e *endpoint) serve(w http.ResponseWriter, r *http.Request, f func(c *endpointConfig)) {
+	c := e.get()
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if c.cors != nil && c.cors.handle(w, r) {
+		return
+	}
+
+	f(c)
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.serve(w, r, func(c *endpointConfig) {
+		c.handler(c, w, r)
+	})
+}
+
+// Add an endpoint with the settings, registering its url with the Mux unless it has been registered before
+func (t *HttpTransport) addEndpoint(url string, c *endpointConfig, opts []EndpointOption) error {
+	if c.server == nil && !c.stream {
+		return fmt.Errorf("nil server not allowed")
+	}
+
+	// Check if this url is already registered
+	if _, live := t.endpointConfig(url); live != nil {
+		return fmt.Errorf("the url is already registered")
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.stream && len(c.postStages) > 0 {
+		return fmt.Errorf("post server stages do not apply to stream endpoints")
+	}
+
+	if err := c.cors.validate(); err != nil {
+		return err
+	}
+
+	if t.routes == nil {
+		t.routes = map[string]*endpoint{}
+	}
+
+	e, ok := t.routes[url]
+	if !ok {
+		e = &endpoint{routes: map[string]bool{}}
+		t.routes[url] = e
+		t.Mux.Handle(url, e)
+	}
+
+	if c.server != nil {
+		t.Endpoints[url] = c.server
+	}
+	e.set(c)
+
+	return nil
+}
+
+// Get the settings of a live endpoint
+func (t *HttpTransport) endpointConfig(url string) (*endpoint, *endpointConfig) {
+	e, ok := t.routes[url]
+	if !ok {
+		return nil, nil
+	}
+
+	c := e.get()
+	if c == nil {
+		return nil, nil
+	}
+
+	return e, c
+}
+
+// The key of the context of an admitted stream request holding the Request Context Data set by the stages
+type handshakeDataKey struct{}
+
+// Apply the pre-server stages of the transport and the endpoint to a request opening a stream (SSE or WebSocket)
+// An admitted request is returned with the Data set by the stages (e.g. the principal) in its context, see handshakeData.
+// If a stage rejects it, the response made by the stage is written out (403 Forbidden if it made none)
+func (t *HttpTransport) admitStream(c *endpointConfig, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	hrc := newHttpRequestContext(w, r)
+	if hrc.applyPipeline(&t.PreServerStages) && hrc.applyPipeline(&c.preStages) {
+		return r.WithContext(context.WithValue(r.Context(), handshakeDataKey{}, hrc.Data)), true
+	}
+
+	status := t.responseStatus(&hrc)
+	if status == http.StatusOK {
+		status = http.StatusForbidden
+	}
+
+	if len(hrc.RawResponse) == 0 {
+		w.WriteHeader(status)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(hrc.RawResponse)
+	}
+
+	hrc.ResponseSent()
+
+	return r, false
+}
+
+// Get the Request Context Data set by the stages admitting a stream request, nil if it was not admitted by them
+func handshakeData(r *http.Request) map[string]interface{} {
+	data, _ := r.Context().Value(handshakeDataKey{}).(map[string]interface{})
+	return data
+}
+
+// Change the settings of a live endpoint
+func (t *HttpTransport) updateEndpoint(url string, f func(c *endpointConfig)) error {
+	e, c := t.endpointConfig(url)
+	if c == nil {
+		return fmt.Errorf("the url is not registered")
+	}
+
+	updated := *c
+	f(&updated)
+	e.set(&updated)
+
+	return nil
+}
+
+// Remove the endpoint (with its REST routes), the url may be added again later
+// Connections of a WebSocket endpoint are closed
+func (t *HttpTransport) RemoveEndpoint(url string) error {
+	e, c := t.endpointConfig(url)
+	if c == nil {
+		return fmt.Errorf("the url is not registered")
+	}
+
+	e.set(nil)
+	delete(t.Endpoints, url)
+
+	if c.close != nil {
+		c.close()
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Reject the calls without the token
+func test_TokenStage(context *HttpRequestContext) bool {
+	if context.HttpRequest.Header.Get("X-Token") != "secret" {
+		context.MakeErrorResponse(common.UnauthorizedError)
+		_ = context.RebuildRawResponse()
+		return false
+	}
+
+	return true
+}
+
+func test_Post(t *testing.T, url string, host string, token string) (int, string) {
+	r, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"echo","params":{"name":"lol"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	if host != "" {
+		r.Host = host
+	}
+	if token != "" {
+		r.Header.Set("X-Token", token)
+	}
+
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+
+	return response.StatusCode, string(body)
+}
+
+func TestHttpTransport_EndpointOptions(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_GetHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	transport.AddPostServerStage(func(context *HttpRequestContext) bool {
+		context.HttpResponse.Header().Set("X-Transport", "1")
+		return true
+	})
+
+	_, _ = transport.AddEndpoint("/public", s)
+	_, _ = transport.AddEndpoint("/internal", s,
+		WithPreServerStages(test_TokenStage),
+		WithPostServerStages(func(context *HttpRequestContext) bool {
+			context.HttpResponse.Header().Set("X-Endpoint", "internal")
+			return true
+		}),
+		WithCORS(&CORS{AllowedOrigins: []string{"*"}}),
+	)
+	_, _ = transport.AddEndpoint("internal.example.com/public", server.NewServer())
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	success := `{"jsonrpc":"2.0","id":"1","result":"lol"}`
+	unauthorized := `{"jsonrpc":"2.0","error":{"code":-32001,"message":"Unauthorized"}}`
+	notFound := `{"jsonrpc":"2.0","id":"1","error":{"code":-32601,"message":"Method not found"}}`
+
+	testData := []struct {
+		Path  string
+		Host  string
+		Token string
+		Body  string
+	}{
+		{"/public", "", "", success},
+		{"/internal", "", "", unauthorized},
+		{"/internal", "", "secret", success},
+		// The endpoint with the host wins over the one without it
+		{"/public", "internal.example.com", "", notFound},
+		{"/public", "internal.example.com:8080", "", notFound},
+		{"/public", "api.example.com", "", success},
+	}
+
+	for k, data := range testData {
+		if _, body := test_Post(t, httpServer.URL+data.Path, data.Host, data.Token); body != data.Body {
+			t.Errorf("%d got body %s", k, body)
+		}
+	}
+
+	r, _ := http.NewRequest(http.MethodOptions, httpServer.URL+"/internal", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	if response, err := http.DefaultClient.Do(r); err != nil || response.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("CORS option was not applied")
+	}
+}
+
+func TestHttpTransport_RemoveEndpoint(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_GetHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+	_, _ = transport.EnableRest("/rpc")
+
+	_, _ = transport.AddWebSocketEndpoint("/ws", s)
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	if status, _ := test_Post(t, httpServer.URL+"/rpc/echo", "", ""); status != http.StatusOK {
+		t.Errorf("REST route got status %d", status)
+	}
+
+	if transport.RemoveEndpoint("/nope") == nil {
+		t.Errorf("Unknown endpoint was removed")
+	}
+
+	if err := transport.RemoveEndpoint("/rpc"); err != nil {
+		t.Fatalf("could not remove the endpoint: %s", err.Error())
+	}
+
+	if transport.GetEndpoint("/rpc") != nil {
+		t.Errorf("Endpoint is still registered")
+	}
+
+	for k, path := range []string{"/rpc", "/rpc/echo"} {
+		if status, _ := test_Post(t, httpServer.URL+path, "", ""); status != http.StatusNotFound {
+			t.Errorf("%d got status %d after removal", k, status)
+		}
+	}
+
+	if transport.RemoveEndpoint("/rpc") == nil {
+		t.Errorf("Endpoint was removed twice")
+	}
+
+	// The url may be used again, with the new settings
+	_, _ = transport.AddEndpoint("/rpc", s, WithPreServerStages(test_TokenStage))
+	if _, body := test_Post(t, httpServer.URL+"/rpc", "", ""); body != `{"jsonrpc":"2.0","error":{"code":-32001,"message":"Unauthorized"}}` {
+		t.Errorf("Endpoint was not added again: %s", body)
+	}
+	if status, _ := test_Post(t, httpServer.URL+"/rpc/echo", "", ""); status != http.StatusNotFound {
+		t.Errorf("REST routes were not disabled for the new endpoint")
+	}
+	if _, err := transport.EnableRest("/rpc"); err != nil {
+		t.Errorf("REST routes were not enabled again: %s", err.Error())
+	}
+
+	if err := transport.RemoveEndpoint("/ws"); err != nil {
+		t.Errorf("could not remove the websocket endpoint: %s", err.Error())
+	}
+	if response, err := http.Get(httpServer.URL + "/ws"); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("WebSocket endpoint was not removed")
+	}
+}
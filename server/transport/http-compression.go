@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"net/http"
+
+	"github.com/yekhlakov/gojsonrpc/compression"
+)
+
+// Read the request body decompressing it according to Content-Encoding
+// If the body is not acceptable, an error status is written out and false is returned
+func (t *HttpTransport) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	encoding := r.Header.Get("Content-Encoding")
+	if !compression.Supported(encoding) {
+		w.Header().Set("Accept-Encoding", compression.AcceptEncoding)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	body, err := compression.Decompress(r.Body, encoding, t.MaxRequestSize)
+	switch err {
+	case nil:
+		return body, true
+	case compression.ErrTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	return nil, false
+}
+
+// Get the coding for the response body, empty if it should not be compressed
+func (t *HttpTransport) responseEncoding(r *http.Request, body []byte) string {
+	minSize := t.CompressMinSize
+	if minSize < 0 {
+		return ""
+	}
+	if minSize == 0 {
+		minSize = compression.DefaultMinSize
+	}
+
+	if len(body) < minSize {
+		return ""
+	}
+
+	return compression.Negotiate(r.Header.Get("Accept-Encoding"))
+}
+
+// Write the body out with the status, compressing it if the client accepts that
+func (t *HttpTransport) writeBody(w http.ResponseWriter, r *http.Request, status int, body []byte) error {
+	if t.CompressMinSize >= 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	if encoding := t.responseEncoding(r, body); encoding != "" {
+		if compressed, err := compression.Compress(body, encoding); err == nil {
+			w.Header().Set("Content-Encoding", encoding)
+			body = compressed
+		}
+	}
+
+	w.WriteHeader(status)
+
+	_, err := w.Write(body)
+	return err
+}
@@ -1,10 +1,16 @@
 package transport
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/yekhlakov/gojsonrpc/common"
 	"github.com/yekhlakov/gojsonrpc/server"
@@ -16,30 +22,58 @@ type HttpRequestContext struct {
 	HttpRequest *http.Request
 	common.RequestContext
 	HttpResponse http.ResponseWriter
+	// The client authenticated with a verified certificate (mutual TLS), nil otherwise
+	ClientIdentity *ClientIdentity
+	// HTTP status of the response set by a stage, the one derived from the response if zero
+	HttpStatus int
 }
 
 // A Stage for processing an Http Request before or after the Json-Rpc processing
 type HttpStage func(context *HttpRequestContext) bool
 
+// Cache-Control header of successful responses to GET requests by default
+const DefaultCacheControl = "public, max-age=60"
+
+// Media types accepted for JSON-RPC calls
+var JsonContentTypes = []string{"application/json", "application/json-rpc", "application/jsonrequest"}
+
 // This is the actual HTTP transport
 type HttpTransport struct {
 	Mux              *http.ServeMux
 	PreServerStages  []HttpStage
 	Endpoints        map[string]*server.JsonRpcServer
 	PostServerStages []HttpStage
-	logger           *log.Logger
+	// Responses smaller than this are not compressed, compression.DefaultMinSize if zero
+	// A negative value disables the compression of responses
+	CompressMinSize int
+	// Maximum size of a (decompressed) request body, compression.DefaultMaxSize if zero
+	MaxRequestSize int64
+	// Cache-Control header of successful responses to GET requests, DefaultCacheControl if empty
+	CacheControl string
+	// HTTP statuses of responses carrying the JSON-RPC errors with the given codes, see StandardErrorStatuses
+	// The rest get 200 OK, except for the auth errors (401 Unauthorized and 403 Forbidden)
+	ErrorStatuses map[json.Number]int
+	logger        *log.Logger
+	sse           *sseEndpoints
+	routes        map[string]*endpoint
 }
 
-// Create a new HTTP transport listening on a given hostName:port
-func NewHttpTransport(hostName string) HttpTransport {
-
-	transport := HttpTransport{
+// Create a new HTTP transport (not listening)
+func newHttpTransport() HttpTransport {
+	return HttpTransport{
 		Mux:              http.NewServeMux(),
 		PreServerStages:  []HttpStage{},
 		Endpoints:        map[string]*server.JsonRpcServer{},
 		PostServerStages: []HttpStage{},
 		logger:           log.New(ioutil.Discard, "", 0),
+		sse:              &sseEndpoints{},
 	}
+}
+
+// Create a new HTTP transport listening on a given hostName:port
+func NewHttpTransport(hostName string) HttpTransport {
+
+	transport := newHttpTransport()
 
 	go func() {
 		err := http.ListenAndServe(hostName, transport.Mux)
@@ -88,51 +122,265 @@ func (t *HttpTransport) GetEndpoint(url string) *server.JsonRpcServer {
 }
 
 // Add an existing JSON-RPC server to a transport at given endpoint URL
-func (t *HttpTransport) AddEndpoint(url string, s *server.JsonRpcServer) (*server.JsonRpcServer, error) {
-
-	if s == nil {
-		return nil, fmt.Errorf("nil server not allowed")
+// The url is a ServeMux pattern, so it may include a host to tell apart the endpoints with the same path,
+// e.g. "api.example.com/rpc" and "internal.example.com/rpc"
+func (t *HttpTransport) AddEndpoint(url string, s *server.JsonRpcServer, opts ...EndpointOption) (*server.JsonRpcServer, error) {
+	c := &endpointConfig{
+		server:  s,
+		handler: t.serveEndpoint,
 	}
 
-	// Check if this url is already registered
-	if t.GetEndpoint(url) != nil {
-		return nil, fmt.Errorf("the url is already registered")
+	if err := t.addEndpoint(url, c, opts); err != nil {
+		return nil, err
 	}
 
-	t.Endpoints[url] = s
 	s.Logger = t.logger
 
-	// Register a handler function on the transport for the newly added endpoint
-	t.Mux.HandleFunc(url, func(w http.ResponseWriter, r *http.Request) {
+	return s, nil
+}
+
+// Create the context of an HTTP request
+// Headers and cookies set with the metadata by stages and handlers go right to the response
+func newHttpRequestContext(w http.ResponseWriter, r *http.Request) HttpRequestContext {
+	hrc := HttpRequestContext{
+		HttpRequest:    r,
+		HttpResponse:   w,
+		RequestContext: common.EmptyRequestContext(),
+		ClientIdentity: clientIdentity(r),
+	}
+	hrc.Data[common.MetadataKey] = common.NewHttpMetadata("http", r, w.Header())
+
+	return hrc
+}
+
+// Serve a JSON-RPC call to an endpoint, POSTed or sent with GET for the safe methods
+func (t *HttpTransport) serveEndpoint(c *endpointConfig, w http.ResponseWriter, r *http.Request) {
+	s := c.server
+
+	var body []byte
+	var ok bool
+
+	switch r.Method {
+	case http.MethodPost:
+		if !isJsonContentType(r.Header.Get("Content-Type")) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if body, ok = t.readBody(w, r); !ok {
+			return
+		}
+	case http.MethodGet:
+		// Only safe methods may be called with GET
+		if method, ok := s.GetMethod(r.URL.Query().Get("method")); ok && method.Safe {
+			break
+		}
+		fallthrough
+	default:
+		w.Header().Set("Allow", allowedMethods(s))
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Create Context
+	context := newHttpRequestContext(w, r)
+
+	var err error
+	if r.Method == http.MethodGet {
+		if context.RawRequest, err = queryRequest(r); err != nil {
+			context.RequestContext.MakeErrorResponse(common.ParseError)
+		}
+	} else {
+		context.RawRequest = body
+	}
+
+	if err == nil {
+		_ = t.processRequest(c, &context)
+	}
+
+	if err != nil && context.RawResponse == nil {
+		// Some error should have been set by a pre-processing stage so just regenerate the response
+		_ = context.RebuildRawResponse()
+	}
+
+	t.writeResponse(s, &context)
+
+	context.ResponseSent()
+}
+
+// Write the response out, notifications get 204 No Content
+func (t *HttpTransport) writeResponse(s *server.JsonRpcServer, hrc *HttpRequestContext) {
+	w := hrc.HttpResponse
+
+	if len(hrc.RawResponse) == 0 || !hrc.ShouldRespond() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if hrc.HttpRequest.Method == http.MethodGet && t.setCacheHeaders(hrc) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := t.writeBody(w, hrc.HttpRequest, t.responseStatus(hrc), hrc.RawResponse); err != nil {
+		// Looks like we can't write to output, so no error will ever be returned
+		s.Logger.Println("http response write error", err.Error())
+	}
+}
+
+// Set the cache headers of a response to GET, returns true if the client copy is still valid
+// Errors are never cached. Responses to requests with credentials (or setting cookies) may only be cached privately.
+func (t *HttpTransport) setCacheHeaders(hrc *HttpRequestContext) (notModified bool) {
+	w := hrc.HttpResponse
+	r := hrc.HttpRequest
+
+	w.Header().Add("Vary", "Authorization")
+	w.Header().Add("Vary", "Cookie")
+
+	if hrc.JsonRpcResponse.Error != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+
+	cacheControl := t.CacheControl
+	if cacheControl == "" {
+		cacheControl = DefaultCacheControl
+	}
+
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" || len(w.Header().Values("Set-Cookie")) > 0 {
+		cacheControl = privateCacheControl(cacheControl)
+	}
 
-		// Create Context
-		context := HttpRequestContext{
-			HttpRequest:    r,
-			HttpResponse:   w,
-			RequestContext: common.EmptyRequestContext(),
+	// The ETag is weak as the body may be compressed in different ways
+	sum := sha256.Sum256(hrc.RawResponse)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", "W/"+tag)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if match = strings.TrimPrefix(strings.TrimSpace(match), "W/"); match == tag || match == "*" {
+			return true
 		}
+	}
 
-		var err error
-		context.RawRequest, err = ioutil.ReadAll(r.Body)
-		if err != nil {
-			context.RequestContext.MakeErrorResponse(common.InvalidRequestError)
-		} else {
-			_ = t.ProcessRequest(s, &context)
+	return false
+}
+
+// Make the Cache-Control value private: shared caches must not keep the response
+func privateCacheControl(cacheControl string) string {
+	directives := []string{"private"}
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		switch name := strings.ToLower(strings.SplitN(d, "=", 2)[0]); name {
+		case "", "public", "private", "s-maxage", "proxy-revalidate":
+			continue
+		case "no-store":
+			return "no-store"
 		}
+		directives = append(directives, d)
+	}
 
-		if err != nil && context.RawResponse == nil {
-			// Some error should have been set by a pre-processing stage so just regenerate the response
-			_ = context.RebuildRawResponse()
+	return strings.Join(directives, ", ")
+}
+
+// Build the raw request from the query string of a GET request
+// The params are either URL-encoded JSON or base64url-encoded JSON
+func queryRequest(r *http.Request) ([]byte, error) {
+	query := r.URL.Query()
+
+	request := common.Request{
+		JsonRPC: "2.0",
+		Id:      query.Get("id"),
+		Method:  query.Get("method"),
+	}
+
+	if params := strings.TrimSpace(query.Get("params")); params != "" {
+		raw := []byte(params)
+		if params[0] != '{' && params[0] != '[' {
+			var err error
+			if raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(params, "=")); err != nil {
+				return nil, err
+			}
 		}
 
-		// Write the response
-		if _, err = w.Write(context.RawResponse); err != nil {
-			// Looks like we can't write to output, so no error will ever be returned
-			s.Logger.Println("http response write error", err.Error())
+		if !json.Valid(raw) {
+			return nil, fmt.Errorf("params are not valid json")
 		}
-	})
+		request.Params = raw
+	}
 
-	return s, nil
+	return json.Marshal(request)
+}
+
+// Get the value of the Allow header of the endpoint
+func allowedMethods(s *server.JsonRpcServer) string {
+	if s.HasSafeMethods() {
+		return http.MethodGet + ", " + http.MethodPost
+	}
+
+	return http.MethodPost
+}
+
+// Get the HTTP status for the response according to ErrorStatuses
+// Batch responses always get 200 OK
+func (t *HttpTransport) responseStatus(hrc *HttpRequestContext) int {
+	if hrc.HttpStatus != 0 {
+		return hrc.HttpStatus
+	}
+
+	if hrc.JsonRpcResponse.Error == nil {
+		return http.StatusOK
+	}
+
+	e := common.Error{}
+	if json.Unmarshal(hrc.JsonRpcResponse.Error, &e) != nil {
+		return http.StatusOK
+	}
+
+	if status, ok := t.ErrorStatuses[e.Code]; ok {
+		return status
+	}
+
+	// Auth failures must not look like successes to the clients and proxies
+	switch e.Code {
+	case common.UnauthorizedError.Code:
+		return http.StatusUnauthorized
+	case common.ForbiddenError.Code:
+		return http.StatusForbidden
+	}
+
+	return http.StatusOK
+}
+
+// Check if the content type is one of JSON-RPC media types
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range JsonContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The mapping of JSON-RPC errors to HTTP statuses suggested by the JSON-RPC over HTTP convention
+func StandardErrorStatuses() map[json.Number]int {
+	return map[json.Number]int{
+		common.ParseError.Code:          http.StatusBadRequest,
+		common.InvalidRequestError.Code: http.StatusBadRequest,
+		common.MethodNotFoundError.Code: http.StatusNotFound,
+		common.InvalidParamsError.Code:  http.StatusBadRequest,
+		common.InternalError.Code:       http.StatusInternalServerError,
+		common.UnauthorizedError.Code:   http.StatusUnauthorized,
+		common.ForbiddenError.Code:      http.StatusForbidden,
+	}
 }
 
 // Process the request, return the result that should be ready to write out
@@ -148,6 +396,22 @@ func (t *HttpTransport) ProcessRequest(s *server.JsonRpcServer, hrc *HttpRequest
 	return true
 }
 
+// Process the request with the stages of the transport and the endpoint
+func (t *HttpTransport) processRequest(c *endpointConfig, hrc *HttpRequestContext) (ok bool) {
+	if ok = hrc.applyPipeline(&t.PreServerStages) && hrc.applyPipeline(&c.preStages); !ok {
+		return
+	}
+
+	t.attachSession(hrc)
+
+	_ = c.server.ProcessRawInput(&hrc.RequestContext)
+
+	_ = hrc.applyPipeline(&c.postStages)
+	_ = hrc.applyPipeline(&t.PostServerStages)
+
+	return true
+}
+
 func (t *HttpTransport) AddPreServerStage(stage HttpStage) {
 	t.PreServerStages = append(t.PreServerStages, stage)
 }
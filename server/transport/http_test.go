@@ -2,13 +2,18 @@ package transport
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/compression"
 	"github.com/yekhlakov/gojsonrpc/server"
 )
 
@@ -192,7 +197,7 @@ func TestHttpTransport(t *testing.T) {
 	r, err := http.Post(
 		"http://localhost:56666/lol",
 		"application/json",
-		bytes.NewReader([]byte(`{"jsonrpc":"2.0","method":"kek"}`)),
+		bytes.NewReader([]byte(`{"jsonrpc":"2.0","id":"1","method":"kek"}`)),
 	)
 
 	if err == nil {
@@ -200,7 +205,7 @@ func TestHttpTransport(t *testing.T) {
 
 		if e != nil {
 			t.Errorf("Error reading response body")
-		} else if string(o) != `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"}}` {
+		} else if string(o) != `{"jsonrpc":"2.0","id":"1","error":{"code":-32601,"message":"Method not found"}}` {
 			t.Errorf("Wrong response received")
 			t.Errorf(string(o))
 		}
@@ -208,3 +213,328 @@ func TestHttpTransport(t *testing.T) {
 		t.Errorf("Got http post error %s", err.Error())
 	}
 }
+
+// Create a transport that is not listening, to be served with httptest
+func test_HttpTransport() *HttpTransport {
+	return &HttpTransport{
+		Mux:       http.NewServeMux(),
+		Endpoints: map[string]*server.JsonRpcServer{},
+		logger:    log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func TestHttpTransport_Semantics(t *testing.T) {
+	transport := test_HttpTransport()
+	transport.ErrorStatuses = StandardErrorStatuses()
+	_, _ = transport.AddEndpoint("/rpc", server.NewServer())
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	testData := []struct {
+		Method      string
+		ContentType string
+		Body        string
+		Status      int
+		Header      string
+		Value       string
+	}{
+		{http.MethodGet, "", ``, http.StatusMethodNotAllowed, "Allow", "POST"},
+		{http.MethodPut, "application/json", `{}`, http.StatusMethodNotAllowed, "Allow", "POST"},
+		{http.MethodPost, "text/plain", `{}`, http.StatusUnsupportedMediaType, "", ""},
+		{http.MethodPost, "", `{}`, http.StatusUnsupportedMediaType, "", ""},
+		{http.MethodPost, "application/json", `{"jsonrpc":"2.0","method":"kek"}`, http.StatusNoContent, "", ""},
+		{http.MethodPost, "application/json", `[{"jsonrpc":"2.0","method":"kek"}]`, http.StatusNoContent, "", ""},
+		{http.MethodPost, "application/json; charset=utf-8", `{"jsonrpc":"2.0","id":"1","method":"kek"}`, http.StatusNotFound, "Content-Type", "application/json"},
+		{http.MethodPost, "application/json-rpc", `{"jsonrpc":"2.0","id":"1","method":`, http.StatusBadRequest, "Content-Type", "application/json"},
+		{http.MethodPost, "application/json", `[{"jsonrpc":"2.0","id":"1","method":"kek"}]`, http.StatusOK, "Content-Type", "application/json"},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(data.Method, httpServer.URL+"/rpc", bytes.NewBufferString(data.Body))
+		if data.ContentType != "" {
+			r.Header.Set("Content-Type", data.ContentType)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+
+		if data.Header != "" && response.Header.Get(data.Header) != data.Value {
+			t.Errorf("%d got %s header %q", k, data.Header, response.Header.Get(data.Header))
+		}
+	}
+}
+
+// Notifications of a batch get no responses, a batch of notifications only gets no body at all
+func TestHttpTransport_BatchNotifications(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_GetHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	testData := []struct {
+		Body   string
+		Status int
+		Out    string
+	}{
+		{
+			`[{"jsonrpc":"2.0","id":"1","method":"echo","params":{"name":"lol"}},{"jsonrpc":"2.0","method":"echo","params":{"name":"kek"}}]`,
+			http.StatusOK,
+			`[{"jsonrpc":"2.0","id":"1","result":"lol"}]`,
+		},
+		{
+			`[{"jsonrpc":"2.0","method":"echo","params":{"name":"lol"}},{"jsonrpc":"2.0","method":"nope","params":{}}]`,
+			http.StatusNoContent,
+			``,
+		},
+		{
+			`[{"jsonrpc":"2.0","method":"echo","params":{"name":"lol"}},{"jsonrpc":"1.0","method":"echo","params":{}}]`,
+			http.StatusOK,
+			`[{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid request"}}]`,
+		},
+	}
+
+	for k, data := range testData {
+		response, err := http.Post(httpServer.URL+"/rpc", "application/json", bytes.NewBufferString(data.Body))
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+		if string(body) != data.Out {
+			t.Errorf("%d got body %s", k, string(body))
+		}
+	}
+}
+
+type test_GetHandler struct{}
+
+func (h test_GetHandler) Handle_echo(params struct {
+	Name string `json:"name"`
+}) (result string, jsonRpcError common.Error, err error) {
+	return params.Name, common.Error{}, nil
+}
+
+func (h test_GetHandler) Handle_fail(params struct{}) (result string, jsonRpcError common.Error, err error) {
+	return "", common.InternalError, nil
+}
+
+func (h test_GetHandler) Handle_write(params struct{}) (result bool, jsonRpcError common.Error, err error) {
+	return true, common.Error{}, nil
+}
+
+func TestHttpTransport_Get(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_GetHandler{}, "Handle_")
+	_ = s.MarkSafe("echo", "fail")
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	testData := []struct {
+		Query        string
+		Status       int
+		Body         string
+		CacheControl string
+	}{
+		{`?method=echo&id=1&params=%7B%22name%22%3A%22lol%22%7D`, http.StatusOK, `{"jsonrpc":"2.0","id":"1","result":"lol"}`, DefaultCacheControl},
+		{`?method=echo&id=2&params=eyJuYW1lIjoia2VrIn0`, http.StatusOK, `{"jsonrpc":"2.0","id":"2","result":"kek"}`, DefaultCacheControl},
+		{`?method=echo&id=2&params=eyJuYW1lIjoia2VrIn0=`, http.StatusOK, `{"jsonrpc":"2.0","id":"2","result":"kek"}`, DefaultCacheControl},
+		{`?method=echo&id=3`, http.StatusOK, `{"jsonrpc":"2.0","id":"3","result":""}`, DefaultCacheControl},
+		{`?method=echo&id=4&params=!!!`, http.StatusOK, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"}}`, "no-store"},
+		{`?method=fail&id=5`, http.StatusOK, `{"jsonrpc":"2.0","id":"5","error":{"code":-32603,"message":"Internal error"}}`, "no-store"},
+		{`?method=echo`, http.StatusNoContent, ``, ""},
+		{`?method=write&id=6`, http.StatusMethodNotAllowed, ``, ""},
+		{`?method=nope&id=7`, http.StatusMethodNotAllowed, ``, ""},
+	}
+
+	for k, data := range testData {
+		response, err := http.Get(httpServer.URL + "/rpc" + data.Query)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+
+		if string(body) != data.Body {
+			t.Errorf("%d got body %s", k, string(body))
+		}
+
+		if response.Header.Get("Cache-Control") != data.CacheControl {
+			t.Errorf("%d got Cache-Control %q", k, response.Header.Get("Cache-Control"))
+		}
+
+		if data.Status == http.StatusMethodNotAllowed && response.Header.Get("Allow") != "GET, POST" {
+			t.Errorf("%d got Allow %q", k, response.Header.Get("Allow"))
+		}
+	}
+
+	// A cached copy is revalidated with the ETag
+	response, _ := http.Get(httpServer.URL + "/rpc?method=echo&id=1")
+	_ = response.Body.Close()
+	etag := response.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("No ETag in the response")
+	}
+
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("ETag %s is not weak", etag)
+	}
+	if vary := response.Header.Values("Vary"); !reflect.DeepEqual(vary, []string{"Authorization", "Cookie", "Accept-Encoding"}) {
+		t.Errorf("Got Vary %v", vary)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/rpc?method=echo&id=1", nil)
+	r.Header.Set("If-None-Match", etag)
+	if response, _ = http.DefaultClient.Do(r); response.StatusCode != http.StatusNotModified {
+		t.Errorf("Got status %d for a valid ETag", response.StatusCode)
+	}
+	_ = response.Body.Close()
+
+	// The same body compressed gets the same weak ETag
+	r, _ = http.NewRequest(http.MethodGet, httpServer.URL+"/rpc?method=echo&id=1", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-None-Match", etag)
+	if response, _ = http.DefaultClient.Do(r); response.StatusCode != http.StatusNotModified {
+		t.Errorf("Got status %d for a valid ETag of a compressed body", response.StatusCode)
+	}
+	_ = response.Body.Close()
+
+	// Responses to requests with credentials are only cached privately
+	for k, header := range []string{"Authorization", "Cookie"} {
+		r, _ = http.NewRequest(http.MethodGet, httpServer.URL+"/rpc?method=echo&id=1", nil)
+		r.Header.Set(header, "secret")
+		response, _ = http.DefaultClient.Do(r)
+		_ = response.Body.Close()
+
+		if cc := response.Header.Get("Cache-Control"); cc != "private, max-age=60" {
+			t.Errorf("%d got Cache-Control %q", k, cc)
+		}
+	}
+
+	// So are the responses setting cookies
+	_, _ = transport.AddEndpoint("/session", s, WithPreServerStages(func(hrc *HttpRequestContext) bool {
+		hrc.HttpResponse.Header().Add("Set-Cookie", "session=1")
+		return true
+	}))
+	response, _ = http.Get(httpServer.URL + "/session?method=echo&id=1")
+	_ = response.Body.Close()
+	if cc := response.Header.Get("Cache-Control"); cc != "private, max-age=60" {
+		t.Errorf("Got Cache-Control %q for a response setting a cookie", cc)
+	}
+}
+
+func TestPrivateCacheControl(t *testing.T) {
+	testData := map[string]string{
+		"public, max-age=60":           "private, max-age=60",
+		"max-age=60, s-maxage=600":     "private, max-age=60",
+		"private, no-cache":            "private, no-cache",
+		"public, max-age=60, no-store": "no-store",
+		"":                             "private",
+	}
+
+	for cacheControl, expected := range testData {
+		if got := privateCacheControl(cacheControl); got != expected {
+			t.Errorf("%q got %q", cacheControl, got)
+		}
+	}
+}
+
+func TestHttpTransport_Compression(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_GetHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	transport.MaxRequestSize = 4000
+	_, _ = transport.AddEndpoint("/rpc", s)
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	// Responses are decoded by the test itself
+	httpClient := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	name := strings.Repeat("lol", 1000)
+	big := `{"jsonrpc":"2.0","id":"1","method":"echo","params":{"name":"` + name + `"}}`
+	small := `{"jsonrpc":"2.0","id":"1","method":"echo","params":{"name":"lol"}}`
+
+	testData := []struct {
+		Request         string
+		RequestEncoding string
+		AcceptEncoding  string
+		Status          int
+		Encoding        string
+		Result          string
+	}{
+		{big, "", "gzip, deflate", http.StatusOK, compression.Gzip, name},
+		{big, "", "deflate", http.StatusOK, compression.Deflate, name},
+		{big, "", "", http.StatusOK, "", name},
+		{small, "", "gzip", http.StatusOK, "", "lol"},
+		{big, compression.Gzip, "", http.StatusOK, "", name},
+		{small, compression.Deflate, "", http.StatusOK, "", "lol"},
+		{big, "br", "", http.StatusUnsupportedMediaType, "", ""},
+		{`{"name":"` + strings.Repeat("kek", 2000) + `"}`, compression.Gzip, "", http.StatusRequestEntityTooLarge, "", ""},
+	}
+
+	for k, data := range testData {
+		body := []byte(data.Request)
+		if data.RequestEncoding != "br" {
+			body, _ = compression.Compress(body, data.RequestEncoding)
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/rpc", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", data.RequestEncoding)
+		r.Header.Set("Accept-Encoding", data.AcceptEncoding)
+
+		response, err := httpClient.Do(r)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+
+		if response.Header.Get("Content-Encoding") != data.Encoding {
+			t.Errorf("%d got encoding %q", k, response.Header.Get("Content-Encoding"))
+		}
+
+		raw, err := compression.Decompress(response.Body, response.Header.Get("Content-Encoding"), 0)
+		_ = response.Body.Close()
+
+		if data.Status == http.StatusOK {
+			jsonRpcResponse := common.Response{}
+			result := ""
+			if err != nil || json.Unmarshal(raw, &jsonRpcResponse) != nil || json.Unmarshal(jsonRpcResponse.Result, &result) != nil || result != data.Result {
+				t.Errorf("%d got response %.100s", k, string(raw))
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+type test_MetadataHandler struct{}
+
+// Describe the transport of the call, set a response header and a cookie
+func (h test_MetadataHandler) Handle_whoami(rc *common.RequestContext, params struct{}) (result string, jsonRpcError common.Error, err error) {
+	m, ok := rc.GetMetadata()
+	if !ok {
+		return "", common.InternalError, nil
+	}
+
+	m.SetResponseHeader("X-Served-By", "whoami")
+	m.SetCookie(&http.Cookie{Name: "seen", Value: "1"})
+
+	return m.Transport + " " + m.Header("X-Client"), common.Error{}, nil
+}
+
+func TestMetadata(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_MetadataHandler{}, "Handle_")
+
+	// Server-level stages see the metadata too
+	remoteAddr := ""
+	s.PreProcessingStages = append(s.PreProcessingStages, func(rc *common.RequestContext) bool {
+		if m, ok := rc.GetMetadata(); ok {
+			remoteAddr = m.RemoteAddr
+		}
+		return true
+	})
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+	_, _ = transport.EnableRest("/rpc")
+	_, _ = transport.AddWebSocketEndpoint("/ws", s)
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	for k, path := range []string{"/rpc", "/rpc/whoami"} {
+		r, _ := http.NewRequest(http.MethodPost, httpServer.URL+path, bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Client", "lol")
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%d request failed: %s", k, err.Error())
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if !strings.Contains(string(body), `"http lol"`) {
+			t.Errorf("%d got body %s", k, string(body))
+		}
+
+		if response.Header.Get("X-Served-By") != "whoami" || response.Header.Get("Set-Cookie") != "seen=1" {
+			t.Errorf("%d got headers %v", k, response.Header)
+		}
+
+		if !strings.HasPrefix(remoteAddr, "127.0.0.1:") {
+			t.Errorf("%d got remote address %q", k, remoteAddr)
+		}
+	}
+
+	// Persistent connections get the headers of the handshake
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", http.Header{"X-Client": {"kek"}}, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	defer ws.Close()
+
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"1","method":"whoami","params":{}}`))
+	if r, _ := ws.ReadMessage(); string(r) != `{"jsonrpc":"2.0","id":"1","result":"websocket kek"}` {
+		t.Errorf("Got response %s", string(r))
+	}
+}
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// REST-style routing
+// With EnableRest, each method of an endpoint server is also served at POST {url}/{method}.
+// The body of the request (with a JSON content type, even if empty) is the params, the response body
+// is the raw result (or the error object) and the HTTP status is derived from the JSON-RPC error (see RestStatus).
+
+// Id of the requests made from REST calls
+const restRequestId = "rest"
+
+// Serve the methods of the endpoint at the given url as REST routes
+// Routes are generated from the methods registered so far, the methods added later are not routed
+func (t *HttpTransport) EnableRest(url string) ([]string, error) {
+	e, c := t.endpointConfig(url)
+	if c == nil {
+		return nil, fmt.Errorf("the url is not registered")
+	}
+
+	if c.rest {
+		return nil, fmt.Errorf("rest routes are already enabled")
+	}
+
+	names := make([]string, 0, len(c.server.Methods))
+	for name := range c.server.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	routes := make([]string, 0, len(names))
+	for _, name := range names {
+		route := strings.TrimSuffix(url, "/") + "/" + name
+		routes = append(routes, route)
+
+		// Routes of an endpoint removed and added again are registered already
+		if e.routes[route] {
+			continue
+		}
+		e.routes[route] = true
+
+		method := name
+		t.Mux.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+			e.serve(w, r, func(c *endpointConfig) {
+				if !c.rest {
+					http.NotFound(w, r)
+					return
+				}
+				t.serveRest(c, method, w, r)
+			})
+		})
+	}
+
+	return routes, t.updateEndpoint(url, func(c *endpointConfig) {
+		c.rest = true
+	})
+}
+
+// Serve a REST call of the method
+func (t *HttpTransport) serveRest(c *endpointConfig, method string, w http.ResponseWriter, r *http.Request) {
+	s := c.server
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Calls may change the state, so a JSON content type is required even for an empty body:
+	// a cross-origin form or a plain fetch must not be able to make them without a preflight
+	if !isJsonContentType(r.Header.Get("Content-Type")) {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, ok := t.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	// An empty body means no params
+	body = bytes.TrimSpace(body)
+
+	context := newHttpRequestContext(w, r)
+
+	request := common.Request{
+		JsonRPC: "2.0",
+		Id:      restRequestId,
+		Method:  method,
+	}
+	if len(body) > 0 {
+		if !json.Valid(body) {
+			context.MakeErrorResponse(common.ParseError)
+		} else {
+			request.Params = body
+		}
+	}
+
+	if context.JsonRpcResponse.Error == nil {
+		context.RawRequest, _ = json.Marshal(request)
+		_ = t.processRequest(c, &context)
+	}
+
+	t.writeRestResponse(s, &context)
+
+	context.ResponseSent()
+}
+
+// Write the raw result or the error object out
+func (t *HttpTransport) writeRestResponse(s *server.JsonRpcServer, hrc *HttpRequestContext) {
+	w := hrc.HttpResponse
+
+	status := http.StatusOK
+	body := []byte(hrc.JsonRpcResponse.Result)
+
+	if hrc.JsonRpcResponse.Error != nil {
+		e := common.Error{}
+		if json.Unmarshal(hrc.JsonRpcResponse.Error, &e) != nil {
+			e = common.InternalError
+		}
+		status = t.RestStatus(e)
+		body = hrc.JsonRpcResponse.Error
+	}
+
+	if hrc.HttpStatus != 0 {
+		status = hrc.HttpStatus
+	}
+
+	// Nothing to write if a stage has stopped the processing without an error
+	if len(body) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := t.writeBody(w, hrc.HttpRequest, status, body); err != nil {
+		s.Logger.Println("http response write error", err.Error())
+	}
+}
+
+// Get the HTTP status of a REST response with the error
+// ErrorStatuses take precedence over StandardErrorStatuses, other reserved (server) errors
+// get 500 Internal Server Error and application errors get 422 Unprocessable Entity
+func (t *HttpTransport) RestStatus(e common.Error) int {
+	if status, ok := t.ErrorStatuses[e.Code]; ok {
+		return status
+	}
+
+	if status, ok := StandardErrorStatuses()[e.Code]; ok {
+		return status
+	}
+
+	if code, err := e.Code.Int64(); err == nil && code >= -32768 && code <= -32000 {
+		return http.StatusInternalServerError
+	}
+
+	return http.StatusUnprocessableEntity
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+type test_RestHandler struct{}
+
+func (h test_RestHandler) Handle_echo(params struct {
+	Name string `json:"name"`
+}) (result struct {
+	Name string `json:"name"`
+}, jsonRpcError common.Error, err error) {
+	result.Name = params.Name
+	return
+}
+
+func (h test_RestHandler) Handle_fail(params struct{}) (result bool, jsonRpcError common.Error, err error) {
+	return false, common.Error{Code: "-1", Message: "Failed"}, nil
+}
+
+func TestHttpTransport_EnableRest(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_RestHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	transport.ErrorStatuses = map[json.Number]int{"-1": http.StatusConflict}
+	_, _ = transport.AddEndpoint("/rpc", s)
+
+	if _, err := transport.EnableRest("/nope"); err == nil {
+		t.Errorf("Unknown endpoint was enabled")
+	}
+
+	routes, err := transport.EnableRest("/rpc")
+	if err != nil || !reflect.DeepEqual(routes, []string{"/rpc/echo", "/rpc/fail"}) {
+		t.Fatalf("Got routes %v", routes)
+	}
+
+	if _, err = transport.EnableRest("/rpc"); err == nil {
+		t.Errorf("Routes were enabled twice")
+	}
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	testData := []struct {
+		Method      string
+		Path        string
+		ContentType string
+		Body        string
+		Status      int
+		Response    string
+	}{
+		{http.MethodPost, "/rpc/echo", "application/json", `{"name":"lol"}`, http.StatusOK, `{"name":"lol"}`},
+		{http.MethodPost, "/rpc/echo", "application/json", ``, http.StatusOK, `{"name":""}`},
+		{http.MethodPost, "/rpc/echo", "", ``, http.StatusUnsupportedMediaType, ``},
+		{http.MethodPost, "/rpc/echo", "application/x-www-form-urlencoded", ``, http.StatusUnsupportedMediaType, ``},
+		{http.MethodPost, "/rpc/echo", "application/json", `{"name":1}`, http.StatusBadRequest, `{"code":-32602,"message":"Invalid params"}`},
+		{http.MethodPost, "/rpc/echo", "application/json", `{"name":`, http.StatusBadRequest, `{"code":-32700,"message":"Parse error"}`},
+		{http.MethodPost, "/rpc/echo", "text/plain", `{}`, http.StatusUnsupportedMediaType, ``},
+		{http.MethodPost, "/rpc/fail", "application/json", `{}`, http.StatusConflict, `{"code":-1,"message":"Failed"}`},
+		{http.MethodGet, "/rpc/echo", "", ``, http.StatusMethodNotAllowed, ``},
+		{http.MethodPost, "/rpc/nope", "application/json", `{}`, http.StatusNotFound, "404 page not found\n"},
+	}
+
+	for k, data := range testData {
+		r, _ := http.NewRequest(data.Method, httpServer.URL+data.Path, bytes.NewBufferString(data.Body))
+		if data.ContentType != "" {
+			r.Header.Set("Content-Type", data.ContentType)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+
+		if string(body) != data.Response {
+			t.Errorf("%d got body %s", k, string(body))
+		}
+	}
+}
+
+func TestHttpTransport_RestStatus(t *testing.T) {
+	transport := test_HttpTransport()
+
+	testData := []struct {
+		Code   json.Number
+		Status int
+	}{
+		{common.MethodNotFoundError.Code, http.StatusNotFound},
+		{common.InternalError.Code, http.StatusInternalServerError},
+		{"-32010", http.StatusInternalServerError},
+		{"42", http.StatusUnprocessableEntity},
+	}
+
+	for k, data := range testData {
+		if status := transport.RestStatus(common.Error{Code: data.Code}); status != data.Status {
+			t.Errorf("%d got status %d", k, status)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+)
+
+// The header (or query parameter, in lower case) tying POSTed calls to an SSE session
+const (
+	SessionHeader = "X-Session-Id"
+	SessionParam  = "session"
+)
+
+// Defaults of the SSE transport
+const (
+	DefaultSSEKeepAlive      = 15 * time.Second
+	DefaultSSEReplaySize     = 100
+	DefaultSSESessionTimeout = time.Minute
+)
+
+// Server-Sent Events transport
+// A GET request opens a session (its id comes in the SessionHeader header and in the first "session" event)
+// and streams the notifications sent to the session as JSON-RPC notification objects.
+// Calls POSTed to the endpoints of the same HttpTransport with the session id get the session as their
+// notifier, so their subscription events and progress reports go to the stream.
+// Each event has an id of the form "session:sequence", so a reconnecting EventSource resumes the session
+// with Last-Event-ID and gets the missed events from a bounded replay buffer.
+// Only the client that opened a session (as identified by Owner) may resume it or tie calls to it.
+type SSETransport struct {
+	// Interval of the keepalive comments, DefaultSSEKeepAlive if zero
+	KeepAlive time.Duration
+	// Number of events kept for resuming, DefaultSSEReplaySize if zero
+	ReplaySize int
+	// A session without a stream is closed after this long, DefaultSSESessionTimeout if zero
+	SessionTimeout time.Duration
+	// Identifies the client from a request opening or resuming a session, or from a call tied to it,
+	// given the Data set by the pre server stages (e.g. the principal), CredentialsOwner if nil
+	Owner func(r *http.Request, data map[string]interface{}) string
+	// Called for each new session
+	OnConnect func(s *SSESession)
+	sessions  map[string]*SSESession
+	logger    *log.Logger
+	mu        sync.Mutex
+}
+
+// A single SSE session, it is a common.Notifier
+type SSESession struct {
+	Id        string
+	owner     string
+	transport *SSETransport
+	events    []sseEvent
+	next      uint64
+	delivered uint64
+	wake      chan struct{}
+	stream    chan struct{}
+	timer     *time.Timer
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// A buffered event
+type sseEvent struct {
+	seq  uint64
+	data []byte
+}
+
+// Create a new SSE transport
+func NewSSETransport() *SSETransport {
+	return &SSETransport{
+		sessions: map[string]*SSESession{},
+		logger:   log.New(ioutil.Discard, "", 0),
+	}
+}
+
+// Set the logger for the transport
+func (t *SSETransport) SetLogger(logger *log.Logger) error {
+	if logger == nil {
+		return fmt.Errorf("nil logger not allowed")
+	}
+
+	t.logger = logger
+	return nil
+}
+
+func (t *SSETransport) keepAlive() time.Duration {
+	if t.KeepAlive <= 0 {
+		return DefaultSSEKeepAlive
+	}
+
+	return t.KeepAlive
+}
+
+func (t *SSETransport) replaySize() int {
+	if t.ReplaySize <= 0 {
+		return DefaultSSEReplaySize
+	}
+
+	return t.ReplaySize
+}
+
+func (t *SSETransport) sessionTimeout() time.Duration {
+	if t.SessionTimeout <= 0 {
+		return DefaultSSESessionTimeout
+	}
+
+	return t.SessionTimeout
+}
+
+// Get a live session by id
+func (t *SSETransport) GetSession(id string) *SSESession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.sessions[id]
+}
+
+// Get all live sessions
+func (t *SSETransport) Sessions() []*SSESession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := make([]*SSESession, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		r = append(r, s)
+	}
+
+	return r
+}
+
+// Send a notification to all live sessions
+func (t *SSETransport) Broadcast(method string, params interface{}) error {
+	raw, err := marshalNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range t.Sessions() {
+		s.Send(raw)
+	}
+
+	return nil
+}
+
+// Close all sessions
+func (t *SSETransport) Close() {
+	for _, s := range t.Sessions() {
+		s.Close()
+	}
+}
+
+// Identify the client making the request
+func (t *SSETransport) owner(r *http.Request, data map[string]interface{}) string {
+	if t.Owner == nil {
+		return CredentialsOwner(r, data)
+	}
+
+	return t.Owner(r, data)
+}
+
+// Identify the client by a digest of its credentials (the Authorization header and the client certificate),
+// "" if it has none. Clients authenticated otherwise, e.g. EventSource with cookies, should be identified
+// by what the stages make of the credentials instead (see auth.SSEOwner).
+func CredentialsOwner(r *http.Request, data map[string]interface{}) string {
+	authorization := r.Header.Get("Authorization")
+	identity := clientIdentity(r)
+	if authorization == "" && identity == nil {
+		return ""
+	}
+
+	h := sha256.New()
+	_, _ = h.Write([]byte(authorization))
+	if identity != nil {
+		_, _ = h.Write(identity.Certificate.Raw)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Check if the client owns the session
+func (s *SSESession) ownedBy(owner string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.owner), []byte(owner)) == 1
+}
+
+func (t *SSETransport) newSession(owner string) *SSESession {
+	s := &SSESession{
+		Id:        newConnectionId(),
+		owner:     owner,
+		transport: t,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	if t.sessions == nil {
+		t.sessions = map[string]*SSESession{}
+	}
+	t.sessions[s.Id] = s
+	t.mu.Unlock()
+
+	if t.OnConnect != nil {
+		t.OnConnect(s)
+	}
+
+	return s
+}
+
+// Find the session to resume and the last event the client has seen
+func (t *SSETransport) resume(r *http.Request) (s *SSESession, last uint64) {
+	id := r.URL.Query().Get(SessionParam)
+	if id == "" {
+		id = r.Header.Get(SessionHeader)
+	}
+
+	if lastId := r.Header.Get("Last-Event-ID"); lastId != "" {
+		if i := strings.LastIndexByte(lastId, ':'); i > 0 {
+			if id == "" {
+				id = lastId[:i]
+			}
+			if id == lastId[:i] {
+				last, _ = strconv.ParseUint(lastId[i+1:], 10, 64)
+			}
+		}
+	}
+
+	if id == "" {
+		return nil, 0
+	}
+
+	return t.GetSession(id), last
+}
+
+// Stream the events of a new or resumed session
+func (t *SSETransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	owner := t.owner(r, handshakeData(r))
+
+	s, last := t.resume(r)
+	resumed := s != nil
+	if resumed && !s.ownedBy(owner) {
+		t.logger.Println("sse session", s.Id, "resumed by another client")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !resumed {
+		s = t.newSession(owner)
+	}
+
+	stream := s.attach(last)
+	defer s.detach(stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(SessionHeader, s.Id)
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		_, _ = fmt.Fprintf(w, "event: session\ndata: %s\n\n", s.Id)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(t.keepAlive())
+	defer ticker.Stop()
+
+	for {
+		for _, e := range s.since(last) {
+			if _, err := fmt.Fprintf(w, "id: %s:%d\ndata: %s\n\n", s.Id, e.seq, e.data); err != nil {
+				return
+			}
+			last = e.seq
+		}
+		flusher.Flush()
+		s.markDelivered(stream, last)
+
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-stream:
+			return
+		case <-s.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Tie the request to its SSE session, if any and if the client owns it
+func (t *SSETransport) attachSession(hrc *HttpRequestContext) bool {
+	id := hrc.HttpRequest.Header.Get(SessionHeader)
+	if id == "" {
+		id = hrc.HttpRequest.URL.Query().Get(SessionParam)
+	}
+	if id == "" {
+		return false
+	}
+
+	s := t.GetSession(id)
+	if s == nil {
+		return false
+	}
+
+	if !s.ownedBy(t.owner(hrc.HttpRequest, hrc.Data)) {
+		t.logger.Println("sse session", s.Id, "called by another client")
+		return false
+	}
+
+	hrc.Data[common.NotifierKey] = s
+	return true
+}
+
+// Attach a stream to the session, a stream attached before is closed
+func (s *SSESession) attach(last uint64) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delivered = last
+
+	if s.stream != nil {
+		close(s.stream)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	s.stream = make(chan struct{})
+	return s.stream
+}
+
+// Detach the stream, the session expires unless another stream is attached in time
+func (s *SSESession) detach(stream chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream != stream {
+		return
+	}
+
+	s.stream = nil
+	s.trimLocked()
+	s.timer = time.AfterFunc(s.transport.sessionTimeout(), s.Close)
+}
+
+// Remember the last event written by the stream
+func (s *SSESession) markDelivered(stream chan struct{}, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream == stream {
+		s.delivered = seq
+		s.trimLocked()
+	}
+}
+
+// Drop the oldest events beyond the replay size, must be called under lock
+// Events the attached stream has not written yet are kept (up to a hard limit)
+func (s *SSESession) trimLocked() {
+	size := s.transport.replaySize()
+
+	limit := size
+	if s.stream != nil && s.next > s.delivered {
+		if pending := int(s.next - s.delivered); pending > limit {
+			limit = pending
+		}
+		if limit > 4*size {
+			limit = 4 * size
+		}
+	}
+
+	if over := len(s.events) - limit; over > 0 {
+		s.events = append([]sseEvent{}, s.events[over:]...)
+	}
+}
+
+// Get the buffered events after the given one
+func (s *SSESession) since(last uint64) []sseEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.events {
+		if e.seq > last {
+			return append([]sseEvent{}, s.events[i:]...)
+		}
+	}
+
+	return nil
+}
+
+// Marshal a notification object
+func marshalNotification(method string, params interface{}) ([]byte, error) {
+	n, err := common.MakeNotification(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(n)
+}
+
+// Send a notification to the session
+func (s *SSESession) Notify(method string, params interface{}) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("session closed")
+	default:
+	}
+
+	raw, err := marshalNotification(method, params)
+	if err != nil {
+		return err
+	}
+
+	s.Send(raw)
+	return nil
+}
+
+// Queue a raw message for the stream, the oldest events are dropped from the replay buffer
+func (s *SSESession) Send(message []byte) {
+	s.mu.Lock()
+	s.next++
+	s.events = append(s.events, sseEvent{seq: s.next, data: message})
+	s.trimLocked()
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get a channel that is closed when the session is closed
+func (s *SSESession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close the session and its stream
+func (s *SSESession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.mu.Unlock()
+
+		s.transport.mu.Lock()
+		delete(s.transport.sessions, s.Id)
+		s.transport.mu.Unlock()
+	})
+}
+
+// The SSE endpoints of the HTTP transport
+// They are looked up by the calls to all endpoints, so they are guarded against being added or removed meanwhile
+type sseEndpoints struct {
+	transports map[string]*SSETransport
+	mu         sync.RWMutex
+}
+
+func (e *sseEndpoints) add(url string, sse *SSETransport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.transports == nil {
+		e.transports = map[string]*SSETransport{}
+	}
+	e.transports[url] = sse
+}
+
+func (e *sseEndpoints) remove(url string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.transports, url)
+}
+
+func (e *sseEndpoints) list() []*SSETransport {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	r := make([]*SSETransport, 0, len(e.transports))
+	for _, sse := range e.transports {
+		r = append(r, sse)
+	}
+
+	return r
+}
+
+// Add an SSE endpoint to the HTTP transport at the given URL
+// Calls to all endpoints of the transport may be tied to its sessions.
+// The pre server stages (of the transport and the options) apply to the requests opening the streams.
+func (t *HttpTransport) AddSSEEndpoint(url string, opts ...EndpointOption) (*SSETransport, error) {
+	if t.sse == nil {
+		t.sse = &sseEndpoints{}
+	}
+
+	sse := NewSSETransport()
+	_ = sse.SetLogger(t.logger)
+
+	c := &endpointConfig{
+		handler: func(c *endpointConfig, w http.ResponseWriter, r *http.Request) {
+			if r, ok := t.admitStream(c, w, r); ok {
+				sse.ServeHTTP(w, r)
+			}
+		},
+		stream: true,
+		close: func() {
+			t.sse.remove(url)
+			sse.Close()
+		},
+	}
+
+	if err := t.addEndpoint(url, c, opts); err != nil {
+		return nil, err
+	}
+
+	t.sse.add(url, sse)
+
+	return sse, nil
+}
+
+// Tie the request to the SSE session given in it
+// It is done once the pre server stages are passed, so that the owner may be identified by what they set
+func (t *HttpTransport) attachSession(hrc *HttpRequestContext) {
+	if t.sse == nil {
+		return
+	}
+
+	for _, sse := range t.sse.list() {
+		if sse.attachSession(hrc) {
+			return
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+type test_SSEHandler struct{}
+
+// Send the given number of notifications to the caller
+func (h test_SSEHandler) Handle_tick(rc *common.RequestContext, params struct {
+	Count int `json:"count"`
+}) (result bool, jsonRpcError common.Error, err error) {
+	n, ok := rc.GetNotifier()
+	if !ok {
+		return false, common.Error{}, nil
+	}
+
+	for i := 1; i <= params.Count; i++ {
+		_ = n.Notify("tick", i)
+	}
+
+	return true, common.Error{}, nil
+}
+
+// Read a single event (or comment) from the stream
+func test_ReadEvent(t *testing.T, r *bufio.Reader) string {
+	lines := []string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream read error: %s", err.Error())
+		}
+		if line == "\n" {
+			return strings.Join(lines, "\n")
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func test_OpenStream(t *testing.T, url string, lastEventId string) (*http.Response, *bufio.Reader) {
+	r, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventId != "" {
+		r.Header.Set("Last-Event-ID", lastEventId)
+	}
+
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("could not open the stream: %s", err.Error())
+	}
+
+	return response, bufio.NewReader(response.Body)
+}
+
+func TestSSETransport(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_SSEHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+
+	sse, err := transport.AddSSEEndpoint("/events")
+	if err != nil {
+		t.Fatalf("could not add the endpoint: %s", err.Error())
+	}
+	sse.ReplaySize = 2
+	sse.KeepAlive = 50 * time.Millisecond
+	sse.SessionTimeout = 50 * time.Millisecond
+
+	if _, err = transport.AddSSEEndpoint("/events"); err == nil {
+		t.Errorf("duplicate endpoint was accepted")
+	}
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	response, stream := test_OpenStream(t, httpServer.URL+"/events", "")
+	id := response.Header.Get(SessionHeader)
+	if response.Header.Get("Content-Type") != "text/event-stream" || id == "" {
+		t.Fatalf("bad stream headers %v", response.Header)
+	}
+
+	if e := test_ReadEvent(t, stream); e != "event: session\ndata: "+id {
+		t.Errorf("bad session event %q", e)
+	}
+
+	// A call tied to the session sends its notifications to the stream
+	call, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/rpc", bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"tick","params":{"count":3}}`))
+	call.Header.Set("Content-Type", "application/json")
+	call.Header.Set(SessionHeader, id)
+	callResponse, err := http.DefaultClient.Do(call)
+	if err != nil {
+		t.Fatalf("call failed: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(callResponse.Body)
+	_ = callResponse.Body.Close()
+	if string(body) != `{"jsonrpc":"2.0","id":"1","result":true}` {
+		t.Errorf("bad call response %s", string(body))
+	}
+
+	for i, tick := range []string{"1", "2", "3"} {
+		expected := "id: " + id + ":" + tick + "\ndata: " + `{"jsonrpc":"2.0","method":"tick","params":` + tick + `}`
+		if e := test_ReadEvent(t, stream); e != expected {
+			t.Errorf("%d bad event %q", i, e)
+		}
+	}
+
+	if e := test_ReadEvent(t, stream); e != ": keepalive" {
+		t.Errorf("bad keepalive %q", e)
+	}
+	_ = response.Body.Close()
+
+	// Resuming replays the missed events still in the buffer (only 2 of them are kept)
+	response, stream = test_OpenStream(t, httpServer.URL+"/events", id+":0")
+	if response.Header.Get(SessionHeader) != id {
+		t.Fatalf("session was not resumed")
+	}
+
+	for i, tick := range []string{"2", "3"} {
+		if e := test_ReadEvent(t, stream); !strings.HasPrefix(e, "id: "+id+":"+tick+"\n") {
+			t.Errorf("%d bad replayed event %q", i, e)
+		}
+	}
+	_ = response.Body.Close()
+
+	// A session without a stream expires
+	deadline := time.Now().Add(2 * time.Second)
+	for sse.GetSession(id) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sse.GetSession(id) != nil {
+		t.Errorf("session did not expire")
+	}
+}
+
+func TestSSETransport_Endpoint(t *testing.T) {
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", server.NewServer())
+
+	if _, err := transport.AddSSEEndpoint("/rpc"); err == nil {
+		t.Errorf("url of another endpoint was accepted")
+	}
+
+	if _, err := transport.AddSSEEndpoint("/events", WithPostServerStages(func(hrc *HttpRequestContext) bool { return true })); err == nil {
+		t.Errorf("post server stages were accepted")
+	}
+
+	// Streams are only opened with the token
+	sse, err := transport.AddSSEEndpoint("/events",
+		WithCORS(&CORS{AllowedOrigins: []string{"https://app.example.com"}}),
+		WithPreServerStages(func(hrc *HttpRequestContext) bool {
+			if hrc.HttpRequest.Header.Get("X-Token") == "secret" {
+				return true
+			}
+			hrc.HttpStatus = http.StatusUnauthorized
+			return false
+		}))
+	if err != nil {
+		t.Fatalf("could not add the endpoint: %s", err.Error())
+	}
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	open := func(token string) *http.Response {
+		r, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/events", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		if token != "" {
+			r.Header.Set("X-Token", token)
+		}
+
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("could not open the stream: %s", err.Error())
+		}
+		return response
+	}
+
+	response := open("")
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized || len(sse.Sessions()) != 0 {
+		t.Errorf("stream was opened without the token: %d", response.StatusCode)
+	}
+
+	response = open("secret")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("bad stream response %d %v", response.StatusCode, response.Header)
+	}
+
+	// Removing the endpoint closes its sessions
+	if err = transport.RemoveEndpoint("/events"); err != nil {
+		t.Fatalf("could not remove the endpoint: %s", err.Error())
+	}
+	if len(sse.Sessions()) != 0 {
+		t.Errorf("sessions of a removed endpoint were left open")
+	}
+	_ = response.Body.Close()
+
+	response = open("secret")
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("removed endpoint responded with %d", response.StatusCode)
+	}
+
+	if _, err = transport.AddSSEEndpoint("/events"); err != nil {
+		t.Errorf("removed endpoint could not be added again: %s", err.Error())
+	}
+}
+
+func TestSSETransport_Owner(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_SSEHandler{}, "Handle_")
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", s)
+	sse, _ := transport.AddSSEEndpoint("/events")
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/events", nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("could not open the stream: %s", err.Error())
+	}
+	defer response.Body.Close()
+	id := response.Header.Get(SessionHeader)
+
+	testData := []struct {
+		Authorization string
+		Status        int
+		Tied          bool
+	}{
+		{"", http.StatusForbidden, false},
+		{"Bearer mallory", http.StatusForbidden, false},
+		{"Bearer alice", http.StatusOK, true},
+	}
+
+	for k, data := range testData {
+		// Only the owner resumes the session
+		r, _ = http.NewRequest(http.MethodGet, httpServer.URL+"/events?"+SessionParam+"="+id, nil)
+		if data.Authorization != "" {
+			r.Header.Set("Authorization", data.Authorization)
+		}
+		resumed, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%d could not resume the stream: %s", k, err.Error())
+		}
+		_ = resumed.Body.Close()
+		if resumed.StatusCode != data.Status {
+			t.Errorf("%d resumed with status %d", k, resumed.StatusCode)
+		}
+
+		// Only the calls of the owner are tied to the session
+		call, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/rpc", bytes.NewBufferString(`{"jsonrpc":"2.0","id":"1","method":"tick","params":{"count":1}}`))
+		call.Header.Set("Content-Type", "application/json")
+		call.Header.Set(SessionHeader, id)
+		if data.Authorization != "" {
+			call.Header.Set("Authorization", data.Authorization)
+		}
+		callResponse, err := http.DefaultClient.Do(call)
+		if err != nil {
+			t.Fatalf("%d call failed: %s", k, err.Error())
+		}
+		body, _ := ioutil.ReadAll(callResponse.Body)
+		_ = callResponse.Body.Close()
+		if tied := string(body) == `{"jsonrpc":"2.0","id":"1","result":true}`; tied != data.Tied {
+			t.Errorf("%d wrong call response %s", k, string(body))
+		}
+	}
+
+	if sse.GetSession(id) == nil {
+		t.Errorf("session was lost")
+	}
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Stdio transport
+// Serves a JSON-RPC server over the standard input and output (LSP-style Content-Length framing by default),
+// so the server may be run as a subprocess of an editor or another tool.
+// Nothing but responses is ever written to Out, logs go to the standard error.
+type StdioTransport struct {
+	Server *server.JsonRpcServer
+	Framer framing.Framer
+	In     io.Reader
+	Out    io.Writer
+	// Limit for the size of incoming messages, zero means framing.DefaultMaxMessageSize
+	MaxMessageSize int64
+	// Called for the connection before it starts serving requests
+	OnConnect func(c *Connection)
+	logger    *log.Logger
+}
+
+// A byte stream made of separate input and output
+type stdioStream struct {
+	io.Reader
+	io.Writer
+}
+
+// Only the input is closed, the output may be still shared with others
+func (s stdioStream) Close() error {
+	if c, ok := s.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Create a new stdio transport for the given server
+func NewStdioTransport(s *server.JsonRpcServer) *StdioTransport {
+	t := &StdioTransport{
+		Server: s,
+		Framer: framing.ContentLength{},
+		In:     os.Stdin,
+		Out:    os.Stdout,
+	}
+	_ = t.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+	return t
+}
+
+// Set the logger for the transport and its server
+func (t *StdioTransport) SetLogger(logger *log.Logger) error {
+	if logger == nil {
+		return fmt.Errorf("nil logger not allowed")
+	}
+
+	t.logger = logger
+	t.Server.Logger = logger
+
+	return nil
+}
+
+// Serve requests until the input is closed
+func (t *StdioTransport) Serve() error {
+	fc := framing.NewConn(stdioStream{t.In, t.Out}, t.Framer)
+	fc.MaxMessageSize = t.MaxMessageSize
+
+	c := NewConnection(t.Server, fc, "stdio")
+	c.RemoteAddr = "stdio"
+
+	if t.OnConnect != nil {
+		t.OnConnect(c)
+	}
+
+	err := c.Serve()
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// Serve the server over the standard input and output until the input is closed
+func ServeStdio(s *server.JsonRpcServer) error {
+	return NewStdioTransport(s).Serve()
+}
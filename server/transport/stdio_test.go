@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/yekhlakov/gojsonrpc/framing"
+)
+
+func TestStdioTransport_Serve(t *testing.T) {
+	in := bytes.Buffer{}
+	_ = framing.ContentLength{}.WriteFrame(&in, []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":0}}`))
+	_ = framing.ContentLength{}.WriteFrame(&in, []byte(`{"jsonrpc":"2.0","method":"sleep","params":{"delay":0}}`))
+
+	out := bytes.Buffer{}
+	w := &test_SyncWriter{w: &out, done: make(chan bool, 1)}
+
+	st := NewStdioTransport(test_StreamServer())
+	st.In = &in
+	st.Out = w
+
+	if err := st.Serve(); err != nil {
+		t.Errorf("serving failed: %s", err.Error())
+	}
+	<-w.done
+
+	if out.String() != "Content-Length: 37\r\n\r\n"+`{"jsonrpc":"2.0","id":"1","result":0}` {
+		t.Errorf("wrong output %q", out.String())
+	}
+}
+
+// Signals the first write
+type test_SyncWriter struct {
+	w    io.Writer
+	done chan bool
+}
+
+func (w *test_SyncWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.done <- true
+	return n, err
+}
+
+func TestStdioTransport_ServeWaitsForRequests(t *testing.T) {
+	in := bytes.Buffer{}
+	_ = framing.ContentLength{}.WriteFrame(&in, []byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":50}}`))
+
+	out := bytes.Buffer{}
+
+	st := NewStdioTransport(test_StreamServer())
+	st.In = &in
+	st.Out = &out
+
+	// The input ends right away but the response is still written before Serve returns
+	if err := st.Serve(); err != nil {
+		t.Errorf("serving failed: %s", err.Error())
+	}
+
+	if out.String() != "Content-Length: 38\r\n\r\n"+`{"jsonrpc":"2.0","id":"1","result":50}` {
+		t.Errorf("wrong output %q", out.String())
+	}
+}
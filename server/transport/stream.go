@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Stream transport
+// Serves a JSON-RPC server over TCP or Unix domain socket connections with pluggable framing
+type StreamTransport struct {
+	connectionSet
+	Server *server.JsonRpcServer
+	Framer framing.Framer
+	// Connections over this limit are closed right after accepting, zero means no limit
+	MaxConnections int
+	// Connections without traffic for this long are closed, zero means no timeout
+	IdleTimeout time.Duration
+	// Limit for the size of incoming messages, zero means framing.DefaultMaxMessageSize
+	MaxMessageSize int64
+	// Maximum number of requests processed at once for each connection, zero means no limit
+	MaxInFlight int
+	// Called for each new connection before it starts serving requests
+	OnConnect func(c *Connection)
+	listener  net.Listener
+	network   string
+	logger    *log.Logger
+	stopped   bool
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// Create a new TCP transport listening on the given address
+func NewTcpTransport(address string, s *server.JsonRpcServer, framer framing.Framer) (*StreamTransport, error) {
+	return listenStream("tcp", address, s, framer)
+}
+
+// Create a new Unix domain socket transport listening on the given path
+func NewUnixTransport(path string, s *server.JsonRpcServer, framer framing.Framer) (*StreamTransport, error) {
+	return listenStream("unix", path, s, framer)
+}
+
+func listenStream(network string, address string, s *server.JsonRpcServer, framer framing.Framer) (*StreamTransport, error) {
+	if s == nil {
+		return nil, fmt.Errorf("nil server not allowed")
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStreamTransport(l, s, framer), nil
+}
+
+// Create a new stream transport for connections accepted from the given listener
+// Connections are not accepted until Start is called, so the settings may be changed before that
+func NewStreamTransport(l net.Listener, s *server.JsonRpcServer, framer framing.Framer) *StreamTransport {
+	if framer == nil {
+		framer = framing.Newline{}
+	}
+
+	t := &StreamTransport{
+		Server:   s,
+		Framer:   framer,
+		listener: l,
+		network:  l.Addr().Network(),
+		logger:   log.New(ioutil.Discard, "", 0),
+	}
+
+	return t
+}
+
+// Start accepting connections in the background
+func (t *StreamTransport) Start() {
+	go t.acceptLoop()
+}
+
+// Get the address the transport listens on
+func (t *StreamTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Set the logger for the transport and its server
+func (t *StreamTransport) SetLogger(logger *log.Logger) error {
+	if logger == nil {
+		return fmt.Errorf("nil logger not allowed")
+	}
+
+	t.logger = logger
+	t.Server.Logger = logger
+
+	return nil
+}
+
+// Accept connections until the listener is closed
+func (t *StreamTransport) acceptLoop() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			t.mu.Lock()
+			stopped := t.stopped
+			t.mu.Unlock()
+
+			if !stopped {
+				t.logger.Println(t.network, "accept error", err.Error())
+			}
+			return
+		}
+
+		if t.MaxConnections > 0 && len(t.Connections()) >= t.MaxConnections {
+			t.logger.Println(t.network, "connection limit reached, rejecting", conn.RemoteAddr().String())
+			_ = conn.Close()
+			continue
+		}
+
+		fc := framing.NewConn(conn, t.Framer)
+		fc.MaxMessageSize = t.MaxMessageSize
+
+		c := NewConnection(t.Server, fc, t.network)
+		c.RemoteAddr = conn.RemoteAddr().String()
+		c.MaxInFlight = t.MaxInFlight
+		c.IdleTimeout = t.IdleTimeout
+
+		// Registering the connection and the shutdown check go under the same lock,
+		// so Shutdown never misses a connection nor waits while one is being added
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		t.add(c)
+		t.wg.Add(1)
+		t.mu.Unlock()
+
+		if t.OnConnect != nil {
+			t.OnConnect(c)
+		}
+
+		go func() {
+			defer t.wg.Done()
+			_ = c.Serve()
+		}()
+	}
+}
+
+// Stop accepting connections, wait for the requests in flight and close all connections
+// If the context is done before the requests are finished, the connections are closed anyway
+func (t *StreamTransport) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+
+	err := t.listener.Close()
+
+	for _, c := range t.Connections() {
+		if e := c.WaitIdle(ctx); e != nil {
+			err = e
+			break
+		}
+	}
+
+	t.closeAll()
+	t.wg.Wait()
+
+	return err
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/framing"
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+type test_StreamHandler struct{}
+
+func (h test_StreamHandler) Handle_sleep(params struct {
+	Delay int `json:"delay"`
+}) (result int, jsonRpcError common.Error, err error) {
+	time.Sleep(time.Duration(params.Delay) * time.Millisecond)
+	result = params.Delay
+	return
+}
+
+func test_StreamServer() *server.JsonRpcServer {
+	s := server.NewServer()
+	s.AddHandler(test_StreamHandler{}, "Handle_")
+	return s
+}
+
+func TestStreamTransport(t *testing.T) {
+	testData := []struct {
+		Network string
+		Address string
+		Framer  framing.Framer
+	}{
+		{"tcp", "127.0.0.1:0", framing.Newline{}},
+		{"tcp", "127.0.0.1:0", framing.ContentLength{}},
+		{"unix", filepath.Join(t.TempDir(), "rpc.sock"), framing.LengthPrefix{}},
+	}
+
+	for k, data := range testData {
+		var st *StreamTransport
+		var err error
+		if data.Network == "tcp" {
+			st, err = NewTcpTransport(data.Address, test_StreamServer(), data.Framer)
+		} else {
+			st, err = NewUnixTransport(data.Address, test_StreamServer(), data.Framer)
+		}
+		if err != nil {
+			t.Fatalf("%d could not listen: %s", k, err.Error())
+		}
+		st.Start()
+
+		conn, err := net.Dial(data.Network, st.Addr().String())
+		if err != nil {
+			t.Fatalf("%d could not dial: %s", k, err.Error())
+		}
+		fc := framing.NewConn(conn, data.Framer)
+
+		_ = fc.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":0}}`))
+		if r, err := fc.ReadMessage(); err != nil || string(r) != `{"jsonrpc":"2.0","id":"1","result":0}` {
+			t.Errorf("%d wrong response %s", k, string(r))
+		}
+
+		_ = fc.Close()
+		_ = st.Shutdown(context.Background())
+	}
+}
+
+func TestStreamTransport_Limits(t *testing.T) {
+	st, err := NewTcpTransport("127.0.0.1:0", test_StreamServer(), nil)
+	if err != nil {
+		t.Fatalf("could not listen: %s", err.Error())
+	}
+	st.MaxConnections = 1
+	st.IdleTimeout = 50 * time.Millisecond
+	st.Start()
+	defer st.Shutdown(context.Background())
+
+	c1, _ := net.Dial("tcp", st.Addr().String())
+	defer c1.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	// Over the limit
+	c2, _ := net.Dial("tcp", st.Addr().String())
+	defer c2.Close()
+	if _, err = framing.NewConn(c2, nil).ReadMessage(); err == nil {
+		t.Errorf("connection over the limit was served")
+	}
+
+	// Idle
+	if _, err = framing.NewConn(c1, nil).ReadMessage(); err == nil {
+		t.Errorf("idle connection was not closed")
+	}
+}
+
+func TestStreamTransport_Shutdown(t *testing.T) {
+	st, err := NewTcpTransport("127.0.0.1:0", test_StreamServer(), nil)
+	if err != nil {
+		t.Fatalf("could not listen: %s", err.Error())
+	}
+	st.Start()
+
+	conn, _ := net.Dial("tcp", st.Addr().String())
+	fc := framing.NewConn(conn, nil)
+	defer fc.Close()
+
+	_ = fc.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"1","method":"sleep","params":{"delay":100}}`))
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		done <- st.Shutdown(context.Background())
+	}()
+
+	// The request in flight is completed
+	if r, err := fc.ReadMessage(); err != nil || string(r) != `{"jsonrpc":"2.0","id":"1","result":100}` {
+		t.Errorf("request in flight was not completed: %s", string(r))
+	}
+
+	if err = <-done; err != nil {
+		t.Errorf("shutdown failed: %s", err.Error())
+	}
+
+	if _, err = net.Dial("tcp", st.Addr().String()); err == nil {
+		t.Errorf("connection was accepted after shutdown")
+	}
+}
@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default interval of checking the certificate files for changes
+const DefaultCertCheckInterval = 10 * time.Second
+
+// Create a new HTTPS transport listening on a given hostName:port
+// The config must provide the server certificate (see NewTLSConfig); for mutual TLS set its ClientAuth
+// and ClientCAs, the verified client certificates are exposed as HttpRequestContext.ClientIdentity
+func NewHttpsTransport(hostName string, config *tls.Config) (HttpTransport, error) {
+	if config == nil {
+		return HttpTransport{}, fmt.Errorf("nil tls config not allowed")
+	}
+
+	listener, err := tls.Listen("tcp", hostName, config)
+	if err != nil {
+		return HttpTransport{}, err
+	}
+
+	transport := newHttpTransport()
+
+	go func() {
+		_ = http.Serve(listener, transport.Mux)
+	}()
+
+	return transport, nil
+}
+
+// Create a server TLS config with the certificate loaded from files and reloaded when they change
+func NewTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// Load a pool of CA certificates from PEM files (e.g. for tls.Config.ClientCAs)
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", file)
+		}
+	}
+
+	return pool, nil
+}
+
+// Keeps a certificate loaded from files, reloading it once the files change
+// A certificate that fails to load is logged and the previous one stays in use
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// How often the files are checked for changes, DefaultCertCheckInterval if zero
+	CheckInterval time.Duration
+	Logger        *log.Logger
+	cert          *tls.Certificate
+	modTime       time.Time
+	checked       time.Time
+	mu            sync.Mutex
+}
+
+// Create a new reloader with the certificate loaded from the files
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Logger:   log.New(ioutil.Discard, "", 0),
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get the latest modification time of the files
+func (c *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{c.CertFile, c.KeyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Load the certificate from the files
+func (c *CertReloader) Reload() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert = &cert
+	c.modTime = modTime
+	c.checked = time.Now()
+
+	return nil
+}
+
+// Reload the certificate if the files have changed since the last check
+func (c *CertReloader) check() {
+	interval := c.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCertCheckInterval
+	}
+
+	c.mu.Lock()
+	if time.Since(c.checked) < interval {
+		c.mu.Unlock()
+		return
+	}
+	c.checked = time.Now()
+	loaded := c.modTime
+	c.mu.Unlock()
+
+	modTime, err := c.filesModTime()
+	if err != nil || modTime.Equal(loaded) {
+		return
+	}
+
+	if err = c.Reload(); err != nil && c.Logger != nil {
+		c.Logger.Println("certificate reload error", err.Error())
+	}
+}
+
+// Get the current certificate, for tls.Config.GetCertificate
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.check()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cert, nil
+}
+
+// Get the current certificate, for tls.Config.GetClientCertificate
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.GetCertificate(nil)
+}
+
+// Identity of a client from its verified certificate
+type ClientIdentity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []string
+	Certificate    *x509.Certificate
+}
+
+// Get the identity of the client of the request, nil if it has not presented a verified certificate
+func clientIdentity(r *http.Request) *ClientIdentity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	identity := &ClientIdentity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		Certificate:    cert,
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+
+	return identity
+}
@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/server"
+)
+
+// Issue a certificate signed by the parent (self-signed if nil), write it and its key as PEM files
+func test_WriteCert(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("could not create a certificate: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	_ = ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return cert, key
+}
+
+func TestHttpsTransport_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, caKey := test_WriteCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	test_WriteCert(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	spiffe, _ := url.Parse("spiffe://example.com/alice")
+	test_WriteCert(t, dir, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		DNSNames:    []string{"alice.example.com"},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	config, err := NewTLSConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatalf("could not create the config: %s", err.Error())
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if config.ClientCAs, err = LoadCertPool(filepath.Join(dir, "ca.pem")); err != nil {
+		t.Fatalf("could not load the pool: %s", err.Error())
+	}
+
+	if _, err = NewHttpsTransport("127.0.0.1:0", nil); err == nil {
+		t.Errorf("Nil config was accepted")
+	}
+	if _, err = NewHttpsTransport("127.0.0.1:0", config); err != nil {
+		t.Errorf("Could not create the transport: %s", err.Error())
+	}
+
+	// The same config on a listener with a known address
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("could not listen: %s", err.Error())
+	}
+	defer listener.Close()
+
+	transport := test_HttpTransport()
+	_, _ = transport.AddEndpoint("/rpc", server.NewServer())
+
+	var identity *ClientIdentity
+	transport.AddPreServerStage(func(context *HttpRequestContext) bool {
+		identity = context.ClientIdentity
+		return true
+	})
+
+	go func() {
+		_ = http.Serve(listener, transport.Mux)
+	}()
+
+	clientCert, _ := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	roots, _ := LoadCertPool(filepath.Join(dir, "ca.pem"))
+
+	call := func(certs []tls.Certificate) error {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		response, err := httpClient.Post("https://"+listener.Addr().String()+"/rpc", "application/json", nil)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+		return err
+	}
+
+	if err = call(nil); err != nil || identity != nil {
+		t.Errorf("Anonymous call failed or got an identity: %v", err)
+	}
+
+	if err = call([]tls.Certificate{clientCert}); err != nil {
+		t.Fatalf("Call with a certificate failed: %s", err.Error())
+	}
+
+	if identity == nil || identity.Subject.CommonName != "alice" || identity.DNSNames[0] != "alice.example.com" || identity.URIs[0] != "spiffe://example.com/alice" {
+		t.Errorf("Got identity %v", identity)
+	}
+
+	if _, err = LoadCertPool(filepath.Join(dir, "server.key")); err == nil {
+		t.Errorf("Pool was loaded from a key")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "cert.key")
+
+	first, _ := test_WriteCert(t, dir, "cert", &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}, nil, nil)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("could not load the certificate: %s", err.Error())
+	}
+	reloader.CheckInterval = time.Nanosecond
+
+	cert, _ := reloader.GetCertificate(nil)
+	if parsed, _ := x509.ParseCertificate(cert.Certificate[0]); !parsed.Equal(first) {
+		t.Errorf("Wrong certificate was loaded")
+	}
+
+	// A broken file keeps the old certificate
+	_ = ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	_ = os.Chtimes(certFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if cert, _ = reloader.GetCertificate(nil); cert == nil {
+		t.Fatalf("Certificate was lost")
+	} else if parsed, _ := x509.ParseCertificate(cert.Certificate[0]); !parsed.Equal(first) {
+		t.Errorf("Broken certificate was loaded")
+	}
+
+	second, _ := test_WriteCert(t, dir, "cert", &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}, nil, nil)
+	_ = os.Chtimes(certFile, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+
+	cert, _ = reloader.GetCertificate(nil)
+	if parsed, _ := x509.ParseCertificate(cert.Certificate[0]); !parsed.Equal(second) {
+		t.Errorf("Certificate was not reloaded")
+	}
+
+	if _, err = NewCertReloader(filepath.Join(dir, "nope.pem"), keyFile); err == nil {
+		t.Errorf("Missing file was accepted")
+	}
+}
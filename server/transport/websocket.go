@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+// WebSocket transport
+// Serves a JSON-RPC server over persistent WebSocket connections; it is an http.Handler
+// so it may be mounted anywhere, e.g. with HttpTransport.AddWebSocketEndpoint
+type WebSocketTransport struct {
+	connectionSet
+	Server *server.JsonRpcServer
+	// Limit for the size of incoming messages, zero means websocket.DefaultMaxMessageSize
+	MaxMessageSize int64
+	// Maximum number of requests processed at once for each connection, zero means no limit
+	MaxInFlight int
+	// Connections without traffic for this long are closed, zero means no timeout
+	IdleTimeout time.Duration
+	// Called for each new connection before it starts serving requests
+	OnConnect func(c *Connection)
+	// Checks the Origin of the handshakes, nil means websocket.SameOrigin (cross-origin handshakes are denied)
+	CheckOrigin websocket.OriginChecker
+	logger      *log.Logger
+}
+
+// Create a new WebSocket transport for the given server
+func NewWebSocketTransport(s *server.JsonRpcServer) *WebSocketTransport {
+	return &WebSocketTransport{
+		Server: s,
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+}
+
+// Set the logger for the transport and its server
+func (t *WebSocketTransport) SetLogger(logger *log.Logger) error {
+	if logger == nil {
+		return fmt.Errorf("nil logger not allowed")
+	}
+
+	t.logger = logger
+	t.Server.Logger = logger
+
+	return nil
+}
+
+// Upgrade the HTTP connection and serve it until it is closed
+func (t *WebSocketTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := websocket.UpgradeChecked(w, r, t.CheckOrigin)
+	if err != nil {
+		t.logger.Println("websocket upgrade error", err.Error())
+		return
+	}
+	ws.MaxMessageSize = t.MaxMessageSize
+
+	c := NewConnection(t.Server, ws, "websocket")
+	c.RemoteAddr = r.RemoteAddr
+	c.HttpRequest = r
+	c.HandshakeData = handshakeData(r)
+	c.MaxInFlight = t.MaxInFlight
+	c.IdleTimeout = t.IdleTimeout
+
+	t.add(c)
+
+	if t.OnConnect != nil {
+		t.OnConnect(c)
+	}
+
+	_ = c.Serve()
+}
+
+// Close all the connections
+func (t *WebSocketTransport) Close() {
+	t.closeAll()
+}
+
+// Add a JSON-RPC server to the HTTP transport as a WebSocket endpoint at the given URL
+// The pre server stages (of the transport and the options) apply to the handshakes, a rejected handshake
+// gets the response of the stage instead of the upgrade. Post server stages do not apply.
+// The Data the stages set on the handshake (e.g. the principal of auth.HttpStage) goes to every request of the connection.
+func (t *HttpTransport) AddWebSocketEndpoint(url string, s *server.JsonRpcServer, opts ...EndpointOption) (*WebSocketTransport, error) {
+	if s == nil {
+		return nil, fmt.Errorf("nil server not allowed")
+	}
+
+	ws := NewWebSocketTransport(s)
+	_ = ws.SetLogger(t.logger)
+
+	c := &endpointConfig{
+		server: s,
+		handler: func(c *endpointConfig, w http.ResponseWriter, r *http.Request) {
+			if r, ok := t.admitStream(c, w, r); ok {
+				ws.ServeHTTP(w, r)
+			}
+		},
+		stream: true,
+		close:  ws.Close,
+	}
+
+	if err := t.addEndpoint(url, c, opts); err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
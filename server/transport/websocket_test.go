@@ -0,0 +1,248 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yekhlakov/gojsonrpc/common"
+	"github.com/yekhlakov/gojsonrpc/server"
+	"github.com/yekhlakov/gojsonrpc/websocket"
+)
+
+type test_WebSocketHandler struct{}
+
+// Remember the name in the connection state
+func (h test_WebSocketHandler) Handle_login(rc *common.RequestContext, params struct {
+	Name string `json:"name"`
+}) (result string, jsonRpcError common.Error, err error) {
+	c, _ := GetConnection(rc)
+	c.Set("name", params.Name)
+	result = "ok"
+	return
+}
+
+// Greet the name from the connection state after the given delay
+func (h test_WebSocketHandler) Handle_greet(rc *common.RequestContext, params struct {
+	Delay int `json:"delay"`
+}) (result string, jsonRpcError common.Error, err error) {
+	time.Sleep(time.Duration(params.Delay) * time.Millisecond)
+	c, _ := GetConnection(rc)
+	name, _ := c.Get("name")
+	result = "hello " + name.(string)
+	return
+}
+
+// Wait until the request is cancelled
+func (h test_WebSocketHandler) Handle_wait(rc *common.RequestContext, params struct{}) (result string, jsonRpcError common.Error, err error) {
+	select {
+	case <-rc.GetContext().Done():
+	case <-time.After(time.Second):
+	}
+	result = "done"
+	return
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WebSocketHandler{}, "Handle_")
+
+	wst := NewWebSocketTransport(s)
+	connected := make(chan *Connection, 1)
+	wst.OnConnect = func(c *Connection) {
+		connected <- c
+	}
+
+	httpServer := httptest.NewServer(wst)
+	defer httpServer.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	defer ws.Close()
+
+	c := <-connected
+	if len(wst.Connections()) != 1 || wst.GetConnection(c.Id) != c {
+		t.Errorf("connection was not registered")
+	}
+
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"1","method":"login","params":{"name":"lol"}}`))
+	if r, _ := ws.ReadMessage(); string(r) != `{"jsonrpc":"2.0","id":"1","result":"ok"}` {
+		t.Errorf("wrong login response %s", string(r))
+	}
+
+	// The slow request is answered last
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"slow","method":"greet","params":{"delay":100}}`))
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","method":"greet","params":{"delay":0}}`))
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"fast","method":"greet","params":{"delay":0}}`))
+
+	for _, expect := range []string{
+		`{"jsonrpc":"2.0","id":"fast","result":"hello lol"}`,
+		`{"jsonrpc":"2.0","id":"slow","result":"hello lol"}`,
+	} {
+		if r, _ := ws.ReadMessage(); string(r) != expect {
+			t.Errorf("wrong response %s", string(r))
+		}
+	}
+
+	// Server push
+	if err = wst.Broadcast("event", map[string]int{"x": 1}); err != nil {
+		t.Errorf("broadcast failed: %s", err.Error())
+	}
+
+	r, _ := ws.ReadMessage()
+	n := common.Request{}
+	if json.Unmarshal(r, &n) != nil || !n.IsNotification() || n.Method != "event" || string(n.Params) != `{"x":1}` {
+		t.Errorf("wrong notification %s", string(r))
+	}
+
+	_ = ws.Close()
+	select {
+	case <-c.Context().Done():
+	case <-time.After(time.Second):
+		t.Errorf("connection was not closed")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if len(wst.Connections()) != 0 {
+		t.Errorf("closed connection was not removed")
+	}
+}
+
+func TestHttpTransport_AddWebSocketEndpoint(t *testing.T) {
+	transport := NewHttpTransport("localhost:56666")
+
+	if _, err := transport.AddWebSocketEndpoint("/ws", nil); err == nil {
+		t.Errorf("nil server was accepted")
+	}
+
+	s := server.NewServer()
+	if _, err := transport.AddWebSocketEndpoint("/ws", s); err != nil {
+		t.Errorf("could not add websocket endpoint")
+	}
+
+	if _, err := transport.AddWebSocketEndpoint("/ws", s); err == nil {
+		t.Errorf("duplicate endpoint was accepted")
+	}
+}
+
+func TestHttpTransport_WebSocketStages(t *testing.T) {
+	transport := test_HttpTransport()
+
+	if _, err := transport.AddWebSocketEndpoint("/ws", server.NewServer(), WithPostServerStages(func(hrc *HttpRequestContext) bool { return true })); err == nil {
+		t.Errorf("post server stages were accepted")
+	}
+
+	// The transport stage rejects the handshakes without the token, the endpoint one rejects the rest
+	transport.AddPreServerStage(func(hrc *HttpRequestContext) bool {
+		if hrc.HttpRequest.Header.Get("X-Token") == "secret" {
+			return true
+		}
+		hrc.MakeErrorResponse(common.UnauthorizedError)
+		_ = hrc.RebuildRawResponse()
+		hrc.HttpStatus = http.StatusUnauthorized
+		return false
+	})
+	wst, err := transport.AddWebSocketEndpoint("/ws", server.NewServer(), WithPreServerStages(func(hrc *HttpRequestContext) bool {
+		return hrc.HttpRequest.Header.Get("X-Tenant") != "banned"
+	}))
+	if err != nil {
+		t.Fatalf("could not add the endpoint: %s", err.Error())
+	}
+
+	httpServer := httptest.NewServer(transport.Mux)
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws"
+
+	testData := []struct {
+		Header http.Header
+		Status int
+	}{
+		{http.Header{}, http.StatusUnauthorized},
+		{http.Header{"X-Token": {"secret"}, "X-Tenant": {"banned"}}, http.StatusForbidden},
+	}
+
+	for k, data := range testData {
+		if ws, err := websocket.Dial(url, data.Header, nil); err == nil {
+			_ = ws.Close()
+			t.Errorf("%d rejected handshake was upgraded", k)
+		}
+
+		r, _ := http.NewRequest(http.MethodGet, httpServer.URL+"/ws", nil)
+		r.Header = data.Header
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Errorf("%d request failed: %s", k, err.Error())
+			continue
+		}
+		_ = response.Body.Close()
+
+		if response.StatusCode != data.Status {
+			t.Errorf("%d got status %d", k, response.StatusCode)
+		}
+	}
+
+	if len(wst.Connections()) != 0 {
+		t.Errorf("rejected handshakes made connections")
+	}
+
+	ws, err := websocket.Dial(url, http.Header{"X-Token": {"secret"}}, nil)
+	if err != nil {
+		t.Fatalf("admitted handshake failed: %s", err.Error())
+	}
+	_ = ws.Close()
+}
+
+func TestWebSocketTransport_Origin(t *testing.T) {
+	wst := NewWebSocketTransport(server.NewServer())
+
+	httpServer := httptest.NewServer(wst)
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	if _, err := websocket.Dial(url, http.Header{"Origin": {"https://evil.com"}}, nil); err == nil {
+		t.Errorf("cross-origin handshake was accepted")
+	}
+
+	wst.CheckOrigin = websocket.AllowOrigins("https://evil.com")
+	ws, err := websocket.Dial(url, http.Header{"Origin": {"https://evil.com"}}, nil)
+	if err != nil {
+		t.Fatalf("allowed origin was rejected: %s", err.Error())
+	}
+	_ = ws.Close()
+}
+
+func TestWebSocketTransport_CancelWhenBusy(t *testing.T) {
+	s := server.NewServer()
+	s.AddHandler(test_WebSocketHandler{}, "Handle_")
+
+	wst := NewWebSocketTransport(s)
+	wst.MaxInFlight = 1
+
+	httpServer := httptest.NewServer(wst)
+	defer httpServer.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	defer ws.Close()
+
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","id":"w","method":"wait","params":{}}`))
+	time.Sleep(10 * time.Millisecond)
+	_ = ws.WriteMessage([]byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"w"}}`))
+
+	if r, _ := ws.ReadMessage(); string(r) != `{"jsonrpc":"2.0","id":"w","error":{"code":-32800,"message":"Request cancelled"}}` {
+		t.Errorf("busy connection did not handle the cancellation: %s", string(r))
+	}
+}
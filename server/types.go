@@ -13,12 +13,18 @@ import (
 type Handler interface{}
 
 // A struct for keeping JSON-RPC method descriptions
+// WithContext is set for methods that take *common.RequestContext before the params
+// Safe is set for read-only methods that transports may expose to cacheable requests (see MarkSafe)
+// Scopes are required from the caller by the authorization stages (see RequireScopes)
 type JsonRpcMethod struct {
-	Receiver   Handler
-	Name       string
-	Method     reflect.Method
-	ParamsType reflect.Type
-	ResultType reflect.Type
+	Receiver    Handler
+	Name        string
+	Method      reflect.Method
+	ParamsType  reflect.Type
+	ResultType  reflect.Type
+	WithContext bool
+	Safe        bool
+	Scopes      []string
 }
 
 // A Server for actual handling of requests
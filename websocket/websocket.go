@@ -0,0 +1,465 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 implementation: enough to exchange JSON-RPC messages
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// The magic value from RFC 6455 used to compute Sec-WebSocket-Accept
+const acceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Default limit for the size of a single (reassembled) message
+const DefaultMaxMessageSize = 16 << 20
+
+// Control frames can not carry more than this
+const maxControlPayload = 125
+
+// A WebSocket connection
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// Client connections mask outgoing frames, server ones do not
+	client bool
+	// Messages larger than this are rejected, zero means DefaultMaxMessageSize
+	MaxMessageSize int64
+	wmu            sync.Mutex
+	closeOnce      sync.Once
+}
+
+// The error returned by ReadMessage when the peer closes the connection
+var ErrClosed = fmt.Errorf("websocket connection closed")
+
+// Compute Sec-WebSocket-Accept value for the given key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGuid))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Check if a comma-separated header contains the token
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Check if the HTTP request asks for a WebSocket upgrade
+func IsUpgradeRequest(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Checks the Origin of a handshake request, see SameOrigin and AllowOrigins
+type OriginChecker func(r *http.Request) bool
+
+// Accept the handshakes without an Origin (those come from non-browser clients)
+// and the ones whose Origin host is the host of the request
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Accept the same-origin handshakes and the ones from the listed origins
+// An origin is either exact (https://app.example.com) or a path.Match pattern (https://*.example.com)
+func AllowOrigins(origins ...string) OriginChecker {
+	return func(r *http.Request) bool {
+		if SameOrigin(r) {
+			return true
+		}
+
+		origin := r.Header.Get("Origin")
+		for _, allowed := range origins {
+			if allowed == origin {
+				return true
+			}
+			if matched, err := path.Match(allowed, origin); err == nil && matched {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Upgrade a server HTTP connection to the WebSocket protocol
+// Cross-origin handshakes are denied (see SameOrigin), use UpgradeChecked to allow them
+// An error response is written if the request is not a proper handshake
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return UpgradeChecked(w, r, SameOrigin)
+}
+
+// Upgrade a server HTTP connection to the WebSocket protocol accepting the origins the checker accepts
+// A nil checker means SameOrigin
+func UpgradeChecked(w http.ResponseWriter, r *http.Request, checkOrigin OriginChecker) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgradeRequest(r) {
+		http.Error(w, "websocket handshake expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing websocket key")
+	}
+
+	if checkOrigin == nil {
+		checkOrigin = SameOrigin
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("origin %s not allowed", r.Header.Get("Origin"))
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("connection can not be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+
+	if _, err = conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+// Open a client WebSocket connection to a ws:// or wss:// url
+func Dial(rawUrl string, header http.Header, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.DialTimeout("tcp", host, 30*time.Second)
+	case "wss":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = u.Hostname()
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported websocket url scheme %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, values := range header {
+		for _, v := range values {
+			request.Header.Add(k, v)
+		}
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err = request.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	response, err := http.ReadResponse(br, request)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket handshake failed: %s", response.Status)
+	}
+
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+// Get the underlying network connection
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Read a whole (possibly fragmented) text or binary message
+// Control frames are handled transparently
+func (c *Conn) ReadMessage() ([]byte, error) {
+	limit := c.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	var message []byte
+	started := false
+
+	for {
+		fin, opcode, payload, err := c.readFrame(limit)
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err = c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			_ = c.writeFrame(opClose, payload)
+			_ = c.conn.Close()
+			return nil, ErrClosed
+		case opText, opBinary:
+			if started {
+				return nil, c.fail("unexpected data frame inside a fragmented message")
+			}
+			started = true
+			message = payload
+		case opContinuation:
+			if !started {
+				return nil, c.fail("unexpected continuation frame")
+			}
+			if int64(len(message)+len(payload)) > limit {
+				return nil, c.fail("message too large")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, c.fail("unknown opcode")
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// Read a single frame
+func (c *Conn) readFrame(limit int64) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	// Clients must mask their frames, servers must not
+	if masked == c.client {
+		err = c.fail("wrong frame masking")
+		return
+	}
+
+	if opcode >= opClose {
+		if !fin {
+			err = c.fail("fragmented control frame")
+			return
+		}
+		if length > maxControlPayload {
+			err = c.fail("control frame too large")
+			return
+		}
+	}
+
+	if length < 0 || length > limit {
+		err = c.fail("message too large")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return
+}
+
+// Write a message as a single text frame
+// Safe for concurrent use
+func (c *Conn) WriteMessage(message []byte) error {
+	return c.writeFrame(opText, message)
+}
+
+// Send a ping frame
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Write a single final frame
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, ext[:]...)
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close the connection with a protocol error
+func (c *Conn) fail(reason string) error {
+	payload := []byte{0x03, 0xea} // 1002 protocol error
+	_ = c.writeFrame(opClose, append(payload, reason...))
+	_ = c.conn.Close()
+
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// Send a close frame and close the connection
+func (c *Conn) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		_ = c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000 normal closure
+		err = c.conn.Close()
+	})
+
+	return err
+}
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455
+	if acceptKey("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong accept key")
+	}
+}
+
+func TestDial(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			m, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(m); err != nil {
+				return
+			}
+		}
+	}))
+	defer httpServer.Close()
+
+	conn, err := Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil, nil)
+	if err != nil {
+		t.Fatalf("could not dial: %s", err.Error())
+	}
+	defer conn.Close()
+
+	for _, size := range []int{0, 10, 200, 70000} {
+		m := strings.Repeat("x", size)
+
+		if err = conn.WriteMessage([]byte(m)); err != nil {
+			t.Errorf("could not write message: %s", err.Error())
+		}
+
+		if err = conn.Ping(); err != nil {
+			t.Errorf("could not ping: %s", err.Error())
+		}
+
+		r, err := conn.ReadMessage()
+		if err != nil {
+			t.Errorf("could not read message: %s", err.Error())
+		} else if string(r) != m {
+			t.Errorf("message of size %d was not echoed properly", size)
+		}
+	}
+
+	conn.MaxMessageSize = 10
+	_ = conn.WriteMessage([]byte(strings.Repeat("x", 11)))
+	if _, err = conn.ReadMessage(); err == nil {
+		t.Errorf("message over the limit was read")
+	}
+}
+
+func TestUpgrade_Error(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = Upgrade(w, r)
+	}))
+	defer httpServer.Close()
+
+	r, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatalf("http get failed: %s", err.Error())
+	}
+	if r.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain http request was not rejected")
+	}
+
+	if _, err = Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/", http.Header{"Sec-WebSocket-Version": {"12"}}, nil); err != nil {
+		t.Errorf("handshake version was not overridden")
+	}
+}
+
+func TestUpgrade_Origin(t *testing.T) {
+	handler := func(check OriginChecker) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if conn, err := UpgradeChecked(w, r, check); err == nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer plain.Close()
+
+	allowing := httptest.NewServer(handler(AllowOrigins("https://app.example.com", "https://*.trusted.com")))
+	defer allowing.Close()
+
+	testData := []struct {
+		Server *httptest.Server
+		Origin string
+		Ok     bool
+	}{
+		{plain, "", true},
+		{plain, plain.URL, true},
+		{plain, "https://evil.com", false},
+		{plain, "null", false},
+		{allowing, "https://app.example.com", true},
+		{allowing, "https://api.trusted.com", true},
+		{allowing, allowing.URL, true},
+		{allowing, "https://evil.com", false},
+	}
+
+	for k, data := range testData {
+		header := http.Header{}
+		if data.Origin != "" {
+			header.Set("Origin", data.Origin)
+		}
+
+		conn, err := Dial("ws"+strings.TrimPrefix(data.Server.URL, "http"), header, nil)
+		if (err == nil) != data.Ok {
+			t.Errorf("%d origin %s: unexpected result %v", k, data.Origin, err)
+		}
+		if err == nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+func TestConn_ProtocolErrors(t *testing.T) {
+	testData := []struct {
+		Name   string
+		Client bool
+		Frame  []byte
+	}{
+		{"unmasked client frame", false, []byte{0x81, 0x02, 'h', 'i'}},
+		{"masked server frame", true, []byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'}},
+		{"fragmented ping", false, []byte{0x09, 0x80, 0, 0, 0, 0}},
+		{"large ping", false, append([]byte{0x89, 0x80 | 126, 0, 126, 0, 0, 0, 0}, make([]byte, 126)...)},
+	}
+
+	for k, data := range testData {
+		local, remote := net.Pipe()
+		conn := &Conn{conn: local, br: bufio.NewReader(local), client: data.Client}
+
+		go func() {
+			_, _ = remote.Write(data.Frame)
+		}()
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, remote)
+		}()
+
+		if _, err := conn.ReadMessage(); err == nil || !strings.Contains(err.Error(), "protocol error") {
+			t.Errorf("%d %s was accepted: %v", k, data.Name, err)
+		}
+
+		_ = remote.Close()
+	}
+}